@@ -221,6 +221,10 @@ func (service *DispatcherService) messageLoop() {
 					service.handleSyncPositionYawOnClients(dcp, pkt)
 				case proto.MT_CALL_ENTITY_METHOD:
 					service.handleCallEntityMethod(dcp, pkt)
+				case proto.MT_CALL_ENTITY_METHOD_WITH_REPLY:
+					service.handleCallEntityMethodWithReply(dcp, pkt)
+				case proto.MT_CALL_ENTITY_METHOD_REPLY:
+					service.handleCallEntityMethodReply(dcp, pkt)
 				case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
 					service.handleCallEntityMethodFromClient(dcp, pkt)
 				case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE:
@@ -253,6 +257,8 @@ func (service *DispatcherService) messageLoop() {
 					service.handleCancelMigrate(dcp, pkt)
 				case proto.MT_KVREG_REGISTER:
 					service.handleKvregRegister(dcp, pkt)
+				case proto.MT_NOTIFY_KVDB_CHANGE:
+					service.broadcastToGames(pkt)
 				case proto.MT_SET_GAME_ID:
 					// this is a game server
 					service.handleSetGameID(dcp, pkt)
@@ -756,6 +762,31 @@ func (service *DispatcherService) handleCallEntityMethod(dcp *dispatcherClientPr
 	}
 }
 
+func (service *DispatcherService) handleCallEntityMethodWithReply(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	entityID := pkt.ReadEntityID()
+
+	if consts.DEBUG_PACKETS {
+		gwlog.Debugf("%s.handleCallEntityMethodWithReply: dcp=%s, entityID=%s", service, dcp, entityID)
+	}
+
+	entityDispatchInfo := service.entityDispatchInfos[entityID]
+	if entityDispatchInfo != nil {
+		entityDispatchInfo.dispatchPacket(pkt)
+	} else {
+		// reply the error to caller immediately, so that caller does not have to wait for timeout
+		callerGameID := pkt.ReadUint16()
+		callID := pkt.ReadUint32()
+		method := pkt.ReadVarStr()
+		gwlog.Warnf("%s: entity %s is called by other entity with reply, but dispatch info is not found", service, entityID)
+		dcp.SendCallEntityMethodReply(callerGameID, callID, fmt.Sprintf("entity %s is not found while calling %s", entityID, method), nil)
+	}
+}
+
+func (service *DispatcherService) handleCallEntityMethodReply(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	callerGameID := pkt.ReadUint16()
+	service.dispatchPacketToGame(callerGameID, pkt)
+}
+
 func (service *DispatcherService) handleCallNilSpaces(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	// send the packet to all games
 	exceptGameID := pkt.ReadUint16()
@@ -107,6 +107,10 @@ func (gs *GameService) serveRoutine() {
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				gs.HandleCallEntityMethod(eid, method, args, "")
+			case proto.MT_CALL_ENTITY_METHOD_WITH_REPLY:
+				gs.HandleCallEntityMethodWithReply(pkt)
+			case proto.MT_CALL_ENTITY_METHOD_REPLY:
+				gs.HandleCallEntityMethodReply(pkt)
 			case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE_ACK:
 				gs.HandleQuerySpaceGameIDForMigrateAck(pkt)
 			case proto.MT_MIGRATE_REQUEST_ACK:
@@ -116,8 +120,9 @@ func (gs *GameService) serveRoutine() {
 			case proto.MT_NOTIFY_CLIENT_CONNECTED:
 				clientid := pkt.ReadClientID()
 				eid := pkt.ReadEntityID()
+				identity := pkt.ReadVarStr()
 				gid := pkt.ReadUint16()
-				gs.HandleNotifyClientConnected(clientid, eid, gid)
+				gs.HandleNotifyClientConnected(clientid, eid, gid, identity)
 			case proto.MT_NOTIFY_CLIENT_DISCONNECTED:
 				eid := pkt.ReadEntityID()
 				clientid := pkt.ReadClientID()
@@ -141,6 +146,10 @@ func (gs *GameService) serveRoutine() {
 				gs.HandleCallNilSpaces(method, args)
 			case proto.MT_KVREG_REGISTER:
 				gs.HandleKvregRegister(pkt)
+			case proto.MT_NOTIFY_KVDB_CHANGE:
+				key := pkt.ReadVarStr()
+				val := pkt.ReadVarStr()
+				kvdb.HandleNotifyKVDBChange(key, val)
 			case proto.MT_NOTIFY_GATE_DISCONNECTED:
 				gateid := pkt.ReadUint16()
 				gs.HandleGateDisconnected(gateid)
@@ -180,6 +189,7 @@ func (gs *GameService) serveRoutine() {
 		// after handling packets or firing timers, check the posted functions
 		post.Tick()
 		if isTick {
+			entity.FlushAttrChanges()
 			now := time.Now()
 			if !gs.nextCollectEntitySyncInfosTime.After(now) {
 				gs.nextCollectEntitySyncInfosTime = now.Add(gs.positionSyncInterval)
@@ -413,8 +423,28 @@ func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method s
 	entity.OnCall(entityID, method, args, clientid)
 }
 
-func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, bootEid common.EntityID, gateid uint16) {
-	client := entity.MakeGameClient(clientid, gateid)
+func (gs *GameService) HandleCallEntityMethodWithReply(pkt *netutil.Packet) {
+	eid := pkt.ReadEntityID()
+	callerGameID := pkt.ReadUint16()
+	callID := pkt.ReadUint32()
+	method := pkt.ReadVarStr()
+	args := pkt.ReadArgs()
+	if consts.DEBUG_PACKETS {
+		gwlog.Debugf("%s.HandleCallEntityMethodWithReply: %s.%s(%v), caller game=%d, call=%d", gs, eid, method, args, callerGameID, callID)
+	}
+	entity.OnCallWithReply(eid, method, args, callerGameID, callID)
+}
+
+func (gs *GameService) HandleCallEntityMethodReply(pkt *netutil.Packet) {
+	_ = pkt.ReadUint16() // caller gameid
+	callID := pkt.ReadUint32()
+	errmsg := pkt.ReadVarStr()
+	rets := pkt.ReadArgs()
+	entity.OnCallReply(callID, errmsg, rets)
+}
+
+func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, bootEid common.EntityID, gateid uint16, identity string) {
+	client := entity.MakeGameClient(clientid, gateid, identity)
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleNotifyClientConnected: %s", gs, client)
 	}
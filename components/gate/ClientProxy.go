@@ -33,6 +33,9 @@ type ClientProxy struct {
 	clientSyncInfo clientSyncInfo
 	heartbeatTime  time.Time
 	ownerEntityID  common.EntityID // owner entity's ID
+	authenticated  bool            // the boot entity is created only after the client is authenticated
+	authenticating bool
+	clientSession
 }
 
 func newClientProxy(_conn net.Conn, cfg *config.GateConfig) *ClientProxy {
@@ -74,3 +77,31 @@ func (cp *ClientProxy) serve() {
 		gwlog.Panic(err)
 	}
 }
+
+// SendAuthResultOnClient sends MT_AUTH_RESULT_ON_CLIENT message
+func (cp *ClientProxy) SendAuthResultOnClient(ok bool, errmsg string) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_AUTH_RESULT_ON_CLIENT)
+	packet.AppendBool(ok)
+	packet.AppendVarStr(errmsg)
+	cp.SendPacket(packet)
+	packet.Release()
+}
+
+// SendSetSessionTokenOnClient sends MT_SET_SESSION_TOKEN_ON_CLIENT message
+func (cp *ClientProxy) SendSetSessionTokenOnClient(token string) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_SET_SESSION_TOKEN_ON_CLIENT)
+	packet.AppendVarStr(token)
+	cp.SendPacket(packet)
+	packet.Release()
+}
+
+// SendResumeSessionResultOnClient sends MT_RESUME_SESSION_RESULT_ON_CLIENT message
+func (cp *ClientProxy) SendResumeSessionResultOnClient(ok bool) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_RESUME_SESSION_RESULT_ON_CLIENT)
+	packet.AppendBool(ok)
+	cp.SendPacket(packet)
+	packet.Release()
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gateauth"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/netutil"
@@ -45,6 +46,11 @@ type GateService struct {
 	tlsConfig               *tls.Config
 	checkHeartbeatsInterval time.Duration
 	positionSyncInterval    time.Duration
+	authVerifier            gateauth.Verifier
+	authTimeout             time.Duration
+	sessionGracePeriod      time.Duration
+	sessionReplayBufferSize int
+	sessions                map[string]*ClientProxy // client proxies with resumable sessions by session token
 }
 
 func newGateService() *GateService {
@@ -65,6 +71,7 @@ func newGateService() *GateService {
 		filterTrees:                 map[string]*_FilterTree{},
 		pendingSyncPackets:          pendingSyncPackets,
 		terminated:                  xnsyncutil.NewOneTimeCond(),
+		sessions:                    map[string]*ClientProxy{},
 	}
 }
 
@@ -76,6 +83,17 @@ func (gs *GateService) run() {
 		gs.setupTLSConfig(cfg)
 	}
 
+	authVerifier, err := gateauth.NewVerifier(cfg)
+	if err != nil {
+		gwlog.Fatalf("%s: create auth verifier failed: %s", gs, err)
+	}
+	gs.authVerifier = authVerifier
+	gs.authTimeout = time.Second * time.Duration(cfg.AuthTimeout)
+	gwlog.Infof("%s: auth type = %q, auth timeout = %s", gs, cfg.AuthType, gs.authTimeout)
+	gs.sessionGracePeriod = time.Second * time.Duration(cfg.SessionGracePeriod)
+	gs.sessionReplayBufferSize = cfg.SessionReplayBufferSize
+	gwlog.Infof("%s: session grace period = %s, replay buffer size = %d", gs, gs.sessionGracePeriod, gs.sessionReplayBufferSize)
+
 	gs.listenAddr = cfg.ListenAddr
 	go netutil.ServeTCPForever(gs.listenAddr, gs)
 	go gs.serveKCP(gs.listenAddr)
@@ -198,6 +216,9 @@ func (gs *GateService) checkClientHeartbeats() {
 	now := time.Now()
 
 	for _, cp := range gs.clientProxies { // close all connected clients when terminating
+		if cp.detached {
+			continue // connection is already closed, and session expires by grace timer
+		}
 		if cp.heartbeatTime.Add(gs.checkHeartbeatsInterval).Before(now) {
 			// 10 seconds no heartbeat, close it...
 			gwlog.Infof("Connection %s timeout ...", cp)
@@ -208,13 +229,97 @@ func (gs *GateService) checkClientHeartbeats() {
 
 func (gs *GateService) onNewClientProxy(cp *ClientProxy) {
 	gs.clientProxies[cp.clientid] = cp
+	if !gs.needHandshake() {
+		gs.notifyClientConnected(cp, "")
+		return
+	}
+
+	// client should be authenticated or resume session in limited time, otherwise the connection is closed
+	time.AfterFunc(gs.authTimeout, func() {
+		post.Post(func() {
+			if !cp.authenticated && gs.clientProxies[cp.clientid] == cp {
+				gwlog.Warnf("%s: %s handshake timeout", gs, cp)
+				cp.Close()
+			}
+		})
+	})
+}
+
+// needHandshake returns if clients should authenticate or start sessions before the boot entity is created
+func (gs *GateService) needHandshake() bool {
+	return gs.authVerifier != nil || gs.sessionGracePeriod > 0
+}
+
+// notifyClientConnected announces the client proxy to the dispatcher, so the boot entity is created for the client
+func (gs *GateService) notifyClientConnected(cp *ClientProxy, identity string) {
+	cp.authenticated = true
 	bootEntityID := common.GenEntityID() // generate boot entity ID in the gate
 	cp.ownerEntityID = bootEntityID
-	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientConnected(cp.clientid, bootEntityID)
+	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientConnected(cp.clientid, bootEntityID, identity)
+	if gs.sessionGracePeriod > 0 {
+		gs.startSession(cp)
+	}
+}
+
+func (gs *GateService) handleAuthFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	ticket := pkt.ReadVarStr()
+	if cp.authenticated || cp.authenticating {
+		gwlog.Warnf("%s: %s is already authenticated or authenticating", gs, cp)
+		return
+	}
+
+	cp.authenticating = true
+	verifier := gs.authVerifier
+	go func() {
+		identity, err := verifier.Verify(ticket) // verifier might block, so verify in another goroutine
+		post.Post(func() {
+			gs.onClientAuthResult(cp, identity, err)
+		})
+	}()
+}
+
+func (gs *GateService) onClientAuthResult(cp *ClientProxy, identity string, err error) {
+	cp.authenticating = false
+	if gs.clientProxies[cp.clientid] != cp || cp.authenticated {
+		// client proxy is already closed or resumed another session
+		return
+	}
+
+	if err != nil {
+		gwlog.Warnf("%s: %s authentication failed: %s", gs, cp, err)
+		cp.SendAuthResultOnClient(false, err.Error())
+		cp.Close()
+		return
+	}
+
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: %s is authenticated as %s", gs, cp, identity)
+	}
+	cp.SendAuthResultOnClient(true, "")
+	gs.notifyClientConnected(cp, identity)
 }
 
 func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
+	if cp.replaced {
+		// the session is already resumed by another client proxy
+		return
+	}
+
+	if cp.sessionToken != "" && !gs.terminating.Load() {
+		// keep the session for the client to resume in grace period
+		gs.detachSession(cp)
+		return
+	}
+
+	gs.closeClientProxy(cp)
+}
+
+func (gs *GateService) closeClientProxy(cp *ClientProxy) {
 	delete(gs.clientProxies, cp.clientid)
+	if cp.sessionToken != "" {
+		delete(gs.sessions, cp.sessionToken)
+		cp.releaseReplayBuffer()
+	}
 
 	for key, val := range cp.filterProps {
 		ft := gs.filterTrees[key]
@@ -226,7 +331,9 @@ func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 		}
 	}
 
-	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
+	if cp.authenticated { // boot entity is not created for unauthenticated clients
+		dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
+	}
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debugf("%s.onClientProxyClose: client %s disconnected", gs, cp)
 	}
@@ -240,7 +347,21 @@ func (gs *GateService) handleClientProxyPacket(_pkt *pktconn.Packet) {
 
 	msgtype := proto.MsgType(pkt.ReadUint16())
 
+	if !cp.authenticated && gs.needHandshake() && msgtype != proto.MT_AUTH_FROM_CLIENT && msgtype != proto.MT_RESUME_SESSION_FROM_CLIENT && msgtype != proto.MT_HEARTBEAT_FROM_CLIENT {
+		gwlog.Warnf("%s: %s sends message %d before authenticated", gs, cp, msgtype)
+		cp.Close()
+		return
+	}
+
 	switch msgtype {
+	case proto.MT_AUTH_FROM_CLIENT:
+		if gs.authVerifier != nil {
+			gs.handleAuthFromClient(cp, pkt)
+		}
+	case proto.MT_RESUME_SESSION_FROM_CLIENT:
+		if gs.sessionGracePeriod > 0 {
+			gs.handleResumeSessionFromClient(cp, pkt)
+		}
 	case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
 		gs.handleSyncPositionYawFromClient(pkt)
 	case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// clientSession is the resumable session of client proxy
+//
+// Session messages sent to the client are numbered and the latest ones are kept in replay buffer (see
+// proto.IsSessionMsgType for which messages are counted). When client reconnects in grace period, it resumes the
+// session with the number of session messages it has received, and the missed messages are replayed to the client.
+type clientSession struct {
+	sessionToken string
+	sendSeq      uint32            // number of session messages sent to client in this session
+	replayBuffer []*netutil.Packet // the latest session messages sent to client, the last one is numbered sendSeq
+	detached     bool              // connection is closed, but session is kept in grace period
+	replaced     bool              // session is resumed by another client proxy
+	graceTimer   *time.Timer
+}
+
+func genSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		gwlog.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SendPacket sends packet to client, and numbers and keeps session messages for replaying if session is enabled
+//
+// All messages to client should be sent by SendPacket, so that session messages are numbered in the order they are sent.
+func (cp *ClientProxy) SendPacket(packet *netutil.Packet) {
+	msgtype := proto.MsgType(netutil.NETWORK_ENDIAN.Uint16(packet.Payload()))
+	if cp.sessionToken != "" && proto.IsSessionMsgType(msgtype) {
+		packet.Retain()
+		cp.replayBuffer = append(cp.replayBuffer, packet)
+		cp.sendSeq += 1
+		if len(cp.replayBuffer) > gateService.sessionReplayBufferSize {
+			cp.replayBuffer[0].Release()
+			cp.replayBuffer[0] = nil
+			cp.replayBuffer = cp.replayBuffer[1:]
+		}
+	}
+
+	if !cp.detached {
+		cp.GoWorldConnection.SendPacket(packet)
+	}
+}
+
+// canReplayFrom checks if all messages after recvSeq are still in replay buffer
+func (cp *ClientProxy) canReplayFrom(recvSeq uint32) bool {
+	return recvSeq <= cp.sendSeq && cp.sendSeq-recvSeq <= uint32(len(cp.replayBuffer))
+}
+
+func (cp *ClientProxy) replayFrom(recvSeq uint32) {
+	for _, packet := range cp.replayBuffer[len(cp.replayBuffer)-int(cp.sendSeq-recvSeq):] {
+		cp.GoWorldConnection.SendPacket(packet)
+	}
+}
+
+func (cp *ClientProxy) releaseReplayBuffer() {
+	for _, packet := range cp.replayBuffer {
+		packet.Release()
+	}
+	cp.replayBuffer = nil
+}
+
+func (gs *GateService) startSession(cp *ClientProxy) {
+	cp.sessionToken = genSessionToken()
+	gs.sessions[cp.sessionToken] = cp
+	cp.SendSetSessionTokenOnClient(cp.sessionToken)
+}
+
+// detachSession keeps the session of disconnected client proxy until grace period expires
+func (gs *GateService) detachSession(cp *ClientProxy) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: %s is detached, session is kept for %s", gs, cp, gs.sessionGracePeriod)
+	}
+
+	cp.detached = true
+	cp.graceTimer = time.AfterFunc(gs.sessionGracePeriod, func() {
+		post.Post(func() {
+			if cp.detached && !cp.replaced {
+				gwlog.Infof("%s: session of %s expired", gs, cp)
+				gs.closeClientProxy(cp)
+			}
+		})
+	})
+}
+
+func (gs *GateService) handleResumeSessionFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	token := pkt.ReadVarStr()
+	recvSeq := pkt.ReadUint32()
+	if cp.authenticated {
+		gwlog.Warnf("%s: %s is already in session", gs, cp)
+		return
+	}
+
+	if token == "" {
+		// start a new session, or wait for authentication if auth is enabled
+		if gs.authVerifier == nil {
+			gs.notifyClientConnected(cp, "")
+		}
+		return
+	}
+
+	old := gs.sessions[token]
+	if old == nil || !old.canReplayFrom(recvSeq) {
+		gwlog.Warnf("%s: %s resume session failed: session not found or missed messages are dropped", gs, cp)
+		cp.SendResumeSessionResultOnClient(false)
+		return
+	}
+
+	gs.resumeSession(cp, old, recvSeq)
+}
+
+// resumeSession moves the session of the old client proxy to the new client proxy, and replays missed messages
+func (gs *GateService) resumeSession(cp *ClientProxy, old *ClientProxy, recvSeq uint32) {
+	delete(gs.clientProxies, cp.clientid)
+	cp.clientid = old.clientid
+	cp.ownerEntityID = old.ownerEntityID
+	cp.filterProps = old.filterProps
+	cp.authenticated = true
+	cp.sessionToken = old.sessionToken
+	cp.sendSeq = old.sendSeq
+	cp.replayBuffer = old.replayBuffer
+
+	for key, val := range cp.filterProps {
+		if ft := gs.filterTrees[key]; ft != nil {
+			ft.Remove(old, val)
+			ft.Insert(cp, val)
+		}
+	}
+	gs.clientProxies[cp.clientid] = cp
+	gs.sessions[cp.sessionToken] = cp
+
+	old.replaced = true
+	old.detached = true
+	old.replayBuffer = nil
+	if old.graceTimer != nil {
+		old.graceTimer.Stop()
+	}
+	old.Close() // old connection might be still alive if client reconnects before it is closed
+
+	gwlog.Infof("%s: %s resumed session, replaying %d messages", gs, cp, cp.sendSeq-recvSeq)
+	cp.SendResumeSessionResultOnClient(true)
+	cp.replayFrom(recvSeq)
+}
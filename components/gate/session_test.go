@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/pktconn"
+)
+
+const testMsgType = proto.MT_CALL_ENTITY_METHOD_ON_CLIENT
+
+func newTestGateService(replayBufferSize int, gracePeriod time.Duration) *GateService {
+	gateService = &GateService{
+		clientProxies:           map[common.ClientID]*ClientProxy{},
+		filterTrees:             map[string]*_FilterTree{},
+		sessions:                map[string]*ClientProxy{},
+		checkHeartbeatsInterval: time.Second,
+		sessionGracePeriod:      gracePeriod,
+		sessionReplayBufferSize: replayBufferSize,
+	}
+	return gateService
+}
+
+// newTestClientProxy creates a client proxy connected to the test client, which receives packets from recv
+func newTestClientProxy(t *testing.T, gs *GateService) (cp *ClientProxy, recv chan *pktconn.Packet) {
+	serverConn, clientConn := net.Pipe()
+	cp = newClientProxy(serverConn, &config.GateConfig{})
+	client := netutil.NewPacketConnection(netutil.NetConn{Conn: clientConn}, nil)
+	recv = make(chan *pktconn.Packet, 100)
+	go client.RecvChan(recv)
+	t.Cleanup(func() {
+		cp.Close()
+		client.Close()
+	})
+	gs.clientProxies[cp.clientid] = cp
+	return
+}
+
+func sendTestPackets(cp *ClientProxy, from, to uint32) {
+	for i := from; i <= to; i++ {
+		packet := netutil.NewPacket()
+		packet.AppendUint16(testMsgType)
+		packet.AppendUint32(i)
+		cp.SendPacket(packet)
+		packet.Release()
+	}
+}
+
+func recvTestPacket(t *testing.T, recv chan *pktconn.Packet) *netutil.Packet {
+	select {
+	case pkt := <-recv:
+		return (*netutil.Packet)(pkt)
+	case <-time.After(time.Second):
+		t.Fatalf("packet not received")
+		return nil
+	}
+}
+
+func newResumeSessionPacket(token string, recvSeq uint32) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendVarStr(token)
+	packet.AppendUint32(recvSeq)
+	return packet
+}
+
+func TestResumeSession(t *testing.T) {
+	gs := newTestGateService(10, time.Minute)
+	old, oldRecv := newTestClientProxy(t, gs)
+	gs.startSession(old)
+	old.authenticated = true
+	if pkt := recvTestPacket(t, oldRecv); pkt.ReadUint16() != proto.MT_SET_SESSION_TOKEN_ON_CLIENT || pkt.ReadVarStr() != old.sessionToken {
+		t.Fatalf("session token should be sent to client")
+	}
+
+	sendTestPackets(old, 1, 5)
+	gs.detachSession(old)
+	sendTestPackets(old, 6, 7) // sent while detached
+
+	// resume with invalid token
+	cp, recv := newTestClientProxy(t, gs)
+	gs.handleResumeSessionFromClient(cp, newResumeSessionPacket("invalid", 3))
+	if pkt := recvTestPacket(t, recv); pkt.ReadUint16() != proto.MT_RESUME_SESSION_RESULT_ON_CLIENT || pkt.ReadBool() {
+		t.Fatalf("resume with invalid token should fail")
+	}
+	if cp.authenticated || gs.clientProxies[old.clientid] != old {
+		t.Fatalf("session should not be resumed with invalid token")
+	}
+
+	// resume with valid token, messages after recvSeq are replayed
+	gs.handleResumeSessionFromClient(cp, newResumeSessionPacket(old.sessionToken, 3))
+	if pkt := recvTestPacket(t, recv); pkt.ReadUint16() != proto.MT_RESUME_SESSION_RESULT_ON_CLIENT || !pkt.ReadBool() {
+		t.Fatalf("resume with valid token should succeed")
+	}
+	for i := uint32(4); i <= 7; i++ {
+		if pkt := recvTestPacket(t, recv); pkt.ReadUint16() != testMsgType || pkt.ReadUint32() != i {
+			t.Fatalf("message %d should be replayed", i)
+		}
+	}
+	if !cp.authenticated || cp.clientid != old.clientid || gs.clientProxies[cp.clientid] != cp || gs.sessions[cp.sessionToken] != cp {
+		t.Fatalf("session should be moved to the new client proxy")
+	}
+	if !old.replaced || !old.IsClosed() {
+		t.Fatalf("old client proxy should be replaced and closed")
+	}
+}
+
+func TestCanReplayFromAfterOverflow(t *testing.T) {
+	gs := newTestGateService(3, time.Minute)
+	cp, _ := newTestClientProxy(t, gs)
+	cp.sessionToken = genSessionToken()
+	cp.detached = true
+
+	sendTestPackets(cp, 1, 5)
+	if len(cp.replayBuffer) != 3 {
+		t.Fatalf("replay buffer should keep the latest 3 messages, but has %d", len(cp.replayBuffer))
+	}
+	for recvSeq, ok := range map[uint32]bool{0: false, 1: false, 2: true, 4: true, 5: true, 6: false} {
+		if cp.canReplayFrom(recvSeq) != ok {
+			t.Errorf("canReplayFrom(%d) should be %v", recvSeq, ok)
+		}
+	}
+}
+
+func TestSessionGraceExpiry(t *testing.T) {
+	gs := newTestGateService(10, 10*time.Millisecond)
+	cp, _ := newTestClientProxy(t, gs)
+	cp.sessionToken = genSessionToken()
+	gs.sessions[cp.sessionToken] = cp
+	sendTestPackets(cp, 1, 3)
+
+	gs.detachSession(cp)
+	cp.heartbeatTime = time.Now().Add(-time.Minute)
+	gs.checkClientHeartbeats()
+	if cp.IsClosed() {
+		t.Fatalf("detached client proxy should not be checked by heartbeats")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	post.Tick()
+	if gs.clientProxies[cp.clientid] != nil || gs.sessions[cp.sessionToken] != nil || cp.replayBuffer != nil {
+		t.Fatalf("client proxy should be closed when grace period expires")
+	}
+}
+
+// testSessionClient counts received session messages like real clients
+type testSessionClient struct {
+	token   string
+	recvSeq uint32
+}
+
+func (c *testSessionClient) recv(t *testing.T, recv chan *pktconn.Packet) (uint16, *netutil.Packet) {
+	pkt := recvTestPacket(t, recv)
+	msgtype := pkt.ReadUint16()
+	if msgtype == proto.MT_SET_SESSION_TOKEN_ON_CLIENT {
+		c.token = pkt.ReadVarStr()
+		c.recvSeq = 0
+	} else if c.token != "" && proto.IsSessionMsgType(proto.MsgType(msgtype)) {
+		c.recvSeq++
+	}
+	return msgtype, pkt
+}
+
+func sendTestSyncPackets(cp *ClientProxy, n int) {
+	for i := 0; i < n; i++ {
+		packet := netutil.NewPacket()
+		packet.AppendUint16(proto.MT_SYNC_POSITION_YAW_ON_CLIENTS)
+		packet.AppendBytes(make([]byte, common.ENTITYID_LENGTH+proto.SYNC_INFO_SIZE_PER_ENTITY))
+		cp.SendPacket(packet)
+		packet.Release()
+	}
+}
+
+func TestResumeSessionByClientCount(t *testing.T) {
+	gs := newTestGateService(3, time.Minute)
+	old, oldRecv := newTestClientProxy(t, gs)
+	client := &testSessionClient{}
+	gs.startSession(old)
+	old.authenticated = true
+
+	// position syncs are neither counted nor buffered, so they do not overflow the replay buffer
+	sendTestPackets(old, 1, 2)
+	sendTestSyncPackets(old, 10)
+	for i := 0; i < 13; i++ {
+		client.recv(t, oldRecv)
+	}
+	if client.token != old.sessionToken || client.recvSeq != 2 || old.sendSeq != 2 {
+		t.Fatalf("client counts %d messages, but gate sends %d", client.recvSeq, old.sendSeq)
+	}
+
+	gs.detachSession(old)
+	sendTestPackets(old, 3, 5)
+	sendTestSyncPackets(old, 10)
+
+	cp, recv := newTestClientProxy(t, gs)
+	gs.handleResumeSessionFromClient(cp, newResumeSessionPacket(client.token, client.recvSeq))
+	if msgtype, pkt := client.recv(t, recv); msgtype != proto.MT_RESUME_SESSION_RESULT_ON_CLIENT || !pkt.ReadBool() {
+		t.Fatalf("resume with the count of received messages should succeed")
+	}
+	sendTestPackets(cp, 6, 6)
+	for i := uint32(3); i <= 6; i++ {
+		if msgtype, pkt := client.recv(t, recv); msgtype != testMsgType || pkt.ReadUint32() != i {
+			t.Fatalf("message %d should be received after resuming", i)
+		}
+	}
+	if client.recvSeq != cp.sendSeq {
+		t.Fatalf("client counts %d messages, but gate sends %d", client.recvSeq, cp.sendSeq)
+	}
+}
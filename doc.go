@@ -56,7 +56,16 @@ Use goworld.LoadEntity* functions to load entities from database.
 
 Entity RPC
 
-Use goworld.Call* functions to do RPC among entities
+Use goworld.Call* functions to do RPC among entities.
+Use goworld.CallWithReply to call an entity method and receive its return values in a callback. If the last return
+value of the method is an error, it is given to the callback as the error of the call.
+
+	goworld.CallWithReply(avatarID, "GetLevel", time.Second, func(rets []interface{}, err error) {
+		var level int
+		if err == nil {
+			err = entity.ConvertReply(rets, &level)
+		}
+	})
 
 Entity storage and attributes
 
@@ -0,0 +1,153 @@
+package aoi
+
+import "math"
+
+type towerCoord struct {
+	x, y, z int
+}
+
+type tower struct {
+	aois AOISet
+}
+
+// TowerAOIManager is an implementation of AOIManager which divides the space into uniform grids (towers)
+//
+// Each AOI is put in the tower covering its position, and only AOIs in towers within the maximum AOI distance
+// are checked as candidates for AOI changes, so TowerAOIManager works well for crowded spaces. Positions out of
+// the tower range are put in the towers on the edge.
+//
+// The 3D variant also divides space on Y axis, and AOI distances are checked on Y axis as well.
+type TowerAOIManager struct {
+	minX, maxX Coord
+	minZ, maxZ Coord
+	towerSize  Coord
+	use3D      bool
+	towers     map[towerCoord]*tower
+	dists      distTracker
+}
+
+// NewTowerAOIManager creates a new TowerAOIManager working on X-Z plane
+func NewTowerAOIManager(minX, maxX, minZ, maxZ Coord, towerSize Coord) AOIManager {
+	return newTowerAOIManager(minX, maxX, minZ, maxZ, towerSize, false)
+}
+
+// NewTower3DAOIManager creates a new TowerAOIManager which also takes Y axis into account
+//
+// The range on Y axis is unbounded.
+func NewTower3DAOIManager(minX, maxX, minZ, maxZ Coord, towerSize Coord) AOIManager {
+	return newTowerAOIManager(minX, maxX, minZ, maxZ, towerSize, true)
+}
+
+func newTowerAOIManager(minX, maxX, minZ, maxZ Coord, towerSize Coord, use3D bool) *TowerAOIManager {
+	if towerSize <= 0 {
+		panic("tower size should be positive")
+	}
+	if minX > maxX || minZ > maxZ {
+		panic("invalid tower range")
+	}
+
+	return &TowerAOIManager{
+		minX:      minX,
+		maxX:      maxX,
+		minZ:      minZ,
+		maxZ:      maxZ,
+		towerSize: towerSize,
+		use3D:     use3D,
+		towers:    map[towerCoord]*tower{},
+	}
+}
+
+// Enter is called when Entity enters Space
+func (aoiman *TowerAOIManager) Enter(aoi *AOI, x, y, z Coord) {
+	aoi.x, aoi.y, aoi.z = x, y, z
+	tc := aoiman.towerCoordOf(x, y, z)
+	aoi.implData = tc
+	aoiman.dists.add(aoi.dist)
+	aoiman.addToTower(aoi, tc)
+	aoiman.adjust(aoi)
+}
+
+// Leave is called when Entity leaves Space
+func (aoiman *TowerAOIManager) Leave(aoi *AOI) {
+	aoiman.removeFromTower(aoi, aoi.implData.(towerCoord))
+	aoi.implData = nil
+	aoiman.dists.remove(aoi.dist)
+	aoi.clearInterests()
+}
+
+// Moved is called when Entity moves in Space
+func (aoiman *TowerAOIManager) Moved(aoi *AOI, x, y, z Coord) {
+	aoi.x, aoi.y, aoi.z = x, y, z
+	oldtc := aoi.implData.(towerCoord)
+	if tc := aoiman.towerCoordOf(x, y, z); tc != oldtc {
+		aoiman.removeFromTower(aoi, oldtc)
+		aoiman.addToTower(aoi, tc)
+		aoi.implData = tc
+	}
+	aoiman.adjust(aoi)
+}
+
+// adjust updates interests between the moved AOI and AOIs in towers within the maximum AOI distance
+func (aoiman *TowerAOIManager) adjust(aoi *AOI) {
+	see := canSee
+	if aoiman.use3D {
+		see = canSee3D
+	}
+
+	removeInterests(aoi, see)
+
+	dist := aoiman.dists.maxDist
+	mintc := aoiman.towerCoordOf(aoi.x-dist, aoi.y-dist, aoi.z-dist)
+	maxtc := aoiman.towerCoordOf(aoi.x+dist, aoi.y+dist, aoi.z+dist)
+	for tx := mintc.x; tx <= maxtc.x; tx++ {
+		for ty := mintc.y; ty <= maxtc.y; ty++ {
+			for tz := mintc.z; tz <= maxtc.z; tz++ {
+				t := aoiman.towers[towerCoord{tx, ty, tz}]
+				if t == nil {
+					continue
+				}
+
+				for other := range t.aois {
+					if other != aoi {
+						checkInterests(aoi, other, see)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (aoiman *TowerAOIManager) addToTower(aoi *AOI, tc towerCoord) {
+	t := aoiman.towers[tc]
+	if t == nil {
+		t = &tower{aois: AOISet{}}
+		aoiman.towers[tc] = t
+	}
+	t.aois.Add(aoi)
+}
+
+func (aoiman *TowerAOIManager) removeFromTower(aoi *AOI, tc towerCoord) {
+	t := aoiman.towers[tc]
+	t.aois.Remove(aoi)
+	if len(t.aois) == 0 {
+		delete(aoiman.towers, tc)
+	}
+}
+
+func (aoiman *TowerAOIManager) towerCoordOf(x, y, z Coord) (tc towerCoord) {
+	tc.x = aoiman.towerIndex(x, aoiman.minX, aoiman.maxX)
+	tc.z = aoiman.towerIndex(z, aoiman.minZ, aoiman.maxZ)
+	if aoiman.use3D {
+		tc.y = int(math.Floor(float64(y / aoiman.towerSize)))
+	}
+	return
+}
+
+func (aoiman *TowerAOIManager) towerIndex(v Coord, min, max Coord) int {
+	if v < min {
+		v = min
+	} else if v > max {
+		v = max
+	}
+	return int((v - min) / aoiman.towerSize)
+}
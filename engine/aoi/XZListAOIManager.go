@@ -0,0 +1,132 @@
+package aoi
+
+type xzaoi struct {
+	aoi          *AOI
+	xPrev, xNext *xzaoi
+}
+
+// XZListAOIManager is an implementation of AOIManager using a list sorted by X coordinates
+//
+// Entities within the maximum AOI distance on X axis are checked as candidates for AOI changes,
+// so XZListAOIManager works with different AOI distances among entities.
+type XZListAOIManager struct {
+	head, tail *xzaoi
+	dists      distTracker
+}
+
+// NewXZListAOIManager creates a new XZListAOIManager
+func NewXZListAOIManager() AOIManager {
+	return &XZListAOIManager{}
+}
+
+// Enter is called when Entity enters Space
+func (aoiman *XZListAOIManager) Enter(aoi *AOI, x, y, z Coord) {
+	node := &xzaoi{aoi: aoi}
+	aoi.x, aoi.y, aoi.z = x, y, z
+	aoi.implData = node
+	aoiman.dists.add(aoi.dist)
+	aoiman.insert(node)
+	aoiman.adjust(node)
+}
+
+// Leave is called when Entity leaves Space
+func (aoiman *XZListAOIManager) Leave(aoi *AOI) {
+	node := aoi.implData.(*xzaoi)
+	aoi.implData = nil
+	aoiman.remove(node)
+	aoiman.dists.remove(aoi.dist)
+	aoi.clearInterests()
+}
+
+// Moved is called when Entity moves in Space
+func (aoiman *XZListAOIManager) Moved(aoi *AOI, x, y, z Coord) {
+	oldX := aoi.x
+	aoi.x, aoi.y, aoi.z = x, y, z
+	node := aoi.implData.(*xzaoi)
+	if oldX != x {
+		aoiman.reposition(node)
+	}
+	aoiman.adjust(node)
+}
+
+// adjust updates interests between the moved AOI and other AOIs
+func (aoiman *XZListAOIManager) adjust(node *xzaoi) {
+	aoi := node.aoi
+	removeInterests(aoi, canSee)
+
+	// find new interests among AOIs within the maximum AOI distance on X axis
+	for prev := node.xPrev; prev != nil && aoi.x-prev.aoi.x <= aoiman.dists.maxDist; prev = prev.xPrev {
+		checkInterests(aoi, prev.aoi, canSee)
+	}
+	for next := node.xNext; next != nil && next.aoi.x-aoi.x <= aoiman.dists.maxDist; next = next.xNext {
+		checkInterests(aoi, next.aoi, canSee)
+	}
+}
+
+func (aoiman *XZListAOIManager) insert(node *xzaoi) {
+	x := node.aoi.x
+	p := aoiman.head
+	for p != nil && p.aoi.x < x {
+		p = p.xNext
+	}
+
+	if p == nil { // insert at the end of list
+		aoiman.insertAfter(node, aoiman.tail)
+	} else { // p.x >= x, insert before p
+		aoiman.insertBefore(node, p)
+	}
+}
+
+func (aoiman *XZListAOIManager) insertAfter(node *xzaoi, prev *xzaoi) {
+	node.xPrev = prev
+	if prev != nil {
+		node.xNext = prev.xNext
+		prev.xNext = node
+	} else {
+		node.xNext = aoiman.head
+		aoiman.head = node
+	}
+
+	if node.xNext != nil {
+		node.xNext.xPrev = node
+	} else {
+		aoiman.tail = node
+	}
+}
+
+func (aoiman *XZListAOIManager) insertBefore(node *xzaoi, next *xzaoi) {
+	aoiman.insertAfter(node, next.xPrev)
+}
+
+func (aoiman *XZListAOIManager) remove(node *xzaoi) {
+	prev, next := node.xPrev, node.xNext
+	if prev != nil {
+		prev.xNext = next
+	} else {
+		aoiman.head = next
+	}
+	if next != nil {
+		next.xPrev = prev
+	} else {
+		aoiman.tail = prev
+	}
+	node.xPrev, node.xNext = nil, nil
+}
+
+// reposition moves the node to the right place in list after X coordinate is changed
+func (aoiman *XZListAOIManager) reposition(node *xzaoi) {
+	x := node.aoi.x
+	if next := node.xNext; next != nil && next.aoi.x < x {
+		aoiman.remove(node)
+		for next.xNext != nil && next.xNext.aoi.x < x {
+			next = next.xNext
+		}
+		aoiman.insertAfter(node, next)
+	} else if prev := node.xPrev; prev != nil && prev.aoi.x > x {
+		aoiman.remove(node)
+		for prev.xPrev != nil && prev.xPrev.aoi.x > x {
+			prev = prev.xPrev
+		}
+		aoiman.insertBefore(node, prev)
+	}
+}
@@ -0,0 +1,168 @@
+package aoi
+
+// Coord is the type for coordinate axes values
+type Coord float32
+
+// AOI is the AOI node of an object managed by AOIManager
+//
+// Each AOI has its own AOI distance, so interests are not always mutual:
+// A is interested in B if B is in A's AOI distance, no matter whether A is in B's AOI distance
+type AOI struct {
+	x, y, z Coord
+	dist    Coord
+	Data    interface{}
+
+	callback     AOICallback
+	interestedIn AOISet // AOIs that this AOI is interested in
+	interestedBy AOISet // AOIs that are interested in this AOI
+	implData     interface{}
+}
+
+// InitAOI initializes the AOI with AOI distance, data and callback
+func InitAOI(aoi *AOI, dist Coord, data interface{}, callback AOICallback) {
+	aoi.dist = dist
+	aoi.Data = data
+	aoi.callback = callback
+	aoi.interestedIn = AOISet{}
+	aoi.interestedBy = AOISet{}
+}
+
+// SetDist sets the AOI distance
+//
+// AOI distance should only be changed when AOI is not in any AOIManager
+func (aoi *AOI) SetDist(dist Coord) {
+	aoi.dist = dist
+}
+
+// Dist returns the AOI distance
+func (aoi *AOI) Dist() Coord {
+	return aoi.dist
+}
+
+// canSee checks if other is in the AOI distance of aoi on X-Z plane
+func canSee(aoi *AOI, other *AOI) bool {
+	dx := other.x - aoi.x
+	dz := other.z - aoi.z
+	return dx >= -aoi.dist && dx <= aoi.dist && dz >= -aoi.dist && dz <= aoi.dist
+}
+
+// canSee3D checks if other is in the AOI distance of aoi in 3D space
+func canSee3D(aoi *AOI, other *AOI) bool {
+	dy := other.y - aoi.y
+	return canSee(aoi, other) && dy >= -aoi.dist && dy <= aoi.dist
+}
+
+func (aoi *AOI) interest(other *AOI) {
+	aoi.interestedIn.Add(other)
+	other.interestedBy.Add(aoi)
+	aoi.callback.OnEnterAOI(other)
+}
+
+func (aoi *AOI) uninterest(other *AOI) {
+	aoi.interestedIn.Remove(other)
+	other.interestedBy.Remove(aoi)
+	aoi.callback.OnLeaveAOI(other)
+}
+
+// clearInterests removes all interests of the AOI when it is leaving AOIManager
+func (aoi *AOI) clearInterests() {
+	for other := range aoi.interestedIn {
+		aoi.uninterest(other)
+	}
+	for other := range aoi.interestedBy {
+		other.uninterest(aoi)
+	}
+}
+
+// removeInterests removes interests between aoi and other AOIs that are no longer in AOI distance
+func removeInterests(aoi *AOI, see func(aoi *AOI, other *AOI) bool) {
+	for other := range aoi.interestedIn {
+		if !see(aoi, other) {
+			aoi.uninterest(other)
+		}
+	}
+	for other := range aoi.interestedBy {
+		if !see(other, aoi) {
+			other.uninterest(aoi)
+		}
+	}
+}
+
+// checkInterests adds interests between aoi and other if they are in AOI distance
+func checkInterests(aoi *AOI, other *AOI, see func(aoi *AOI, other *AOI) bool) {
+	if !aoi.interestedIn.Contains(other) && see(aoi, other) {
+		aoi.interest(other)
+	}
+	if !other.interestedIn.Contains(aoi) && see(other, aoi) {
+		other.interest(aoi)
+	}
+}
+
+// AOICallback is the interface for receiving AOI events
+type AOICallback interface {
+	// OnEnterAOI is called when other AOI enters the AOI distance of this AOI
+	OnEnterAOI(other *AOI)
+	// OnLeaveAOI is called when other AOI leaves the AOI distance of this AOI
+	OnLeaveAOI(other *AOI)
+}
+
+// AOIManager is the interface of AOI algorithms
+//
+// Positions are given in 3D coordinates: X and Z are the horizontal axes and Y is the height.
+// AOI managers working on the X-Z plane just ignore Y.
+type AOIManager interface {
+	Enter(aoi *AOI, x, y, z Coord)
+	Leave(aoi *AOI)
+	Moved(aoi *AOI, x, y, z Coord)
+}
+
+// distTracker tracks the maximum AOI distance of AOIs in AOIManager
+type distTracker struct {
+	maxDist   Coord
+	distCount map[Coord]int // number of AOIs for each AOI distance
+}
+
+func (dt *distTracker) add(dist Coord) {
+	if dt.distCount == nil {
+		dt.distCount = map[Coord]int{}
+	}
+	dt.distCount[dist] += 1
+	if dist > dt.maxDist {
+		dt.maxDist = dist
+	}
+}
+
+func (dt *distTracker) remove(dist Coord) {
+	if dt.distCount[dist] -= 1; dt.distCount[dist] > 0 {
+		return
+	}
+
+	delete(dt.distCount, dist)
+	if dist == dt.maxDist {
+		dt.maxDist = 0
+		for d := range dt.distCount {
+			if d > dt.maxDist {
+				dt.maxDist = d
+			}
+		}
+	}
+}
+
+// AOISet is a set of AOIs
+type AOISet map[*AOI]struct{}
+
+// Add adds AOI to set
+func (s AOISet) Add(aoi *AOI) {
+	s[aoi] = struct{}{}
+}
+
+// Remove removes AOI from set
+func (s AOISet) Remove(aoi *AOI) {
+	delete(s, aoi)
+}
+
+// Contains checks if AOI is in set
+func (s AOISet) Contains(aoi *AOI) (ok bool) {
+	_, ok = s[aoi]
+	return
+}
@@ -0,0 +1,148 @@
+package aoi
+
+import (
+	"math/rand"
+	"testing"
+)
+
+type testObj struct {
+	aoi          AOI
+	interestedIn map[*testObj]struct{}
+}
+
+func newTestObj(dist Coord) *testObj {
+	obj := &testObj{interestedIn: map[*testObj]struct{}{}}
+	InitAOI(&obj.aoi, dist, obj, obj)
+	return obj
+}
+
+func (obj *testObj) OnEnterAOI(other *AOI) {
+	o := other.Data.(*testObj)
+	if _, ok := obj.interestedIn[o]; ok {
+		panic("duplicate enter aoi")
+	}
+	obj.interestedIn[o] = struct{}{}
+}
+
+func (obj *testObj) OnLeaveAOI(other *AOI) {
+	o := other.Data.(*testObj)
+	if _, ok := obj.interestedIn[o]; !ok {
+		panic("duplicate leave aoi")
+	}
+	delete(obj.interestedIn, o)
+}
+
+func TestAsymmetricInterests(t *testing.T) {
+	aoiman := NewXZListAOIManager()
+	boss := newTestObj(200)
+	player := newTestObj(50)
+	aoiman.Enter(&boss.aoi, 0, 0, 0)
+	aoiman.Enter(&player.aoi, 100, 0, 0)
+
+	if _, ok := boss.interestedIn[player]; !ok {
+		t.Fatalf("boss should be interested in player")
+	}
+	if _, ok := player.interestedIn[boss]; ok {
+		t.Fatalf("player should not be interested in boss")
+	}
+
+	aoiman.Moved(&player.aoi, 40, 0, 10)
+	if _, ok := player.interestedIn[boss]; !ok {
+		t.Fatalf("player should be interested in boss")
+	}
+
+	aoiman.Moved(&player.aoi, 300, 0, 10)
+	if len(boss.interestedIn) != 0 || len(player.interestedIn) != 0 {
+		t.Fatalf("interests should be cleared")
+	}
+
+	aoiman.Moved(&player.aoi, 10, 0, 10)
+	aoiman.Leave(&boss.aoi)
+	if len(player.interestedIn) != 0 {
+		t.Fatalf("player should not be interested in boss after boss left")
+	}
+}
+
+func TestXZListAOIManager(t *testing.T) {
+	testAOIManager(t, NewXZListAOIManager(), canSee)
+}
+
+func TestTowerAOIManager(t *testing.T) {
+	testAOIManager(t, NewTowerAOIManager(0, 100, 0, 100, 20), canSee)
+}
+
+func TestTower3DAOIManager(t *testing.T) {
+	testAOIManager(t, NewTower3DAOIManager(0, 100, 0, 100, 20), canSee3D)
+}
+
+func testAOIManager(t *testing.T, aoiman AOIManager, see func(aoi *AOI, other *AOI) bool) {
+	var objs []*testObj
+	for i := 0; i < 100; i++ {
+		obj := newTestObj(Coord(10 + rand.Intn(5)*10))
+		objs = append(objs, obj)
+		aoiman.Enter(&obj.aoi, Coord(rand.Intn(200)), Coord(rand.Intn(50)), Coord(rand.Intn(200)))
+	}
+	verifyInterests(t, objs, see)
+
+	for round := 0; round < 100; round++ {
+		for _, obj := range objs {
+			aoiman.Moved(&obj.aoi, obj.aoi.x+randMove(), obj.aoi.y+randMove(), obj.aoi.z+randMove())
+		}
+		verifyInterests(t, objs, see)
+	}
+
+	for _, obj := range objs[:50] {
+		aoiman.Leave(&obj.aoi)
+		if len(obj.interestedIn) != 0 {
+			t.Fatalf("interests should be cleared after leave")
+		}
+	}
+	verifyInterests(t, objs[50:], see)
+}
+
+func randMove() Coord {
+	return Coord(rand.Intn(21) - 10)
+}
+
+func verifyInterests(t *testing.T, objs []*testObj, see func(aoi *AOI, other *AOI) bool) {
+	for _, obj := range objs {
+		for _, other := range objs {
+			if obj == other {
+				continue
+			}
+			_, interested := obj.interestedIn[other]
+			if interested != see(&obj.aoi, &other.aoi) {
+				t.Fatalf("wrong interest: dist=%v, (%v, %v, %v) -> (%v, %v, %v): interested=%v", obj.aoi.dist,
+					obj.aoi.x, obj.aoi.y, obj.aoi.z, other.aoi.x, other.aoi.y, other.aoi.z, interested)
+			}
+		}
+	}
+}
+
+func BenchmarkXZListAOIManager(b *testing.B) {
+	benchmarkAOIManager(b, NewXZListAOIManager())
+}
+
+func BenchmarkTowerAOIManager(b *testing.B) {
+	benchmarkAOIManager(b, NewTowerAOIManager(-1000, 1000, -1000, 1000, 100))
+}
+
+func BenchmarkTower3DAOIManager(b *testing.B) {
+	benchmarkAOIManager(b, NewTower3DAOIManager(-1000, 1000, -1000, 1000, 100))
+}
+
+// benchmarkAOIManager measures each move of entities in a crowded space
+func benchmarkAOIManager(b *testing.B, aoiman AOIManager) {
+	const numObjs = 1000
+	objs := make([]*testObj, numObjs)
+	for i := range objs {
+		objs[i] = newTestObj(100)
+		aoiman.Enter(&objs[i].aoi, Coord(rand.Intn(2000)-1000), Coord(rand.Intn(100)), Coord(rand.Intn(2000)-1000))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj := objs[i%numObjs]
+		aoiman.Moved(&obj.aoi, obj.aoi.x+randMove(), obj.aoi.y+randMove(), obj.aoi.z+randMove())
+	}
+}
@@ -58,18 +58,24 @@ type GameConfig struct {
 
 // GateConfig defines fields of gate config
 type GateConfig struct {
-	ListenAddr             string
-	LogFile                string
-	LogStderr              bool
-	HTTPAddr               string
-	LogLevel               string
-	GoMaxProcs             int
-	CompressConnection     bool
-	EncryptConnection      bool
-	RSAKey                 string
-	RSACertificate         string
-	HeartbeatCheckInterval int
-	PositionSyncIntervalMS int
+	ListenAddr              string
+	LogFile                 string
+	LogStderr               bool
+	HTTPAddr                string
+	LogLevel                string
+	GoMaxProcs              int
+	CompressConnection      bool
+	EncryptConnection       bool
+	RSAKey                  string
+	RSACertificate          string
+	HeartbeatCheckInterval  int
+	PositionSyncIntervalMS  int
+	AuthType                string // Type of client authentication, empty for no authentication
+	AuthSecret              string // Secret for hmac authentication
+	AuthURL                 string // URL for token authentication
+	AuthTimeout             int    // Seconds for clients to finish authentication
+	SessionGracePeriod      int    // Seconds to keep sessions of disconnected clients for resuming, 0 to disable
+	SessionReplayBufferSize int    // Max number of session messages (position syncs excluded) buffered for replaying to resumed clients
 }
 
 // DispatcherConfig defines fields of dispatcher config
@@ -98,19 +104,24 @@ type GoWorldConfig struct {
 
 // StorageConfig defines fields of storage config
 type StorageConfig struct {
-	Type       string // Type of storage (mongodb)
-	Url        string // Connection URL (mongodb)
-	DB         string // Database name (mongodb)
-	StartNodes common.StringSet
+	Type       string           // Type of storage (mongodb, redis, redis_cluster, sql, embedded)
+	Url        string           // Connection URL (mongodb, redis)
+	DB         string           // Database name (mongodb) or index (redis)
+	StartNodes common.StringSet // Start nodes (redis_cluster)
+	Driver     string           // SQL driver: sqlite3, mysql or postgres (sql)
+	DSN        string           // SQL data source name (sql)
+	Path       string           // Database file path (embedded)
 }
 
 // KVDBConfig defines fields of KVDB config
 type KVDBConfig struct {
-	Type       string
-	Url        string // MongoDB
-	DB         string // MongoDB
-	Collection string // MongoDB
-	StartNodes common.StringSet
+	Type          string
+	Url           string // MongoDB
+	DB            string // MongoDB
+	Collection    string // MongoDB
+	StartNodes    common.StringSet
+	Path          string           // embedded
+	RelayPrefixes common.StringSet // changes of keys with these prefixes are relayed, if KVDB can not notify changes
 }
 
 type DebugConfig struct {
@@ -375,6 +386,9 @@ func readGateCommonConfig(section *ini.Section, gcc *GateConfig) {
 	gcc.RSACertificate = "rsa.crt"
 	gcc.HeartbeatCheckInterval = 0
 	gcc.PositionSyncIntervalMS = 100
+	gcc.AuthTimeout = 10
+	gcc.SessionGracePeriod = 0
+	gcc.SessionReplayBufferSize = 1000
 
 	_readGateConfig(section, gcc)
 }
@@ -389,6 +403,12 @@ func readGateConfig(sec *ini.Section, gateCommonConfig *GateConfig) *GateConfig
 	if sc.EncryptConnection && sc.RSACertificate == "" {
 		gwlog.Fatalf("Gate %s: encrypt_connection is enabled, but rsa_certificate is not set", sec.Name())
 	}
+	if sc.AuthType == "hmac" && sc.AuthSecret == "" {
+		gwlog.Fatalf("Gate %s: auth_type is hmac, but auth_secret is not set", sec.Name())
+	}
+	if sc.AuthType == "token" && sc.AuthURL == "" {
+		gwlog.Fatalf("Gate %s: auth_type is token, but auth_url is not set", sec.Name())
+	}
 	return &sc
 }
 
@@ -419,6 +439,18 @@ func _readGateConfig(sec *ini.Section, sc *GateConfig) {
 			sc.HeartbeatCheckInterval = key.MustInt(sc.HeartbeatCheckInterval)
 		} else if name == "position_sync_interval_ms" {
 			sc.PositionSyncIntervalMS = key.MustInt(sc.PositionSyncIntervalMS)
+		} else if name == "auth_type" {
+			sc.AuthType = key.MustString(sc.AuthType)
+		} else if name == "auth_secret" {
+			sc.AuthSecret = key.MustString(sc.AuthSecret)
+		} else if name == "auth_url" {
+			sc.AuthURL = key.MustString(sc.AuthURL)
+		} else if name == "auth_timeout" {
+			sc.AuthTimeout = key.MustInt(sc.AuthTimeout)
+		} else if name == "session_grace_period" {
+			sc.SessionGracePeriod = key.MustInt(sc.SessionGracePeriod)
+		} else if name == "session_replay_buffer_size" {
+			sc.SessionReplayBufferSize = key.MustInt(sc.SessionReplayBufferSize)
 		} else {
 			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
@@ -468,9 +500,12 @@ func _readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 	// setup default values
 	config.Type = "mongodb"
-	config.DB = _DEFAULT_STORAGE_DB
+	config.DB = ""
 	config.Url = ""
 	config.StartNodes = common.StringSet{}
+	config.Driver = ""
+	config.DSN = ""
+	config.Path = ""
 
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
@@ -482,14 +517,22 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 			config.DB = key.MustString(config.DB)
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if name == "driver" {
+			config.Driver = key.MustString(config.Driver)
+		} else if name == "dsn" {
+			config.DSN = key.MustString(config.DSN)
+		} else if name == "path" {
+			config.Path = key.MustString(config.Path)
 		} else {
 			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 
-	if config.Type == "redis" {
-		if config.DB == "" {
+	if config.DB == "" {
+		if config.Type == "redis" {
 			config.DB = "0"
+		} else {
+			config.DB = _DEFAULT_STORAGE_DB
 		}
 	}
 
@@ -498,6 +541,7 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 
 func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 	config.StartNodes = common.StringSet{}
+	config.RelayPrefixes = common.StringSet{}
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "type" {
@@ -510,6 +554,10 @@ func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 			config.Collection = key.MustString(config.Collection)
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if name == "path" {
+			config.Path = key.MustString(config.Path)
+		} else if strings.HasPrefix(name, "relay_prefix_") {
+			config.RelayPrefixes.Add(key.MustString(""))
 		} else {
 			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
@@ -549,6 +597,10 @@ func validateKVDBConfig(config *KVDBConfig) {
 				gwlog.Fatalf("start_nodes must not be empty")
 			}
 		}
+	} else if config.Type == "embedded" {
+		if config.Path == "" {
+			gwlog.Fatalf("path is not set in %s KVDB config", config.Type)
+		}
 	} else {
 		gwlog.Fatalf("unknown storage type: %s", config.Type)
 	}
@@ -584,6 +636,34 @@ func validateStorageConfig(config *StorageConfig) {
 		if config.DB == "" {
 			gwlog.Fatalf("db is not set in %s storage config", config.Type)
 		}
+	} else if config.Type == "redis" {
+		if config.Url == "" {
+			gwlog.Fatalf("url is not set in %s storage config", config.Type)
+		}
+		_, err := strconv.Atoi(config.DB) // make sure db is integer for redis
+		if err != nil {
+			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
+		}
+	} else if config.Type == "redis_cluster" {
+		if len(config.StartNodes) == 0 {
+			gwlog.Fatalf("must have at least 1 start_nodes for [storage].redis_cluster")
+		}
+		for s := range config.StartNodes {
+			if s == "" {
+				gwlog.Fatalf("start_nodes must not be empty")
+			}
+		}
+	} else if config.Type == "sql" {
+		if config.Driver != "sqlite3" && config.Driver != "mysql" && config.Driver != "postgres" {
+			gwlog.Fatalf("driver of %s storage config must be sqlite3, mysql or postgres, but is %#v", config.Type, config.Driver)
+		}
+		if config.DSN == "" {
+			gwlog.Fatalf("dsn is not set in %s storage config", config.Type)
+		}
+	} else if config.Type == "embedded" {
+		if config.Path == "" {
+			gwlog.Fatalf("path is not set in %s storage config", config.Type)
+		}
 	} else {
 		gwlog.Fatalf("unknown storage type: %s", config.Type)
 	}
@@ -62,7 +62,19 @@ const (
 	DISPATCHER_LOAD_TIMEOUT = time.Minute
 	// DISPATCHER_FREEZE_GAME_TIMEOUT is timeout for freezing & restoring game
 	DISPATCHER_FREEZE_GAME_TIMEOUT = time.Second * 10
+	// For Space
+	// SPACE_QUERY_CELL_SIZE is the cell size of spatial index for space queries
+	SPACE_QUERY_CELL_SIZE = 10
 	// For Storage
+	// MAX_DIRTY_ATTRS_FOR_INCREMENTAL_SAVE is the max number of dirty attributes for saving entity incrementally, entity is fully saved if there are more
+	MAX_DIRTY_ATTRS_FOR_INCREMENTAL_SAVE = 100
+	// MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES is the max number of incremental saves before entity is fully saved again,
+	// encoded sizes of entities are measured in full saves
+	MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES = 20
+	// STORAGE_MAX_BATCH_SIZE is the max number of queued storage operations handled in one batch, pending writes in a batch are coalesced
+	STORAGE_MAX_BATCH_SIZE = 100
+	// LARGEST_ENTITIES_METRIC_COUNT is the number of entities listed in the metric of largest entities by encoded size
+	LARGEST_ENTITIES_METRIC_COUNT = 20
 	// For Operation Monitor
 	// OPMON_DUMP_INTERVAL is the interval to print opmon infos to output
 	OPMON_DUMP_INTERVAL = 0
@@ -97,6 +97,13 @@ func SendKvregRegister(srvid string, info string, force bool) {
 	SelectBySrvID(srvid).SendKvregRegister(srvid, info, force)
 }
 
+// SendNotifyKVDBChange relays KVDB change to all games, changes of the same key are relayed by the same dispatcher
+func SendNotifyKVDBChange(key string, val string) {
+	if gid != 0 {
+		SelectBySrvID(key).SendNotifyKVDBChange(key, val)
+	}
+}
+
 func SendCallNilSpaces(exceptGameID uint16, method string, args []interface{}) {
 	// construct one packet for multiple sending
 	packet := proto.AllocCallNilSpacesPacket(exceptGameID, method, args)
@@ -104,6 +111,14 @@ func SendCallNilSpaces(exceptGameID uint16, method string, args []interface{}) {
 	packet.Release()
 }
 
+func SendCallEntityMethodWithReply(id common.EntityID, method string, callID uint32, args []interface{}) {
+	SelectByEntityID(id).SendCallEntityMethodWithReply(id, method, gid, callID, args)
+}
+
+func SendCallEntityMethodReply(id common.EntityID, callerGameID uint16, callID uint32, errmsg string, rets []interface{}) {
+	SelectByEntityID(id).SendCallEntityMethodReply(callerGameID, callID, errmsg, rets)
+}
+
 func EntityIDToDispatcherID(entityid common.EntityID) uint16 {
 	return uint16((hashEntityID(entityid) % dispatcherNum) + 1)
 }
@@ -9,17 +9,19 @@ import (
 	"unsafe"
 
 	"github.com/pkg/errors"
-	"github.com/xiaonanln/go-aoi"
 	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/aoi"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -47,6 +49,14 @@ type Entity struct {
 	I                    IEntity
 	V                    reflect.Value
 	destroyed            bool
+	deleted              bool // entity data is deleted from storage, so it should never be saved again
+	dirtyAttrs           dirtyAttrs
+	epoch                int64          // owner epoch of entity data, stale saves of former owners are rejected by storage
+	attrsReady           bool           // attributes are loaded, attribute hooks and limits apply afterwards
+	attrDeltas           []*attrDelta   // attribute changes to be sent to clients
+	attrDeltaSlots       map[string]int // index of the last delta of each attribute in attrDeltas
+	encodedSize          int            // encoded size of persistent attributes, measured when fully saved
+	kvdbUnwatches        []func()       // functions to stop KVDB watches of entity
 	typeDesc             *EntityTypeDesc
 	Space                *Space
 	Position             Vector3
@@ -72,6 +82,7 @@ type Entity struct {
 type clientData struct {
 	ClientID common.ClientID
 	GateID   uint16
+	Identity string
 }
 
 // entity info that should be migrated
@@ -86,6 +97,8 @@ type entityMigrateData struct {
 	FilterProps       map[string]string      `msgpack:"FP"`
 	SyncingFromClient bool                   `msgpack:"SFC"`
 	SyncInfoFlag      syncInfoFlag           `msgpack:"SIF"`
+	Epoch             int64                  `msgpack:"EP,omitempty"`
+	SchemaVersion     int                    `msgpack:"SV,omitempty"`
 }
 
 type syncInfoFlag int
@@ -133,6 +146,25 @@ func (e *Entity) Destroy() {
 	dispatchercluster.SendNotifyDestroyEntity(e.ID)
 }
 
+// DestroyAndDelete destroys the entity and deletes its data from entity storage
+//
+// The entity is not saved when destroying, and the data saved before is deleted since storage operations are in order
+func (e *Entity) DestroyAndDelete() {
+	if e.destroyed {
+		return
+	}
+
+	e.deleted = true
+	e.Destroy()
+	if e.IsPersistent() {
+		storage.Delete(e.TypeName, e.ID, func(err error) {
+			if err != nil {
+				gwlog.Errorf("%s.DestroyAndDelete: delete from storage failed: %s", e, err)
+			}
+		})
+	}
+}
+
 func (e *Entity) destroyEntity(isMigrate bool) {
 	e.Space.leave(e)
 
@@ -144,6 +176,8 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 
 	e.clearRawTimers()
 	e.rawTimers = nil // prohibit further use
+	e.unwatchKVDB()
+	e.flushAttrDeltas()
 
 	if !isMigrate {
 		e.SetClient(nil) // always set Client to nil before destroy
@@ -154,6 +188,7 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 
 	e.destroyed = true
 	entityManager.del(e)
+	e.forgetEncodedSize()
 }
 
 // IsDestroyed returns if the entity is destroyed
@@ -162,18 +197,42 @@ func (e *Entity) IsDestroyed() bool {
 }
 
 // Save the entity
+//
+// Only persistent attributes changed since last save are written to storage, unless the entity needs to be fully saved.
+// Entity is fully saved after at most consts.MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES incremental saves, and the encoded
+// size of entity is only refreshed in full saves.
 func (e *Entity) Save() {
-	if !e.IsPersistent() {
+	if !e.IsPersistent() || e.deleted {
 		return
 	}
 
-	if consts.DEBUG_SAVE_LOAD {
-		gwlog.Debugf("SAVING %s ...", e)
+	data, updates := e.collectSaveData()
+	if data != nil {
+		if consts.DEBUG_SAVE_LOAD {
+			gwlog.Debugf("SAVING %s ...", e)
+		}
+		e.measureEncodedSize(data)
+		data[storagecommon.EpochKey] = e.epoch
+		if e.typeDesc.schemaVersion > 0 {
+			data[storagecommon.SchemaVersionKey] = e.typeDesc.schemaVersion
+		}
+		storage.Save(e.TypeName, e.ID, data, nil)
+	} else if len(updates) > 0 {
+		if consts.DEBUG_SAVE_LOAD {
+			gwlog.Debugf("SAVING %s: %d updates ...", e, len(updates))
+		}
+		e.saveUpdates(updates)
 	}
+}
 
-	data := e.getPersistentData()
-
-	storage.Save(e.TypeName, e.ID, data, nil)
+// saveUpdates saves updates of entity data with the owner epoch
+func (e *Entity) saveUpdates(updates []storagecommon.AttrUpdate) {
+	updates = append(updates, storagecommon.EpochUpdate(e.epoch))
+	storage.Update(e.TypeName, e.ID, updates, func(err error) {
+		if err != nil && !storagecommon.IsStaleWrite(err) {
+			e.dirtyAttrs.markFull() // incremental save failed, save the whole entity next time
+		}
+	})
 }
 
 // IsSpaceEntity returns if the entity is actually a space
@@ -204,6 +263,8 @@ func (e *Entity) init(typeName string, entityid common.EntityID, entityInstance
 	attrs := NewMapAttr()
 	attrs.owner = e
 	e.Attrs = attrs
+	e.bindAttrFields()
+	e.dirtyAttrs.markFull() // entity is fully saved for the first time
 
 	e.InterestedIn = EntitySet{}
 	e.InterestedBy = EntitySet{}
@@ -234,6 +295,7 @@ func (e *Entity) OnLeaveAOI(otherAoi *aoi.AOI) {
 
 // Interests and Uninterest among entities
 func (e *Entity) interest(other *Entity) {
+	other.flushAttrDeltas() // pending attribute changes are included in the created entity
 	e.InterestedIn.Add(other)
 	other.InterestedBy.Add(e)
 	e.client.sendCreateEntity(other, false)
@@ -422,11 +484,39 @@ func (e *Entity) Post(cb func()) {
 	post.Post(cb)
 }
 
+// WatchKVDB watches changes of KVDB keys with prefix, and calls the method of entity with the changed key and value
+//
+// Watches are stopped when the entity is destroyed or migrated, so they should be set again in OnMigrateIn and OnRestored.
+func (e *Entity) WatchKVDB(prefix string, method string) {
+	unwatch := kvdb.Watch(prefix, func(key string, val string) {
+		e.onCallFromLocal(method, []interface{}{key, val})
+	})
+	e.kvdbUnwatches = append(e.kvdbUnwatches, unwatch)
+}
+
+func (e *Entity) unwatchKVDB() {
+	for _, unwatch := range e.kvdbUnwatches {
+		unwatch()
+	}
+	e.kvdbUnwatches = nil
+}
+
 // Call other entities
 func (e *Entity) Call(id common.EntityID, method string, args ...interface{}) {
 	Call(id, method, args)
 }
 
+// CallWithReply calls the method of other entity and receives the return values of the method in callback
+//
+// The callback will not be called if the entity is destroyed before the reply arrives
+func (e *Entity) CallWithReply(id common.EntityID, method string, timeout time.Duration, callback CallReplyCallback, args ...interface{}) {
+	CallWithReply(id, method, timeout, func(rets []interface{}, err error) {
+		if !e.destroyed {
+			callback(rets, err)
+		}
+	}, args)
+}
+
 func (e *Entity) syncPositionYawFromClient(x, y, z Coord, yaw Yaw) {
 	//gwlog.Infof("%s.syncPositionYawFromClient: %v,%v,%v, Yaw %v, syncing %v", e, x, y, z, Yaw, e.SyncingFromClient)
 	if e.syncingFromClient {
@@ -439,11 +529,12 @@ func (e *Entity) SetClientSyncing(syncing bool) {
 	e.syncingFromClient = syncing
 }
 
-func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
+func (e *Entity) onCallFromLocal(methodName string, args []interface{}) (rets []reflect.Value, err error) {
 	defer func() {
-		err := recover() // recover from any error during RPC call
-		if err != nil {
-			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+		_err := recover() // recover from any error during RPC call
+		if _err != nil {
+			gwlog.TraceError("%s.%s paniced: %s", e, methodName, _err)
+			err = errors.Errorf("%s.%s paniced: %v", e, methodName, _err)
 		}
 	}()
 
@@ -477,14 +568,15 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 		in[i+1] = reflect.Zero(argType)
 	}
 
-	rpcDesc.Func.Call(in)
+	return rpcDesc.Func.Call(in), nil
 }
 
-func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid common.ClientID) {
+func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid common.ClientID) (rets []reflect.Value, err error) {
 	defer func() {
-		err := recover() // recover from any error during RPC call
-		if err != nil {
-			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+		_err := recover() // recover from any error during RPC call
+		if _err != nil {
+			gwlog.TraceError("%s.%s paniced: %s", e, methodName, _err)
+			err = errors.Errorf("%s.%s paniced: %v", e, methodName, _err)
 		}
 	}()
 
@@ -492,7 +584,7 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 	if rpcDesc == nil {
 		// rpc not found
 		gwlog.Errorf("%s.onCallFromRemote: Method %s is not a valid RPC, args=%v", e, methodName, args)
-		return
+		return nil, errors.Errorf("%s.%s is not a valid RPC", e, methodName)
 	}
 
 	methodType := rpcDesc.MethodType
@@ -513,7 +605,7 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 
 	if rpcDesc.NumArgs < len(args) {
 		gwlog.Errorf("%s.onCallFromRemote: Method %s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
-		return
+		return nil, errors.Errorf("%s.%s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
 	}
 
 	in := make([]reflect.Value, rpcDesc.NumArgs+1)
@@ -536,7 +628,7 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 		in[i+1] = reflect.Zero(argType)
 	}
 
-	rpcDesc.Func.Call(in)
+	return rpcDesc.Func.Call(in), nil
 }
 
 // OnInit is called when entity is initializing
@@ -638,12 +730,15 @@ func (e *Entity) GetMigrateData(spaceid common.EntityID, pos Vector3) *entityMig
 		SpaceID:           spaceid,
 		SyncingFromClient: e.syncingFromClient,
 		SyncInfoFlag:      e.syncInfoFlag,
+		Epoch:             e.epoch,
+		SchemaVersion:     e.typeDesc.schemaVersion,
 	}
 
 	if e.client != nil {
 		md.Client = &clientData{
 			ClientID: e.client.clientid,
 			GateID:   e.client.gateid,
+			Identity: e.client.identity,
 		}
 	}
 
@@ -681,6 +776,12 @@ func (e *Entity) SetClient(client *GameClient) {
 		return
 	}
 
+	// flush pending attribute changes before entities are created on the new client
+	e.flushAttrDeltas()
+	for neighbor := range e.InterestedBy {
+		neighbor.flushAttrDeltas()
+	}
+
 	if oldClient != nil {
 		// send destroy entity to Client
 		dispatchercluster.SelectByEntityID(e.ID).SendClearClientFilterProp(oldClient.gateid, oldClient.clientid)
@@ -736,11 +837,13 @@ func (e *Entity) assignClient(client *GameClient) {
 
 // CallClient calls the Client entity
 func (e *Entity) CallClient(method string, args ...interface{}) {
+	e.flushAttrDeltas()
 	e.client.call(e.ID, method, args)
 }
 
 // CallAllClients calls the entity method on all clients
 func (e *Entity) CallAllClients(method string, args ...interface{}) {
+	e.flushAttrDeltas()
 	e.client.call(e.ID, method, args)
 
 	for neighbor := range e.InterestedBy {
@@ -807,6 +910,9 @@ func (e *Entity) getAttrFlag(attrName string) (flag attrFlag) {
 	} else if e.typeDesc.clientAttrs.Contains(attrName) {
 		flag = afClient
 	}
+	if e.typeDesc.persistentAttrs.Contains(attrName) {
+		flag |= afPersistent
+	}
 
 	return
 }
@@ -820,16 +926,7 @@ func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interfa
 		flag = ma.flag
 	}
 
-	if flag&afAllClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrChange(e.ID, path, key, val)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyMapAttrChange(e.ID, path, key, val)
-		}
-	} else if flag&afClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrChange(e.ID, path, key, val)
-	}
+	e.addAttrDelta(flag, attrDelta{op: proto.ATTR_CHANGE_MAP_SET, path: ma.getPathFromOwner(), key: key, val: val})
 }
 
 func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
@@ -841,16 +938,7 @@ func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
 		flag = ma.flag
 	}
 
-	if flag&afAllClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrDel(e.ID, path, key)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyMapAttrDel(e.ID, path, key)
-		}
-	} else if flag&afClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrDel(e.ID, path, key)
-	}
+	e.addAttrDelta(flag, attrDelta{op: proto.ATTR_CHANGE_MAP_DEL, path: ma.getPathFromOwner(), key: key})
 }
 
 func (e *Entity) sendMapAttrClearToClients(ma *MapAttr) {
@@ -858,62 +946,36 @@ func (e *Entity) sendMapAttrClearToClients(ma *MapAttr) {
 		// this is the root attr
 		gwlog.Panicf("outmost e.Attrs can not be cleared")
 	}
-	flag := ma.flag
 
-	if flag&afAllClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrClear(e.ID, path)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyMapAttrClear(e.ID, path)
-		}
-	} else if flag&afClient != 0 {
-		path := ma.getPathFromOwner()
-		e.client.sendNotifyMapAttrClear(e.ID, path)
-	}
+	e.addAttrDelta(ma.flag, attrDelta{op: proto.ATTR_CHANGE_MAP_CLEAR, path: ma.getPathFromOwner()})
 }
 
 func (e *Entity) sendListAttrChangeToClients(la *ListAttr, index int, val interface{}) {
-	flag := la.flag
-
-	if flag&afAllClient != 0 {
-		// TODO: only pack 1 packet, do not marshal multiple times
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrChange(e.ID, path, uint32(index), val)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyListAttrChange(e.ID, path, uint32(index), val)
-		}
-	} else if flag&afClient != 0 {
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrChange(e.ID, path, uint32(index), val)
-	}
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_SET, path: la.getPathFromOwner(), key: index, val: val})
 }
 
 func (e *Entity) sendListAttrPopToClients(la *ListAttr) {
-	flag := la.flag
-	if flag&afAllClient != 0 {
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrPop(e.ID, path)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyListAttrPop(e.ID, path)
-		}
-	} else if flag&afClient != 0 {
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrPop(e.ID, path)
-	}
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_POP, path: la.getPathFromOwner()})
 }
 
 func (e *Entity) sendListAttrAppendToClients(la *ListAttr, val interface{}) {
-	flag := la.flag
-	if flag&afAllClient != 0 {
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrAppend(e.ID, path, val)
-		for neighbor := range e.InterestedBy {
-			neighbor.client.sendNotifyListAttrAppend(e.ID, path, val)
-		}
-	} else if flag&afClient != 0 {
-		path := la.getPathFromOwner()
-		e.client.sendNotifyListAttrAppend(e.ID, path, val)
-	}
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_APPEND, path: la.getPathFromOwner(), val: val})
+}
+
+func (e *Entity) sendListAttrInsertToClients(la *ListAttr, index int, val interface{}) {
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_INSERT, path: la.getPathFromOwner(), key: index, val: val})
+}
+
+func (e *Entity) sendListAttrRemoveToClients(la *ListAttr, index int) {
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_REMOVE, path: la.getPathFromOwner(), key: index})
+}
+
+func (e *Entity) sendListAttrSwapToClients(la *ListAttr, i, j int) {
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_SWAP, path: la.getPathFromOwner(), key: i, val: j})
+}
+
+func (e *Entity) sendListAttrTruncateToClients(la *ListAttr, size int) {
+	e.addAttrDelta(la.flag, attrDelta{op: proto.ATTR_CHANGE_LIST_TRUNCATE, path: la.getPathFromOwner(), key: size})
 }
 
 // Define Attributes Properties
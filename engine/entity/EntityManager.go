@@ -12,6 +12,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -31,6 +32,13 @@ type EntityTypeDesc struct {
 	allClientAttrs  common.StringSet
 	clientAttrs     common.StringSet
 	persistentAttrs common.StringSet
+	indexedAttrs    common.StringSet
+	schemaVersion   int
+	migrations      map[int]AttrMigration
+	attrTypes       map[string]*AttrType
+	attrFields      []attrFieldDesc
+	attrHooks       []attrHook
+	attrLimits      *AttrLimits
 	//compositiveMethodComponentIndices map[string][]int
 	//definedAttrs                      bool
 }
@@ -41,6 +49,7 @@ func init() {
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Client"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("AllClients"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Persistent"))
+	_VALID_ATTR_DEFS.Add(strings.ToLower("Indexed"))
 }
 
 func (desc *EntityTypeDesc) SetPersistent(persistent bool) *EntityTypeDesc {
@@ -52,6 +61,11 @@ func (desc *EntityTypeDesc) SetPersistent(persistent bool) *EntityTypeDesc {
 	return desc
 }
 
+// SetUseAOI sets if the entity type uses AOI
+//
+// aoiDistance is the distance within which entities of this type are interested in other entities,
+// or 0 to use the default AOI distance of space. Entities with different AOI distances can be in the same space,
+// so an entity might be interested in another entity that is not interested in it.
 func (desc *EntityTypeDesc) SetUseAOI(useAOI bool, aoiDistance Coord) *EntityTypeDesc {
 	if aoiDistance < 0 {
 		gwlog.Panicf("aoi distance < 0")
@@ -64,7 +78,7 @@ func (desc *EntityTypeDesc) SetUseAOI(useAOI bool, aoiDistance Coord) *EntityTyp
 
 func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeDesc {
 	gwlog.Infof("        Attr %s = %v", attr, defs)
-	isAllClient, isClient, isPersistent := false, false, false
+	isAllClient, isClient, isPersistent, isIndexed := false, false, false, false
 
 	for _, def := range defs {
 		def := strings.ToLower(def)
@@ -85,9 +99,15 @@ func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeD
 			if !desc.IsPersistent {
 				gwlog.Fatalf("Entity type %s is not persistent, should not define persistent attribute: %s", desc.entityType.Name(), attr)
 			}
+		} else if def == "indexed" {
+			isIndexed = true
 		}
 	}
 
+	if isIndexed && !isPersistent {
+		gwlog.Fatalf("Entity type %s: indexed attribute %s must be persistent", desc.entityType.Name(), attr)
+	}
+
 	if isAllClient {
 		desc.allClientAttrs.Add(attr)
 	}
@@ -97,6 +117,9 @@ func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeD
 	if isPersistent {
 		desc.persistentAttrs.Add(attr)
 	}
+	if isIndexed {
+		desc.indexedAttrs.Add(attr)
+	}
 	return desc
 }
 
@@ -175,6 +198,9 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 		clientAttrs:     common.StringSet{},
 		allClientAttrs:  common.StringSet{},
 		persistentAttrs: common.StringSet{},
+		indexedAttrs:    common.StringSet{},
+		migrations:      map[int]AttrMigration{},
+		attrTypes:       map[string]*AttrType{},
 		//compositiveMethodComponentIndices: map[string][]int{},
 	}
 	registeredEntityTypes[typeName] = entityTypeDesc
@@ -189,6 +215,11 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 	gwlog.Infof(">>> RegisterEntity %s => %s <<<", typeName, entityType.Name())
 	//// define entity Attrs
 	entity.DescribeEntityType(entityTypeDesc)
+	entityTypeDesc.defineAttrFields()
+	entityTypeDesc.checkMigrations()
+	for attr := range entityTypeDesc.indexedAttrs {
+		storage.EnsureIndex(typeName, attr)
+	}
 	return entityTypeDesc
 }
 
@@ -198,7 +229,7 @@ func GetEntityTypeDesc(typeName string) *EntityTypeDesc {
 
 var entityType = reflect.TypeOf(Entity{})
 
-func createEntity(typeName string, space *Space, pos Vector3, entityID common.EntityID, data map[string]interface{}) *Entity {
+func createEntity(typeName string, space *Space, pos Vector3, entityID common.EntityID, data map[string]interface{}, isLoaded bool) *Entity {
 	entityTypeDesc, ok := registeredEntityTypes[typeName]
 	if !ok {
 		gwlog.Panicf("unknown entity type: %s", typeName)
@@ -217,9 +248,23 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 	entity.Space = nilSpace
 
 	entityManager.put(entity)
+	if isLoaded {
+		// take over entity data with a greater epoch, so that stale saves of the former owner are rejected
+		entity.epoch = storagecommon.DataEpoch(data) + 1
+		delete(data, storagecommon.EpochKey)
+	} else {
+		entity.epoch = 1
+	}
+
 	if data != nil {
 		entity.loadPersistentData(data)
+		setAttrDefaults(entity.Attrs, entityTypeDesc.attrTypes)
+		if isLoaded {
+			entity.dirtyAttrs.clear() // data is loaded from storage, so only save changes
+			entity.saveUpdates(nil)   // claim the entity data by saving the new epoch immediately
+		}
 	} else {
+		setAttrDefaults(entity.Attrs, entityTypeDesc.attrTypes)
 		entity.Save() // save immediately after creation
 	}
 
@@ -231,6 +276,7 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 	dispatchercluster.SendNotifyCreateEntity(entityID)
 
 	gwlog.Debugf("Entity %s created.", entity)
+	entity.attrsReady = true
 	gwutils.RunPanicless(func() {
 		entity.I.OnAttrsReady()
 		entity.I.OnCreated()
@@ -259,9 +305,11 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 	entity.Space = nilSpace
 	entity.Position = mdata.Pos
 	entity.yaw = mdata.Yaw
+	entity.epoch = mdata.Epoch
 
 	entityManager.put(entity)
 	entity.loadMigrateData(mdata.Attrs)
+	entityTypeDesc.migrate(entity.Attrs, mdata.SchemaVersion)
 
 	timerData := mdata.TimerData
 	if timerData != nil {
@@ -277,12 +325,13 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 	entity.syncingFromClient = mdata.SyncingFromClient
 
 	if mdata.Client != nil {
-		client := MakeGameClient(mdata.Client.ClientID, mdata.Client.GateID)
+		client := MakeGameClient(mdata.Client.ClientID, mdata.Client.GateID, mdata.Client.Identity)
 		// assign Client to the newly created
 		entity.assignClient(client) // assign Client quietly
 	}
 
 	gwlog.Debugf("Entity %s created, Client=%s", entity, entity.client)
+	entity.attrsReady = true
 	gwutils.RunPanicless(func() {
 		entity.I.OnAttrsReady()
 	})
@@ -308,9 +357,16 @@ func loadEntityLocally(typeName string, entityID common.EntityID, space *Space,
 	// load the data from storage
 	storage.Load(typeName, entityID, func(_data interface{}, err error) {
 		// callback runs in main routine
+		var data map[string]interface{}
+		var migrated bool
+		if err == nil {
+			data, migrated, err = registeredEntityTypes[typeName].prepareLoadedData(_data)
+		}
 		if err != nil {
+			// only this entity fails to load, and it can be loaded again after the data is fixed
 			dispatchercluster.SendNotifyDestroyEntity(entityID) // load entity failed, tell dispatcher
-			gwlog.Panicf("load entity %s.%s failed: %s", typeName, entityID, err)
+			gwlog.Errorf("load entity %s.%s failed: %s", typeName, entityID, err)
+			return
 		}
 
 		ex := entityManager.get(entityID) // existing entity
@@ -324,22 +380,42 @@ func loadEntityLocally(typeName string, entityID common.EntityID, space *Space,
 			space = nil // if space is destroyed before creation, just use nil space
 		}
 
-		data := _data.(map[string]interface{})
-		// need to remove NOT persistent fields from data
-		entityTypeDesc := registeredEntityTypes[typeName]
-		removeFields := []string{}
-		for k := range data {
-			if !entityTypeDesc.persistentAttrs.Contains(k) {
-				removeFields = append(removeFields, k)
-			}
-		}
-		for _, f := range removeFields {
-			delete(data, f)
+		e := createEntity(typeName, space, pos, entityID, data, true)
+		if migrated {
+			e.dirtyAttrs.markFull() // save migrated data fully
 		}
-		createEntity(typeName, space, pos, entityID, data)
 	})
 }
 
+// prepareLoadedData migrates and checks entity data loaded from storage, returns error if the data is invalid
+func (desc *EntityTypeDesc) prepareLoadedData(_data interface{}) (data map[string]interface{}, migrated bool, err error) {
+	data, ok := _data.(map[string]interface{})
+	if !ok {
+		return nil, false, errors.Errorf("wrong data type %T", _data)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			data, migrated, err = nil, false, errors.Errorf("migrate data failed: %v", r)
+		}
+	}()
+	data, migrated = desc.migrateData(data)
+	// need to remove NOT persistent fields from data
+	removeFields := []string{}
+	for k := range data {
+		if k != storagecommon.EpochKey && !desc.persistentAttrs.Contains(k) {
+			removeFields = append(removeFields, k)
+		}
+	}
+	for _, f := range removeFields {
+		delete(data, f)
+	}
+	if err := desc.checkData(data); err != nil {
+		return nil, false, err
+	}
+	return data, migrated, nil
+}
+
 func createEntitySomewhere(gameid uint16, typeName string, data map[string]interface{}) common.EntityID {
 	entityid := common.GenEntityID()
 	dispatchercluster.SendCreateEntitySomewhere(gameid, entityid, typeName, data)
@@ -348,12 +424,12 @@ func createEntitySomewhere(gameid uint16, typeName string, data map[string]inter
 
 // CreateEntityLocally creates new entity in the local game
 func CreateEntityLocally(typeName string, data map[string]interface{}) *Entity {
-	return createEntity(typeName, nil, Vector3{}, "", data)
+	return createEntity(typeName, nil, Vector3{}, "", data, false)
 }
 
 // CreateEntityLocallyWithEntityID creates new entity in the local game with specified entity ID
 func CreateEntityLocallyWithID(typeName string, data map[string]interface{}, id common.EntityID) *Entity {
-	return createEntity(typeName, nil, Vector3{}, id, data)
+	return createEntity(typeName, nil, Vector3{}, id, data, false)
 }
 
 // CreateEntitySomewhere creates new entity in any game
@@ -363,7 +439,7 @@ func CreateEntitySomewhere(gameid uint16, typeName string) common.EntityID {
 
 // OnCreateEntitySomewhere is called when CreateEntitySomewhere chooses this game
 func OnCreateEntitySomewhere(entityid common.EntityID, typeName string, data map[string]interface{}) {
-	createEntity(typeName, nil, Vector3{}, entityid, data)
+	createEntity(typeName, nil, Vector3{}, entityid, data, false)
 }
 
 // OnLoadEntitySomewhere loads entity in the local game.
@@ -547,6 +623,8 @@ func Freeze(gameid uint16) (*FreezeData, error) {
 		return nil, errors.Errorf("nil space not found")
 	}
 
+	FlushAttrChanges() // clients are kept during freezing, so send them all pending attribute changes
+
 	freeze.Entities = entityFreezeInfos
 
 	return &freeze, nil
@@ -579,7 +657,7 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 
 				var client *GameClient
 				if info.Client != nil {
-					client = MakeGameClient(info.Client.ClientID, info.Client.GateID)
+					client = MakeGameClient(info.Client.ClientID, info.Client.GateID, info.Client.Identity)
 					clients[eid] = client // save the Client to the map
 					info.Client = nil
 				}
@@ -16,17 +16,29 @@ import (
 type GameClient struct {
 	clientid common.ClientID
 	gateid   uint16
+	identity string
 	ownerid  common.EntityID
 }
 
-// MakeGameClient creates a GameClient object using Client ID and Game ID
-func MakeGameClient(clientid common.ClientID, gateid uint16) *GameClient {
+// MakeGameClient creates a GameClient object using Client ID, Gate ID and the identity verified by gate
+func MakeGameClient(clientid common.ClientID, gateid uint16, identity string) *GameClient {
 	return &GameClient{
 		clientid: clientid,
 		gateid:   gateid,
+		identity: identity,
 	}
 }
 
+// Identity returns the identity of the client verified by gate
+//
+// Identity is empty if client authentication is not enabled in gate
+func (client *GameClient) Identity() string {
+	if client == nil {
+		return ""
+	}
+	return client.identity
+}
+
 func (client *GameClient) String() string {
 	if client == nil {
 		return "GameClient<nil>"
@@ -64,47 +76,6 @@ func (client *GameClient) call(entityID common.EntityID, method string, args []i
 	}
 }
 
-// sendNotifyMapAttrChange updates MapAttr change to Client entity
-func (client *GameClient) sendNotifyMapAttrChange(entityID common.EntityID, path []interface{}, key string, val interface{}) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrChangeOnClient(client.gateid, client.clientid, entityID, path, key, val)
-	}
-}
-
-// sendNotifyMapAttrDel updates MapAttr delete to Client entity
-func (client *GameClient) sendNotifyMapAttrDel(entityID common.EntityID, path []interface{}, key string) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrDelOnClient(client.gateid, client.clientid, entityID, path, key)
-	}
-}
-
-func (client *GameClient) sendNotifyMapAttrClear(entityID common.EntityID, path []interface{}) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrClearOnClient(client.gateid, client.clientid, entityID, path)
-	}
-}
-
-// sendNotifyListAttrChange notifies Client of ListAttr item changing
-func (client *GameClient) sendNotifyListAttrChange(entityID common.EntityID, path []interface{}, index uint32, val interface{}) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrChangeOnClient(client.gateid, client.clientid, entityID, path, index, val)
-	}
-}
-
-// sendNotifyListAttrPop notify Client of ListAttr popping
-func (client *GameClient) sendNotifyListAttrPop(entityID common.EntityID, path []interface{}) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrPopOnClient(client.gateid, client.clientid, entityID, path)
-	}
-}
-
-// sendNotifyListAttrAppend notify entity of ListAttr appending
-func (client *GameClient) sendNotifyListAttrAppend(entityID common.EntityID, path []interface{}, val interface{}) {
-	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrAppendOnClient(client.gateid, client.clientid, entityID, path, val)
-	}
-}
-
 func (client *GameClient) sendSetClientFilterProp(key, val string) {
 	if client != nil {
 		client.selectDispatcher().SendSetClientFilterProp(client.gateid, client.clientid, key, val)
@@ -12,6 +12,8 @@ type ISpace interface {
 	// Space Operations
 	OnEntityEnterSpace(entity *Entity) // Called when any entity enters space
 	OnEntityLeaveSpace(entity *Entity) // Called when any entity leaves space
+	// AOI related
+	GetTowerRange() (minX, minZ, maxX, maxZ Coord) // Returns the range of towers for tower AOI
 	// Game releated callbacks on nil space only
 	OnGameReady()
 }
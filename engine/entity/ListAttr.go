@@ -69,6 +69,34 @@ func (a *ListAttr) clearOwner() {
 	}
 }
 
+// clearPath clears cached paths recursively, which is required when index of the ListAttr or its parent changes
+func (a *ListAttr) clearPath() {
+	a.path = nil
+
+	for _, v := range a.items {
+		switch a := v.(type) {
+		case *MapAttr:
+			a.clearPath()
+		case *ListAttr:
+			a.clearPath()
+		}
+	}
+}
+
+// renumberItems updates keys of MapAttr and ListAttr items in [start, end) after items are moved
+func (a *ListAttr) renumberItems(start int, end int) {
+	for i := start; i < end; i++ {
+		switch sa := a.items[i].(type) {
+		case *MapAttr:
+			sa.pkey = i
+			sa.clearPath()
+		case *ListAttr:
+			sa.pkey = i
+			sa.clearPath()
+		}
+	}
+}
+
 func (a *ListAttr) setParent(owner *Entity, parent interface{}, pkey interface{}, flag attrFlag) {
 	a.parent = parent
 	a.pkey = pkey
@@ -93,7 +121,14 @@ func (a *ListAttr) setOwner(owner *Entity, flag attrFlag) {
 
 // Set sets item value
 func (a *ListAttr) set(index int, val interface{}) {
+	if a.owner != nil {
+		a.owner.checkAttr(a.getPathFromOwner(), index, val)
+		a.owner.checkListAttrLimits(a, index, val, false)
+	}
+
+	old := a.items[index]
 	a.items[index] = val
+	a.markDirty(index)
 	switch sa := val.(type) {
 	case *MapAttr:
 		// val is MapAttr, set parent and owner accordingly
@@ -113,6 +148,10 @@ func (a *ListAttr) set(index int, val interface{}) {
 	default:
 		a.sendListAttrChangeToClients(index, val)
 	}
+
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), index, old, val)
+	}
 }
 
 func (a *ListAttr) sendListAttrChangeToClients(index int, val interface{}) {
@@ -135,6 +174,30 @@ func (a *ListAttr) sendListAttrAppendToClients(val interface{}) {
 	}
 }
 
+func (a *ListAttr) sendListAttrInsertToClients(index int, val interface{}) {
+	if owner := a.owner; owner != nil {
+		owner.sendListAttrInsertToClients(a, index, val)
+	}
+}
+
+func (a *ListAttr) sendListAttrRemoveToClients(index int) {
+	if owner := a.owner; owner != nil {
+		owner.sendListAttrRemoveToClients(a, index)
+	}
+}
+
+func (a *ListAttr) sendListAttrSwapToClients(i, j int) {
+	if owner := a.owner; owner != nil {
+		owner.sendListAttrSwapToClients(a, i, j)
+	}
+}
+
+func (a *ListAttr) sendListAttrTruncateToClients(size int) {
+	if owner := a.owner; owner != nil {
+		owner.sendListAttrTruncateToClients(a, size)
+	}
+}
+
 func (a *ListAttr) getPathFromOwner() []interface{} {
 	if a.path == nil {
 		a.path = a._getPathFromOwner()
@@ -223,6 +286,7 @@ func (a *ListAttr) pop() interface{} {
 	size := len(a.items)
 	val := a.items[size-1]
 	a.items = a.items[:size-1]
+	a.markSelfDirty()
 
 	switch sa := val.(type) {
 	case *MapAttr:
@@ -232,6 +296,9 @@ func (a *ListAttr) pop() interface{} {
 	}
 
 	a.sendListAttrPopToClients()
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), size-1, val, nil)
+	}
 	return val
 }
 
@@ -263,8 +330,14 @@ func (a *ListAttr) PopMapAttr() *MapAttr {
 
 // append puts item to the end of list
 func (a *ListAttr) append(val interface{}) {
+	if a.owner != nil {
+		a.owner.checkAttr(a.getPathFromOwner(), len(a.items), val)
+		a.owner.checkListAttrLimits(a, len(a.items), val, true)
+	}
+
 	a.items = append(a.items, val)
 	index := len(a.items) - 1
+	a.markSelfDirty()
 
 	switch sa := val.(type) {
 	case *MapAttr:
@@ -273,11 +346,7 @@ func (a *ListAttr) append(val interface{}) {
 			gwlog.Panicf("MapAttr reused in append")
 		}
 
-		sa.parent = a
-		sa.owner = a.owner
-		sa.pkey = index
-		sa.flag = a.flag
-
+		sa.setParent(a.owner, a, index, a.flag)
 		a.sendListAttrAppendToClients(sa.ToMap())
 	case *ListAttr:
 		if sa.parent != nil || sa.owner != nil || sa.pkey != nil {
@@ -289,6 +358,167 @@ func (a *ListAttr) append(val interface{}) {
 	default:
 		a.sendListAttrAppendToClients(val)
 	}
+
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), index, nil, val)
+	}
+}
+
+// insert puts item at the index, items after the index are moved backward
+func (a *ListAttr) insert(index int, val interface{}) {
+	if index < 0 || index > len(a.items) {
+		gwlog.Panicf("ListAttr insert: index %d out of range [0, %d]", index, len(a.items))
+	}
+	if a.owner != nil {
+		a.owner.checkAttr(a.getPathFromOwner(), index, val)
+		a.owner.checkListAttrLimits(a, index, val, true)
+	}
+
+	switch sa := val.(type) {
+	case *MapAttr:
+		if sa.parent != nil || sa.owner != nil || sa.pkey != nil {
+			gwlog.Panicf("MapAttr reused in insert")
+		}
+	case *ListAttr:
+		if sa.parent != nil || sa.owner != nil || sa.pkey != nil {
+			gwlog.Panicf("ListAttr reused in insert")
+		}
+	}
+
+	a.items = append(a.items, nil)
+	copy(a.items[index+1:], a.items[index:])
+	a.items[index] = val
+	a.renumberItems(index+1, len(a.items))
+	a.markSelfDirty()
+
+	switch sa := val.(type) {
+	case *MapAttr:
+		sa.setParent(a.owner, a, index, a.flag)
+		a.sendListAttrInsertToClients(index, sa.ToMap())
+	case *ListAttr:
+		sa.setParent(a.owner, a, index, a.flag)
+		a.sendListAttrInsertToClients(index, sa.ToList())
+	default:
+		a.sendListAttrInsertToClients(index, val)
+	}
+
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), index, nil, val)
+	}
+}
+
+// InsertInt puts int value at the index
+func (a *ListAttr) InsertInt(index int, v int64) {
+	a.insert(index, v)
+}
+
+// InsertFloat puts float value at the index
+func (a *ListAttr) InsertFloat(index int, v float64) {
+	a.insert(index, v)
+}
+
+// InsertBool puts bool value at the index
+func (a *ListAttr) InsertBool(index int, v bool) {
+	a.insert(index, v)
+}
+
+// InsertStr puts string value at the index
+func (a *ListAttr) InsertStr(index int, v string) {
+	a.insert(index, v)
+}
+
+// InsertMapAttr puts MapAttr value at the index
+func (a *ListAttr) InsertMapAttr(index int, attr *MapAttr) {
+	a.insert(index, attr)
+}
+
+// InsertListAttr puts ListAttr value at the index
+func (a *ListAttr) InsertListAttr(index int, attr *ListAttr) {
+	a.insert(index, attr)
+}
+
+// RemoveAt removes the item at the index and returns it, items after the index are moved forward
+func (a *ListAttr) RemoveAt(index int) interface{} {
+	if index < 0 || index >= len(a.items) {
+		gwlog.Panicf("ListAttr RemoveAt: index %d out of range [0, %d)", index, len(a.items))
+	}
+
+	val := a.items[index]
+	copy(a.items[index:], a.items[index+1:])
+	a.items[len(a.items)-1] = nil
+	a.items = a.items[:len(a.items)-1]
+	a.renumberItems(index, len(a.items))
+	a.markSelfDirty()
+
+	switch sa := val.(type) {
+	case *MapAttr:
+		sa.removeFromParent()
+	case *ListAttr:
+		sa.removeFromParent()
+	}
+
+	a.sendListAttrRemoveToClients(index)
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), index, val, nil)
+	}
+	return val
+}
+
+// Swap swaps items at index i and j
+func (a *ListAttr) Swap(i, j int) {
+	if i < 0 || i >= len(a.items) || j < 0 || j >= len(a.items) {
+		gwlog.Panicf("ListAttr Swap: index %d or %d out of range [0, %d)", i, j, len(a.items))
+	}
+	if i == j {
+		return
+	}
+
+	a.items[i], a.items[j] = a.items[j], a.items[i]
+	a.renumberItems(i, i+1)
+	a.renumberItems(j, j+1)
+	a.markDirty(i)
+	a.markDirty(j)
+
+	a.sendListAttrSwapToClients(i, j)
+	if a.owner != nil {
+		path := a.getPathFromOwner()
+		a.owner.onAttrChanged(path, i, a.items[j], a.items[i])
+		a.owner.onAttrChanged(path, j, a.items[i], a.items[j])
+	}
+}
+
+// Truncate removes items from the end of list, so that the list has at most size items
+func (a *ListAttr) Truncate(size int) {
+	if size < 0 {
+		gwlog.Panicf("ListAttr Truncate: size %d < 0", size)
+	}
+	if size >= len(a.items) {
+		return
+	}
+
+	removed := append([]interface{}{}, a.items[size:]...)
+	for i := size; i < len(a.items); i++ {
+		a.items[i] = nil
+	}
+	a.items = a.items[:size]
+	a.markSelfDirty()
+
+	for _, val := range removed {
+		switch sa := val.(type) {
+		case *MapAttr:
+			sa.removeFromParent()
+		case *ListAttr:
+			sa.removeFromParent()
+		}
+	}
+
+	a.sendListAttrTruncateToClients(size)
+	if a.owner != nil {
+		path := a.getPathFromOwner()
+		for i := len(removed) - 1; i >= 0; i-- {
+			a.owner.onAttrChanged(path, size+i, removed[i], nil)
+		}
+	}
 }
 
 // SetInt sets int value at the index
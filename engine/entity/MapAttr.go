@@ -81,8 +81,15 @@ func (a *MapAttr) ForEach(f func(key string, val interface{})) {
 
 // Set sets the key-attribute pair in MapAttr
 func (a *MapAttr) set(key string, val interface{}) {
+	if a.owner != nil {
+		a.owner.checkAttr(a.getPathFromOwner(), key, val)
+		a.owner.checkMapAttrLimits(a, key, val)
+	}
+
 	var flag attrFlag
+	old := a.attrs[key]
 	a.attrs[key] = val
+	a.markDirty(key)
 	switch sa := val.(type) {
 	case *MapAttr:
 		// val is MapAttr, set parent and owner accordingly
@@ -113,6 +120,10 @@ func (a *MapAttr) set(key string, val interface{}) {
 	default:
 		a.sendAttrChangeToClients(key, val)
 	}
+
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), key, old, val)
+	}
 }
 
 // SetInt sets int value at the key
@@ -298,6 +309,7 @@ func (a *MapAttr) pop(key string) interface{} {
 	}
 
 	delete(a.attrs, key)
+	a.markDirty(key)
 	switch sa := val.(type) {
 	case *MapAttr:
 		sa.removeFromParent()
@@ -306,6 +318,9 @@ func (a *MapAttr) pop(key string) interface{} {
 	}
 
 	a.sendAttrDelToClients(key)
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), key, val, nil)
+	}
 	return val
 }
 
@@ -391,7 +406,11 @@ func (a *MapAttr) Clear() {
 		}
 	}
 
+	a.markSelfDirty()
 	a.sendAttrClearToClients()
+	if a.owner != nil {
+		a.owner.onAttrChanged(a.getPathFromOwner(), nil, nil, a)
+	}
 }
 
 // ToMap converts MapAttr to native map, recursively
@@ -491,6 +510,20 @@ func (a *MapAttr) clearOwner() {
 	}
 }
 
+// clearPath clears cached paths recursively, which is required when index of the parent ListAttr changes
+func (a *MapAttr) clearPath() {
+	a.path = nil
+
+	for _, v := range a.attrs {
+		switch a := v.(type) {
+		case *MapAttr:
+			a.clearPath()
+		case *ListAttr:
+			a.clearPath()
+		}
+	}
+}
+
 func (a *MapAttr) setParent(owner *Entity, parent interface{}, pkey interface{}, flag attrFlag) {
 	a.parent = parent
 	a.pkey = pkey
@@ -3,7 +3,7 @@ package entity
 import (
 	"fmt"
 
-	"github.com/xiaonanln/go-aoi"
+	"github.com/xiaonanln/goworld/engine/aoi"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -14,6 +14,16 @@ const (
 	_SPACE_ENTITY_TYPE    = "__space__"
 	_SPACE_KIND_ATTR_KEY  = "_K"
 	_SPACE_ENABLE_AOI_KEY = "_EnableAOI"
+	_SPACE_AOI_KIND_KEY   = "_AOIKind"
+	_SPACE_TOWER_SIZE_KEY = "_TowerSize"
+)
+
+// kinds of AOI managers, saved in space attrs for restoring
+const (
+	aoiKindXZList  = ""
+	aoiKindTower   = "tower"
+	aoiKindTower3D = "tower3d"
+	aoiKindCustom  = "custom"
 )
 
 var (
@@ -27,10 +37,12 @@ type Space struct {
 	Entity
 
 	entities EntitySet
+	index    *spaceIndex
 	Kind     int
 	I        ISpace
 
-	aoiMgr aoi.AOIManager
+	aoiMgr             aoi.AOIManager
+	defaultAOIDistance Coord
 }
 
 func (space *Space) String() string {
@@ -53,13 +65,17 @@ func (space *Space) GetSpaceRange() (minX, minY, maxX, maxY Coord) {
 	return -1000, -1000, 1000, 1000
 }
 
-func (space *Space) GetTowerRange() (minX, minY, maxX, maxY Coord) {
+// GetTowerRange returns the range of towers on X-Z plane for tower AOI
+//
+// Custom space type can override to provide its own range
+func (space *Space) GetTowerRange() (minX, minZ, maxX, maxZ Coord) {
 	return -1000, -1000, 1000, 1000
 }
 
 // OnInit initialize Space entity
 func (space *Space) OnInit() {
 	space.entities = EntitySet{}
+	space.index = newSpaceIndex()
 	space.I = space.Entity.I.(ISpace)
 
 	space.I.OnSpaceInit()
@@ -88,7 +104,66 @@ func (space *Space) OnCreated() {
 	space.I.OnSpaceCreated()
 }
 
+// EnableAOI enables AOI for the space using XZ list AOI
+//
+// Entities whose type uses AOI will be interested in other entities in its AOI distance. Entities whose type
+// does not specify AOI distance use the default AOI distance of space.
 func (space *Space) EnableAOI(defaultAOIDistance Coord) {
+	space.enableAOI(defaultAOIDistance, aoiKindXZList, 0)
+}
+
+// EnableTowerAOI enables AOI for the space using tower AOI on X-Z plane
+//
+// Tower AOI performs better than XZ list AOI in crowded spaces. Towers are laid out in the range of GetTowerRange.
+func (space *Space) EnableTowerAOI(defaultAOIDistance Coord, towerSize Coord) {
+	space.enableAOI(defaultAOIDistance, aoiKindTower, towerSize)
+}
+
+// EnableTower3DAOI enables AOI for the space using tower AOI which also takes Y axis into account
+func (space *Space) EnableTower3DAOI(defaultAOIDistance Coord, towerSize Coord) {
+	space.enableAOI(defaultAOIDistance, aoiKindTower3D, towerSize)
+}
+
+// EnableAOIWithManager enables AOI for the space using custom AOI manager
+//
+// Custom AOI manager can not be restored after freezing, so the restored space uses XZ list AOI instead.
+func (space *Space) EnableAOIWithManager(defaultAOIDistance Coord, aoiMgr aoi.AOIManager) {
+	space.checkEnableAOI(defaultAOIDistance)
+	space.Attrs.SetStr(_SPACE_AOI_KIND_KEY, aoiKindCustom)
+	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
+	space.defaultAOIDistance = defaultAOIDistance
+	space.aoiMgr = aoiMgr
+}
+
+func (space *Space) enableAOI(defaultAOIDistance Coord, kind string, towerSize Coord) {
+	space.checkEnableAOI(defaultAOIDistance)
+
+	var aoiMgr aoi.AOIManager
+	switch kind {
+	case aoiKindXZList:
+		aoiMgr = aoi.NewXZListAOIManager()
+	case aoiKindTower, aoiKindTower3D:
+		if towerSize <= 0 {
+			gwlog.Panicf("%s.EnableAOI: tower size should be positive, but is %v", space, towerSize)
+		}
+		minX, minZ, maxX, maxZ := space.I.GetTowerRange()
+		if kind == aoiKindTower {
+			aoiMgr = aoi.NewTowerAOIManager(aoi.Coord(minX), aoi.Coord(maxX), aoi.Coord(minZ), aoi.Coord(maxZ), aoi.Coord(towerSize))
+		} else {
+			aoiMgr = aoi.NewTower3DAOIManager(aoi.Coord(minX), aoi.Coord(maxX), aoi.Coord(minZ), aoi.Coord(maxZ), aoi.Coord(towerSize))
+		}
+		space.Attrs.SetFloat(_SPACE_TOWER_SIZE_KEY, float64(towerSize))
+	default:
+		gwlog.Panicf("%s.EnableAOI: unknown AOI kind: %s", space, kind)
+	}
+
+	space.Attrs.SetStr(_SPACE_AOI_KIND_KEY, kind)
+	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
+	space.defaultAOIDistance = defaultAOIDistance
+	space.aoiMgr = aoiMgr
+}
+
+func (space *Space) checkEnableAOI(defaultAOIDistance Coord) {
 	if defaultAOIDistance <= 0 {
 		gwlog.Panicf("defaultAOIDistance < 0")
 	}
@@ -100,9 +175,6 @@ func (space *Space) EnableAOI(defaultAOIDistance Coord) {
 	if len(space.entities) > 0 {
 		gwlog.Panicf("%s is already using AOI", space)
 	}
-
-	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
-	space.aoiMgr = aoi.NewXZListAOIManager(aoi.Coord(defaultAOIDistance))
 }
 
 // OnRestored is called when space entity is restored
@@ -111,7 +183,12 @@ func (space *Space) OnRestored() {
 	//gwlog.Debugf("space %s restored: atts=%+v", space, space.Attrs)
 	aoidist := space.GetFloat(_SPACE_ENABLE_AOI_KEY)
 	if aoidist > 0 {
-		space.EnableAOI(Coord(aoidist))
+		kind := space.GetStr(_SPACE_AOI_KIND_KEY)
+		if kind == aoiKindCustom {
+			gwlog.Warnf("%s is restored with custom AOI manager, use XZ list AOI instead", space)
+			kind = aoiKindXZList
+		}
+		space.enableAOI(Coord(aoidist), kind, Coord(space.GetFloat(_SPACE_TOWER_SIZE_KEY)))
 	}
 }
 
@@ -166,7 +243,7 @@ func (space *Space) IsNil() bool {
 
 // CreateEntity creates a new local entity in this space
 func (space *Space) CreateEntity(typeName string, pos Vector3) {
-	createEntity(typeName, space, pos, "", nil)
+	createEntity(typeName, space, pos, "", nil, false)
 }
 
 // LoadEntity loads a entity of specified entityID to the space
@@ -191,6 +268,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 
 	entity.Space = space
 	space.entities.Add(entity)
+	space.index.add(entity, pos)
 	entity.Position = pos
 
 	entity.syncInfoFlag |= sifSyncOwnClient | sifSyncNeighborClients
@@ -199,7 +277,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 		entity.client.sendCreateEntity(&space.Entity, false) // create Space entity before every other entities
 
 		if space.aoiMgr != nil && entity.IsUseAOI() {
-			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
+			space.enterAOI(entity, pos)
 		}
 
 		gwutils.RunPanicless(func() {
@@ -209,13 +287,22 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	} else {
 		// restoring ...
 		if space.aoiMgr != nil && entity.IsUseAOI() {
-			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
+			space.enterAOI(entity, pos)
 		}
 
 	}
 	//space.verifyAOICorrectness(entity)
 }
 
+func (space *Space) enterAOI(entity *Entity, pos Vector3) {
+	aoiDistance := entity.typeDesc.aoiDistance
+	if aoiDistance <= 0 {
+		aoiDistance = space.defaultAOIDistance
+	}
+	entity.aoi.SetDist(aoi.Coord(aoiDistance))
+	space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Y), aoi.Coord(pos.Z))
+}
+
 func (space *Space) leave(entity *Entity) {
 	if entity.Space != space {
 		gwlog.Panicf("%s.leave(%s): entity is not in this Space", space, entity)
@@ -228,6 +315,7 @@ func (space *Space) leave(entity *Entity) {
 
 	// remove from Space entities
 	space.entities.Del(entity)
+	space.index.remove(entity)
 	entity.Space = nilSpace
 
 	if space.aoiMgr != nil && entity.IsUseAOI() {
@@ -242,12 +330,17 @@ func (space *Space) leave(entity *Entity) {
 }
 
 func (space *Space) move(entity *Entity, newPos Vector3) {
-	if space.aoiMgr == nil {
+	entity.Position = newPos
+	if space.IsNil() {
 		return
 	}
 
-	entity.Position = newPos
-	space.aoiMgr.Moved(&entity.aoi, aoi.Coord(newPos.X), aoi.Coord(newPos.Z))
+	space.index.move(entity, newPos)
+	if space.aoiMgr == nil || !entity.IsUseAOI() {
+		return
+	}
+
+	space.aoiMgr.Moved(&entity.aoi, aoi.Coord(newPos.X), aoi.Coord(newPos.Y), aoi.Coord(newPos.Z))
 	gwlog.Debugf("%s: %s move to %v", space, entity, newPos)
 }
 
@@ -0,0 +1,186 @@
+package entity
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// dirtyAttrs tracks paths of persistent attributes changed since last save, so that entity can be saved incrementally
+//
+// Only paths are tracked, values are read from attributes when saving. A dirty path covers all its sub paths.
+type dirtyAttrs struct {
+	full             bool                     // entity should be fully saved
+	paths            map[string][]interface{} // dirty paths from root, indexed by encoded path
+	incrementalSaves int                      // number of incremental saves since last full save
+}
+
+func encodeAttrPath(path []interface{}) string {
+	var sb strings.Builder
+	for i, p := range path {
+		if i > 0 {
+			sb.WriteByte(0)
+		}
+		switch k := p.(type) {
+		case string:
+			sb.WriteString(k)
+		case int:
+			sb.WriteString(strconv.Itoa(k))
+		}
+	}
+	return sb.String()
+}
+
+// markFull marks entity to be fully saved
+func (d *dirtyAttrs) markFull() {
+	d.full = true
+	d.paths = nil
+}
+
+// mark marks the path dirty, path is from the attribute to root, as returned by getPathFromOwner
+func (d *dirtyAttrs) mark(pathFromLeaf []interface{}) {
+	if d.full {
+		return
+	}
+
+	path := make([]interface{}, len(pathFromLeaf))
+	for i, p := range pathFromLeaf {
+		path[len(path)-1-i] = p
+	}
+
+	if d.paths == nil {
+		d.paths = map[string][]interface{}{}
+	}
+	d.paths[encodeAttrPath(path)] = path
+	if len(d.paths) > consts.MAX_DIRTY_ATTRS_FOR_INCREMENTAL_SAVE {
+		d.markFull() // too many changes, just save the whole entity
+	}
+}
+
+func (d *dirtyAttrs) clear() {
+	d.full = false
+	d.paths = nil
+}
+
+// updates returns updates of dirty paths using current values in attrs
+func (d *dirtyAttrs) updates(attrs *MapAttr) []storagecommon.AttrUpdate {
+	paths := make([][]interface{}, 0, len(d.paths))
+	for _, path := range d.paths {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i]) < len(paths[j])
+	})
+
+	updates := make([]storagecommon.AttrUpdate, 0, len(paths))
+	covered := map[string]struct{}{}
+outer:
+	for _, path := range paths {
+		for i := 1; i < len(path); i++ {
+			if _, ok := covered[encodeAttrPath(path[:i])]; ok {
+				continue outer // parent is updated as a whole
+			}
+		}
+		covered[encodeAttrPath(path)] = struct{}{}
+
+		if val, ok := resolveAttrPath(attrs, path); ok {
+			updates = append(updates, storagecommon.AttrUpdate{Path: path, Value: val})
+		} else {
+			updates = append(updates, storagecommon.AttrUpdate{Path: path, Unset: true})
+		}
+	}
+	return updates
+}
+
+// resolveAttrPath returns the native value of attribute at path
+func resolveAttrPath(attrs *MapAttr, path []interface{}) (interface{}, bool) {
+	var val interface{} = attrs
+	for _, p := range path {
+		switch a := val.(type) {
+		case *MapAttr:
+			v, ok := a.attrs[p.(string)]
+			if !ok {
+				return nil, false
+			}
+			val = v
+		case *ListAttr:
+			index := p.(int)
+			if index >= len(a.items) {
+				return nil, false
+			}
+			val = a.items[index]
+		default:
+			return nil, false
+		}
+	}
+
+	switch a := val.(type) {
+	case *MapAttr:
+		return a.ToMap(), true
+	case *ListAttr:
+		return a.ToList(), true
+	default:
+		return val, true
+	}
+}
+
+// isPersistentKey returns if the item of key in MapAttr is persistent
+func (a *MapAttr) isPersistentKey(key string) bool {
+	if a == a.owner.Attrs {
+		return a.owner.typeDesc.persistentAttrs.Contains(key)
+	}
+	return a.flag&afPersistent != 0
+}
+
+// markDirty marks the item of key dirty
+func (a *MapAttr) markDirty(key string) {
+	if a.owner == nil || !a.isPersistentKey(key) {
+		return
+	}
+	a.owner.dirtyAttrs.mark(append([]interface{}{key}, a.getPathFromOwner()...))
+}
+
+// markSelfDirty marks the whole MapAttr dirty
+func (a *MapAttr) markSelfDirty() {
+	if a.owner == nil || a.flag&afPersistent == 0 {
+		return
+	}
+	a.owner.dirtyAttrs.mark(a.getPathFromOwner())
+}
+
+// markDirty marks the item at index dirty
+func (a *ListAttr) markDirty(index int) {
+	if a.owner == nil || a.flag&afPersistent == 0 {
+		return
+	}
+	a.owner.dirtyAttrs.mark(append([]interface{}{index}, a.getPathFromOwner()...))
+}
+
+// markSelfDirty marks the whole ListAttr dirty
+func (a *ListAttr) markSelfDirty() {
+	if a.owner == nil || a.flag&afPersistent == 0 {
+		return
+	}
+	a.owner.dirtyAttrs.mark(a.getPathFromOwner())
+}
+
+// collectSaveData returns the full persistent data if entity should be fully saved, otherwise returns updates of dirty attributes
+func (e *Entity) collectSaveData() (data map[string]interface{}, updates []storagecommon.AttrUpdate) {
+	if !e.dirtyAttrs.full && len(e.dirtyAttrs.paths) > 0 {
+		if e.dirtyAttrs.incrementalSaves++; e.dirtyAttrs.incrementalSaves > consts.MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES {
+			e.dirtyAttrs.markFull() // save fully now and then, which also refreshes the encoded size
+		}
+	}
+
+	if e.dirtyAttrs.full {
+		data = e.getPersistentData()
+		e.dirtyAttrs.incrementalSaves = 0
+	} else if len(e.dirtyAttrs.paths) > 0 {
+		updates = e.dirtyAttrs.updates(e.Attrs)
+	}
+	e.dirtyAttrs.clear()
+	return
+}
@@ -0,0 +1,145 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type DirtyTestEntity struct {
+	Entity
+}
+
+func (e *DirtyTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("a", "Persistent")
+	desc.DefineAttr("m", "Persistent", "Client")
+	desc.DefineAttr("l", "Persistent")
+	desc.DefineAttr("tmp")
+}
+
+// normalizeData converts data to the form loaded from storage
+func normalizeData(t *testing.T, data map[string]interface{}) map[string]interface{} {
+	b, err := netutil.MSG_PACKER.PackMsg(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDirtyAttrsSave(t *testing.T) {
+	RegisterEntity("DirtyTestEntity", &DirtyTestEntity{}, false)
+	e := CreateEntityLocally("DirtyTestEntity", nil)
+
+	saved := normalizeData(t, e.getPersistentData()) // simulates data in storage, entity is fully saved on creation
+	fullSaves, incrementalSaves := 0, 0
+	save := func() {
+		data, updates := e.collectSaveData()
+		if data != nil {
+			saved = normalizeData(t, data)
+			fullSaves++
+		} else if len(updates) > 0 {
+			if err := storagecommon.ApplyUpdates(saved, updates); err != nil {
+				t.Fatal(err)
+			}
+			saved = normalizeData(t, saved)
+			incrementalSaves++
+		}
+
+		if expected := normalizeData(t, e.getPersistentData()); !reflect.DeepEqual(saved, expected) {
+			t.Fatalf("saved data mismatch:\nsaved:    %v\nexpected: %v", saved, expected)
+		}
+	}
+
+	e.Attrs.SetInt("a", 1)
+	e.Attrs.SetInt("tmp", 1)
+	m := e.Attrs.GetMapAttr("m")
+	m.SetStr("name", "test")
+	sub := NewMapAttr()
+	sub.SetInt("x", 1)
+	m.SetMapAttr("sub", sub)
+	l := e.Attrs.GetListAttr("l")
+	l.AppendInt(1)
+	l.AppendStr("2")
+	save()
+
+	sub.SetInt("x", 2)
+	sub.SetInt("y", 3)
+	l.SetInt(0, 10)
+	item := NewMapAttr()
+	l.AppendMapAttr(item)
+	save()
+
+	item.SetStr("z", "z") // item of list appended as MapAttr
+	m.Del("name")
+	sub.Del("y")
+	save()
+
+	l.PopMapAttr()
+	l.PopStr()
+	sub.Clear()
+	e.Attrs.SetInt("tmp", 2) // not persistent, so nothing to save
+	save()
+
+	// replace sub map, changes in the old sub map should not be saved
+	m.PopMapAttr("sub")
+	sub.SetInt("old", 1)
+	newSub := NewMapAttr()
+	newSub.SetListAttr("nl", NewListAttr())
+	m.SetMapAttr("sub", newSub)
+	newSub.GetListAttr("nl").AppendFloat(1.5)
+	save()
+
+	// too many changes, entity is fully saved
+	for i := 0; i < consts.MAX_DIRTY_ATTRS_FOR_INCREMENTAL_SAVE+1; i++ {
+		m.SetInt(string(rune('A'+i%26))+string(rune('a'+i/26)), int64(i))
+	}
+	save()
+
+	m.SetInt("Aa", -1)
+	save()
+
+	if fullSaves != 1 {
+		t.Errorf("should save fully 1 time, but %d", fullSaves)
+	}
+	if incrementalSaves != 6 {
+		t.Errorf("should save incrementally 6 times, but %d", incrementalSaves)
+	}
+}
+
+func TestPeriodicFullSave(t *testing.T) {
+	RegisterEntity("PeriodicSaveTestEntity", &DirtyTestEntity{}, false)
+	e := CreateEntityLocally("PeriodicSaveTestEntity", nil)
+	e.collectSaveData()
+
+	for i := 1; i <= consts.MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES*2+1; i++ {
+		e.Attrs.SetInt("a", int64(i))
+		data, updates := e.collectSaveData()
+		if full := i%(consts.MAX_INCREMENTAL_SAVES_BETWEEN_FULL_SAVES+1) == 0; full != (data != nil) || full == (len(updates) > 0) {
+			t.Fatalf("save %d: should save fully: %v, but data=%v, updates=%v", i, full, data, updates)
+		}
+	}
+}
+
+func TestLoadedEntityEpoch(t *testing.T) {
+	RegisterEntity("EpochTestEntity", &DirtyTestEntity{}, false)
+	e := createEntity("EpochTestEntity", nil, Vector3{}, "", map[string]interface{}{storagecommon.EpochKey: 5, "a": 1}, true)
+	if e.epoch != 6 {
+		t.Errorf("loaded entity should take a greater epoch than stored, but epoch is %d", e.epoch)
+	}
+	if e.Attrs.HasKey(storagecommon.EpochKey) || e.Attrs.GetInt("a") != 1 {
+		t.Errorf("wrong attrs loaded: %v", e.Attrs.ToMap())
+	}
+
+	created := CreateEntityLocally("EpochTestEntity", nil)
+	if created.epoch != 1 {
+		t.Errorf("created entity should have epoch 1, but epoch is %d", created.epoch)
+	}
+}
@@ -0,0 +1,178 @@
+package entity
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Struct-backed attributes
+//
+// Fields of the following types with tag `goworld:"name,flags..."` in entity struct (or in structs embedded in entity struct)
+// are defined as attributes automatically and bound to Attrs of entity, e.g.
+//
+//	type Avatar struct {
+//		entity.Entity
+//		Level entity.IntField `goworld:"level,persistent,client"`
+//	}
+//
+// Flags are same as attribute defs of DefineAttr. Reads and writes of fields go through Attrs,
+// so changes are synced to clients and saved in the same way as Attrs.
+
+type attrFieldBinder interface {
+	bind(attrs *MapAttr, key string)
+	attrType() *AttrType
+}
+
+type attrField struct {
+	attrs *MapAttr
+	key   string
+}
+
+func (f *attrField) bind(attrs *MapAttr, key string) {
+	f.attrs = attrs
+	f.key = key
+}
+
+// Key returns the attribute key of field
+func (f *attrField) Key() string {
+	return f.key
+}
+
+// Has returns if the attribute is set
+func (f *attrField) Has() bool {
+	return f.attrs.HasKey(f.key)
+}
+
+// Del deletes the attribute
+func (f *attrField) Del() {
+	f.attrs.Del(f.key)
+}
+
+// IntField is an int attribute bound to entity Attrs
+type IntField struct{ attrField }
+
+func (f *IntField) attrType() *AttrType { return IntType() }
+
+// Get returns the attribute value
+func (f *IntField) Get() int64 { return f.attrs.GetInt(f.key) }
+
+// Set sets the attribute value
+func (f *IntField) Set(v int64) { f.attrs.SetInt(f.key, v) }
+
+// FloatField is a float attribute bound to entity Attrs
+type FloatField struct{ attrField }
+
+func (f *FloatField) attrType() *AttrType { return FloatType() }
+
+// Get returns the attribute value
+func (f *FloatField) Get() float64 { return f.attrs.GetFloat(f.key) }
+
+// Set sets the attribute value
+func (f *FloatField) Set(v float64) { f.attrs.SetFloat(f.key, v) }
+
+// BoolField is a bool attribute bound to entity Attrs
+type BoolField struct{ attrField }
+
+func (f *BoolField) attrType() *AttrType { return BoolType() }
+
+// Get returns the attribute value
+func (f *BoolField) Get() bool { return f.attrs.GetBool(f.key) }
+
+// Set sets the attribute value
+func (f *BoolField) Set(v bool) { f.attrs.SetBool(f.key, v) }
+
+// StrField is a string attribute bound to entity Attrs
+type StrField struct{ attrField }
+
+func (f *StrField) attrType() *AttrType { return StrType() }
+
+// Get returns the attribute value
+func (f *StrField) Get() string { return f.attrs.GetStr(f.key) }
+
+// Set sets the attribute value
+func (f *StrField) Set(v string) { f.attrs.SetStr(f.key, v) }
+
+// MapField is a MapAttr attribute bound to entity Attrs
+type MapField struct{ attrField }
+
+func (f *MapField) attrType() *AttrType { return MapOfType(nil) }
+
+// Get returns the MapAttr, which is created if not exists
+func (f *MapField) Get() *MapAttr { return f.attrs.GetMapAttr(f.key) }
+
+// Set sets the MapAttr
+func (f *MapField) Set(v *MapAttr) { f.attrs.SetMapAttr(f.key, v) }
+
+// ListField is a ListAttr attribute bound to entity Attrs
+type ListField struct{ attrField }
+
+func (f *ListField) attrType() *AttrType { return ListType(nil) }
+
+// Get returns the ListAttr, which is created if not exists
+func (f *ListField) Get() *ListAttr { return f.attrs.GetListAttr(f.key) }
+
+// Set sets the ListAttr
+func (f *ListField) Set(v *ListAttr) { f.attrs.SetListAttr(f.key, v) }
+
+var attrFieldBinderType = reflect.TypeOf((*attrFieldBinder)(nil)).Elem()
+
+// attrFieldDesc describes a struct field bound to attribute
+type attrFieldDesc struct {
+	index []int
+	key   string
+}
+
+// defineAttrFields defines attributes of tagged fields in entity struct
+func (desc *EntityTypeDesc) defineAttrFields() {
+	desc.visitAttrFields(desc.entityType, nil)
+}
+
+func (desc *EntityTypeDesc) visitAttrFields(structType reflect.Type, index []int) {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		tag, ok := field.Tag.Lookup("goworld")
+		if !ok {
+			if field.Anonymous && field.PkgPath == "" && field.Type.Kind() == reflect.Struct && field.Type != entityType {
+				desc.visitAttrFields(field.Type, fieldIndex)
+			}
+			continue
+		}
+
+		if field.PkgPath != "" || !reflect.PtrTo(field.Type).Implements(attrFieldBinderType) {
+			gwlog.Panicf("Entity type %s: field %s with goworld tag must be an exported attribute field (IntField, StrField, etc.), but is %s", desc.entityType.Name(), field.Name, field.Type)
+		}
+
+		parts := strings.Split(tag, ",")
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			key = field.Name
+		}
+		var defs []string
+		for _, def := range parts[1:] {
+			if def = strings.TrimSpace(def); def != "" {
+				defs = append(defs, def)
+			}
+		}
+
+		desc.DefineAttr(key, defs...)
+		if desc.attrTypes[key] == nil {
+			desc.attrTypes[key] = reflect.New(field.Type).Interface().(attrFieldBinder).attrType()
+		}
+		desc.attrFields = append(desc.attrFields, attrFieldDesc{index: fieldIndex, key: key})
+	}
+}
+
+// bindAttrFields binds attribute fields of entity to Attrs
+func (e *Entity) bindAttrFields() {
+	if len(e.typeDesc.attrFields) == 0 {
+		return
+	}
+
+	entityVal := reflect.Indirect(e.V)
+	for _, f := range e.typeDesc.attrFields {
+		entityVal.FieldByIndex(f.index).Addr().Interface().(attrFieldBinder).bind(e.Attrs, f.key)
+	}
+}
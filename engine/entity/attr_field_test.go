@@ -0,0 +1,64 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type FieldTestAttrs struct {
+	Name StrField  `goworld:"name,persistent,allclients"`
+	Bag  ListField `goworld:"bag,persistent"`
+}
+
+type FieldTestEntity struct {
+	Entity
+	FieldTestAttrs
+	Level IntField   `goworld:"level,persistent,client"`
+	Exp   FloatField `goworld:"exp"`
+	Dead  BoolField  `goworld:"dead, client"`
+	Stats MapField   `goworld:",persistent"`
+}
+
+func (e *FieldTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+}
+
+func TestAttrFields(t *testing.T) {
+	desc := RegisterEntity("FieldTestEntity", &FieldTestEntity{}, false)
+	if !desc.persistentAttrs.Contains("level") || !desc.clientAttrs.Contains("level") || !desc.allClientAttrs.Contains("name") ||
+		!desc.clientAttrs.Contains("dead") || desc.persistentAttrs.Contains("exp") || !desc.persistentAttrs.Contains("Stats") {
+		t.Fatalf("attributes are not defined by fields: persistent=%v, client=%v, allclients=%v", desc.persistentAttrs, desc.clientAttrs, desc.allClientAttrs)
+	}
+
+	e := CreateEntityLocally("FieldTestEntity", map[string]interface{}{"level": 3, "name": "hero"}).I.(*FieldTestEntity)
+	if e.Level.Get() != 3 || e.Name.Get() != "hero" || e.Dead.Has() {
+		t.Errorf("wrong field values: %v", e.Attrs)
+	}
+
+	e.dirtyAttrs.clear()
+	e.Level.Set(4)
+	e.Exp.Set(1.5)
+	e.Dead.Set(true)
+	e.Bag.Get().AppendStr("sword")
+	e.Stats.Get().SetInt("atk", 10)
+	if e.Attrs.GetInt("level") != 4 || e.Attrs.GetFloat("exp") != 1.5 || !e.Attrs.GetBool("dead") ||
+		e.Attrs.GetListAttr("bag").GetStr(0) != "sword" || e.Attrs.GetMapAttr("Stats").GetInt("atk") != 10 {
+		t.Errorf("fields are not written to attrs: %v", e.Attrs)
+	}
+	if _, updates := e.collectSaveData(); len(updates) != 3 {
+		t.Errorf("persistent fields should be saved incrementally: %v", updates)
+	}
+
+	if msg := expectPanic(t, func() { e.Attrs.SetStr("level", "5") }); msg == "" {
+		t.Errorf("type of field should be checked")
+	}
+
+	// fields are bound when entity is restored
+	eid := common.GenEntityID()
+	restoreEntity(eid, e.GetMigrateData("", Vector3{}), true)
+	restored := GetEntity(eid).I.(*FieldTestEntity)
+	if restored.Level.Get() != 4 || restored.Name.Get() != "hero" {
+		t.Errorf("wrong field values after restore: %v", restored.Attrs)
+	}
+}
@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// AttrChangedHook is called after the attribute at path (like "equipment.slots[0]") is changed from old to new
+//
+// old is nil if the attribute is added, and new is nil if the attribute is deleted.
+// When a MapAttr is cleared, path is the path of the MapAttr, old is nil and new is the cleared MapAttr.
+type AttrChangedHook func(e *Entity, path string, old, new interface{})
+
+type attrHook struct {
+	path string
+	hook AttrChangedHook
+}
+
+// OnAttrChanged registers the hook which is called when the attribute at path or any attribute inside it is changed
+//
+// Hooks on path "" are called when any attribute is changed.
+// Hooks are not called when attributes are loaded, restored or migrated, but only after OnAttrsReady.
+func (desc *EntityTypeDesc) OnAttrChanged(path string, hook AttrChangedHook) *EntityTypeDesc {
+	desc.attrHooks = append(desc.attrHooks, attrHook{path: path, hook: hook})
+	return desc
+}
+
+// matchAttrPath returns if path is hookPath or inside hookPath
+func matchAttrPath(hookPath string, path string) bool {
+	if hookPath == "" || hookPath == path {
+		return true
+	}
+	return strings.HasPrefix(path, hookPath) && (path[len(hookPath)] == '.' || path[len(hookPath)] == '[')
+}
+
+// onAttrChanged calls hooks of attribute changes, key is nil if the attribute itself is changed
+func (e *Entity) onAttrChanged(pathFromLeaf []interface{}, key interface{}, old, new interface{}) {
+	if len(e.typeDesc.attrHooks) == 0 || !e.attrsReady {
+		return
+	}
+
+	if key != nil {
+		pathFromLeaf = append([]interface{}{key}, pathFromLeaf...)
+	}
+	path := formatAttrPath(pathFromLeaf)
+	for _, h := range e.typeDesc.attrHooks {
+		if matchAttrPath(h.path, path) {
+			hook := h.hook
+			gwutils.RunPanicless(func() {
+				hook(e, path, old, new)
+			})
+		}
+	}
+}
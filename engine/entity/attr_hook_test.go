@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"testing"
+)
+
+type attrChange struct {
+	path     string
+	old, new interface{}
+}
+
+var (
+	hookTestChanges      []attrChange
+	hookTestEquipChanges []string
+)
+
+type HookTestEntity struct {
+	Entity
+}
+
+func (e *HookTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("level", "Client")
+	desc.DefineAttr("equip", "Client")
+	desc.DefineAttr("equipment", "Client")
+	desc.OnAttrChanged("", func(e *Entity, path string, old, new interface{}) {
+		hookTestChanges = append(hookTestChanges, attrChange{path, old, new})
+	})
+	desc.OnAttrChanged("equip", func(e *Entity, path string, old, new interface{}) {
+		hookTestEquipChanges = append(hookTestEquipChanges, path)
+	})
+}
+
+func TestAttrHooks(t *testing.T) {
+	RegisterEntity("HookTestEntity", &HookTestEntity{}, false)
+	e := CreateEntityLocally("HookTestEntity", map[string]interface{}{"level": 1})
+	if len(hookTestChanges) != 0 {
+		t.Fatalf("hooks should not be called before attributes are ready: %v", hookTestChanges)
+	}
+
+	expect := func(changes ...attrChange) {
+		t.Helper()
+		if len(hookTestChanges) != len(changes) {
+			t.Fatalf("expect changes %v, but got %v", changes, hookTestChanges)
+		}
+		for i, c := range changes {
+			if hookTestChanges[i] != c {
+				t.Errorf("expect change %v, but got %v", c, hookTestChanges[i])
+			}
+		}
+		hookTestChanges = nil
+	}
+
+	e.Attrs.SetInt("level", 2)
+	expect(attrChange{"level", int64(1), int64(2)})
+
+	equip := e.Attrs.GetMapAttr("equip")
+	hookTestChanges = nil
+	equip.SetStr("weapon", "sword")
+	equip.SetStr("weapon", "axe")
+	equip.Del("weapon")
+	expect(attrChange{"equip.weapon", nil, "sword"}, attrChange{"equip.weapon", "sword", "axe"}, attrChange{"equip.weapon", "axe", nil})
+
+	equip.SetStr("armor", "plate")
+	hookTestChanges = nil
+	equip.Clear()
+	if len(hookTestChanges) != 1 || hookTestChanges[0].path != "equip" || hookTestChanges[0].new != equip {
+		t.Errorf("wrong change of clear: %v", hookTestChanges)
+	}
+	hookTestChanges = nil
+
+	slots := e.Attrs.GetListAttr("equipment")
+	hookTestChanges = nil
+	slots.AppendInt(1)
+	slots.SetInt(0, 2)
+	slots.PopInt()
+	expect(attrChange{"equipment[0]", nil, int64(1)}, attrChange{"equipment[0]", int64(1), int64(2)}, attrChange{"equipment[0]", int64(2), nil})
+
+	// hooks on path are called for changes inside the path only, but not for "equipment"
+	if len(hookTestEquipChanges) != 6 {
+		t.Errorf("wrong changes of equip: %v", hookTestEquipChanges)
+	}
+}
@@ -0,0 +1,181 @@
+package entity
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// AttrLimits limits the size of attributes of entity type, zero means no limit
+//
+// Depth, list length and map size are checked when attributes are changed after OnAttrsReady. Encoded size is measured
+// when entity is saved, and changes that add items to oversized entity are treated as violations until it shrinks.
+// Violations are logged as warnings, or panic if Strict is true.
+type AttrLimits struct {
+	MaxDepth       int  // max depth of attributes, attributes in Attrs have depth 1
+	MaxListLen     int  // max length of each ListAttr
+	MaxMapSize     int  // max number of keys of each MapAttr, including Attrs
+	MaxEncodedSize int  // max encoded size of persistent attributes in bytes
+	Strict         bool // panic on violations instead of logging warnings
+}
+
+// SetAttrLimits sets limits of attributes
+func (desc *EntityTypeDesc) SetAttrLimits(limits AttrLimits) *EntityTypeDesc {
+	desc.attrLimits = &limits
+	return desc
+}
+
+// attrDepth returns the depth of attribute value, which is 0 for non-container values
+func attrDepth(val interface{}) int {
+	depth := 0
+	switch a := val.(type) {
+	case *MapAttr:
+		for _, v := range a.attrs {
+			if d := attrDepth(v); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	case *ListAttr:
+		for _, v := range a.items {
+			if d := attrDepth(v); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	default:
+		return 0
+	}
+}
+
+// checkMapAttrLimits checks limits before val is set at key of the MapAttr
+func (e *Entity) checkMapAttrLimits(a *MapAttr, key string, val interface{}) {
+	limits := e.typeDesc.attrLimits
+	if limits == nil || !e.attrsReady {
+		return
+	}
+
+	_, exists := a.attrs[key]
+	var err error
+	if !exists && limits.MaxMapSize > 0 && len(a.attrs) >= limits.MaxMapSize {
+		err = errors.Errorf("attribute %s: size %d exceeds limit %d", formatLimitPath(a.getPathFromOwner()), len(a.attrs)+1, limits.MaxMapSize)
+	} else {
+		err = e.checkItemLimits(limits, a.getPathFromOwner(), key, val, !exists)
+	}
+	e.reportAttrLimitViolation(limits, err)
+}
+
+// checkListAttrLimits checks limits before val is put at index of the ListAttr, grows is true if val is added to the list
+func (e *Entity) checkListAttrLimits(a *ListAttr, index int, val interface{}, grows bool) {
+	limits := e.typeDesc.attrLimits
+	if limits == nil || !e.attrsReady {
+		return
+	}
+
+	var err error
+	if grows && limits.MaxListLen > 0 && len(a.items) >= limits.MaxListLen {
+		err = errors.Errorf("attribute %s: length %d exceeds limit %d", formatLimitPath(a.getPathFromOwner()), len(a.items)+1, limits.MaxListLen)
+	} else {
+		err = e.checkItemLimits(limits, a.getPathFromOwner(), index, val, grows)
+	}
+	e.reportAttrLimitViolation(limits, err)
+}
+
+func (e *Entity) checkItemLimits(limits *AttrLimits, pathFromLeaf []interface{}, key interface{}, val interface{}, grows bool) error {
+	if limits.MaxDepth > 0 {
+		if depth := len(pathFromLeaf) + 1 + attrDepth(val); depth > limits.MaxDepth {
+			return errors.Errorf("attribute %s: depth %d exceeds limit %d", formatAttrPath(append([]interface{}{key}, pathFromLeaf...)), depth, limits.MaxDepth)
+		}
+	}
+	if grows && limits.MaxEncodedSize > 0 && e.encodedSize > limits.MaxEncodedSize {
+		return errors.Errorf("attribute %s: encoded size %d exceeds limit %d", formatAttrPath(append([]interface{}{key}, pathFromLeaf...)), e.encodedSize, limits.MaxEncodedSize)
+	}
+	return nil
+}
+
+func (e *Entity) reportAttrLimitViolation(limits *AttrLimits, err error) {
+	if err == nil {
+		return
+	}
+
+	if limits.Strict {
+		gwlog.Panicf("%s: %s", e, err)
+	} else {
+		gwlog.Warnf("%s: %s", e, err)
+	}
+}
+
+func formatLimitPath(pathFromLeaf []interface{}) string {
+	if len(pathFromLeaf) == 0 {
+		return "Attrs"
+	}
+	return formatAttrPath(pathFromLeaf)
+}
+
+// EntitySize is the encoded size of persistent attributes of entity
+type EntitySize struct {
+	TypeName string
+	ID       common.EntityID
+	Size     int
+}
+
+// entitySizes records encoded sizes of entities, it is read by the metric in other goroutines
+var entitySizes = struct {
+	sync.Mutex
+	sizes map[common.EntityID]EntitySize
+}{sizes: map[common.EntityID]EntitySize{}}
+
+func init() {
+	expvar.Publish("largest_entities", expvar.Func(func() interface{} {
+		return LargestEntities(consts.LARGEST_ENTITIES_METRIC_COUNT)
+	}))
+}
+
+// LargestEntities returns at most n entities with largest encoded sizes, which are measured when entities are fully saved
+func LargestEntities(n int) []EntitySize {
+	entitySizes.Lock()
+	sizes := make([]EntitySize, 0, len(entitySizes.sizes))
+	for _, s := range entitySizes.sizes {
+		sizes = append(sizes, s)
+	}
+	entitySizes.Unlock()
+
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i].Size > sizes[j].Size
+	})
+	if len(sizes) > n {
+		sizes = sizes[:n]
+	}
+	return sizes
+}
+
+// measureEncodedSize measures the encoded size of persistent data of entity
+func (e *Entity) measureEncodedSize(data map[string]interface{}) {
+	b, err := netutil.MSG_PACKER.PackMsg(data, nil)
+	if err != nil {
+		gwlog.Errorf("%s: measure encoded size failed: %s", e, err)
+		return
+	}
+
+	e.encodedSize = len(b)
+	entitySizes.Lock()
+	entitySizes.sizes[e.ID] = EntitySize{TypeName: e.TypeName, ID: e.ID, Size: e.encodedSize}
+	entitySizes.Unlock()
+
+	if limits := e.typeDesc.attrLimits; limits != nil && limits.MaxEncodedSize > 0 && e.encodedSize > limits.MaxEncodedSize {
+		gwlog.Warnf("%s: encoded size %d exceeds limit %d", e, e.encodedSize, limits.MaxEncodedSize)
+	}
+}
+
+// forgetEncodedSize removes the encoded size of entity from metric
+func (e *Entity) forgetEncodedSize() {
+	entitySizes.Lock()
+	delete(entitySizes.sizes, e.ID)
+	entitySizes.Unlock()
+}
@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+)
+
+type LimitTestEntity struct {
+	Entity
+}
+
+func (e *LimitTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("bag", "Persistent")
+	desc.DefineAttr("profile", "Persistent")
+	desc.SetAttrLimits(AttrLimits{MaxDepth: 2, MaxListLen: 3, MaxMapSize: 4, MaxEncodedSize: 100, Strict: true})
+}
+
+type LooseLimitTestEntity struct {
+	Entity
+}
+
+func (e *LooseLimitTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("bag")
+	desc.SetAttrLimits(AttrLimits{MaxListLen: 1})
+}
+
+func TestAttrLimits(t *testing.T) {
+	RegisterEntity("LimitTestEntity", &LimitTestEntity{}, false)
+	RegisterEntity("LooseLimitTestEntity", &LooseLimitTestEntity{}, false)
+
+	// limits are not checked when entity is created with data
+	e := CreateEntityLocally("LimitTestEntity", map[string]interface{}{"bag": []interface{}{1, 2, 3, 4}})
+	if e.Attrs.GetListAttr("bag").Size() != 4 {
+		t.Fatalf("loaded attributes should not be limited: %v", e.Attrs)
+	}
+
+	bag := e.Attrs.GetListAttr("bag")
+	if msg := expectPanic(t, func() { bag.AppendInt(5) }); !strings.Contains(msg, "attribute bag: length 5 exceeds limit 3") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	bag.Truncate(2)
+	bag.AppendInt(3)
+	bag.SetInt(0, 0) // setting items does not grow the list
+
+	profile := e.Attrs.GetMapAttr("profile")
+	if msg := expectPanic(t, func() { profile.SetMapAttr("stats", NewMapAttr()) }); !strings.Contains(msg, "attribute profile.stats: depth 3 exceeds limit 2") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	profile.SetStr("a", "a")
+	e.Attrs.SetInt("x", 1)
+	e.Attrs.SetInt("y", 1)
+	if msg := expectPanic(t, func() { e.Attrs.SetInt("z", 1) }); !strings.Contains(msg, "attribute Attrs: size 5 exceeds limit 4") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	e.Attrs.SetInt("x", 2)
+
+	// encoded size is measured when saved
+	profile.SetStr("a", strings.Repeat("a", 100))
+	e.measureEncodedSize(e.getPersistentData())
+	if msg := expectPanic(t, func() { profile.SetStr("b", "b") }); !strings.Contains(msg, "exceeds limit 100") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	profile.SetStr("a", "a")
+	e.measureEncodedSize(e.getPersistentData())
+	profile.SetStr("b", "b")
+
+	profile.SetStr("a", strings.Repeat("a", 10000))
+	e.measureEncodedSize(e.getPersistentData())
+	if largest := LargestEntities(1); len(largest) != 1 || largest[0].ID != e.ID || largest[0].Size != e.encodedSize {
+		t.Errorf("wrong largest entities: %v", largest)
+	}
+	e.forgetEncodedSize()
+	for _, s := range LargestEntities(100) {
+		if s.ID == e.ID {
+			t.Errorf("entity size should be removed: %v", s)
+		}
+	}
+
+	// violations are only warned if limits are not strict
+	loose := CreateEntityLocally("LooseLimitTestEntity", nil)
+	loose.Attrs.GetListAttr("bag").AppendInt(1)
+	loose.Attrs.GetListAttr("bag").AppendInt(2)
+	if loose.Attrs.GetListAttr("bag").Size() != 2 {
+		t.Errorf("loose limits should not stop changes")
+	}
+}
@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/typeconv"
+)
+
+// AttrMigration migrates attributes from one schema version to the next version
+type AttrMigration func(attrs *MapAttr)
+
+// SetSchemaVersion sets the schema version of persistent attributes
+//
+// Schema version is saved with persistent attributes. When entity is loaded or restored with data of older version,
+// migrations are applied to the data version by version until it reaches the current schema version.
+func (desc *EntityTypeDesc) SetSchemaVersion(version int) *EntityTypeDesc {
+	if version < 0 {
+		gwlog.Panicf("Entity type %s: schema version < 0", desc.entityType.Name())
+	}
+
+	desc.schemaVersion = version
+	return desc
+}
+
+// AddMigration adds the migration from fromVersion to fromVersion + 1
+func (desc *EntityTypeDesc) AddMigration(fromVersion int, migration AttrMigration) *EntityTypeDesc {
+	if fromVersion < 0 {
+		gwlog.Panicf("Entity type %s: migration from version %d < 0", desc.entityType.Name(), fromVersion)
+	}
+	if _, ok := desc.migrations[fromVersion]; ok {
+		gwlog.Panicf("Entity type %s: migration from version %d is already added", desc.entityType.Name(), fromVersion)
+	}
+
+	desc.migrations[fromVersion] = migration
+	return desc
+}
+
+// checkMigrations checks that all migrations can be applied
+func (desc *EntityTypeDesc) checkMigrations() {
+	for fromVersion := range desc.migrations {
+		if fromVersion >= desc.schemaVersion {
+			gwlog.Panicf("Entity type %s: migration from version %d, but schema version is %d", desc.entityType.Name(), fromVersion, desc.schemaVersion)
+		}
+	}
+}
+
+// migrate applies migrations to attributes of the specified version, returns true if attributes are migrated
+func (desc *EntityTypeDesc) migrate(attrs *MapAttr, version int) bool {
+	if version >= desc.schemaVersion {
+		if version > desc.schemaVersion {
+			gwlog.Warnf("Entity type %s: data version %d is newer than schema version %d", desc.entityType.Name(), version, desc.schemaVersion)
+		}
+		return false
+	}
+
+	for v := version; v < desc.schemaVersion; v++ {
+		if migration := desc.migrations[v]; migration != nil {
+			migration(attrs)
+		}
+	}
+	return true
+}
+
+// migrateData applies migrations to data loaded from storage, returns the migrated data and true if data is migrated
+func (desc *EntityTypeDesc) migrateData(data map[string]interface{}) (map[string]interface{}, bool) {
+	version := popSchemaVersion(data)
+	if version >= desc.schemaVersion {
+		return data, desc.migrate(nil, version)
+	}
+
+	attrs := NewMapAttr()
+	attrs.AssignMap(data)
+	desc.migrate(attrs, version)
+	return attrs.ToMap(), true
+}
+
+// popSchemaVersion removes schema version from data, returns 0 if data has no schema version
+func popSchemaVersion(data map[string]interface{}) int {
+	version, ok := data[storagecommon.SchemaVersionKey]
+	if !ok {
+		return 0
+	}
+	delete(data, storagecommon.SchemaVersionKey)
+	return int(typeconv.Int(version))
+}
@@ -0,0 +1,92 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type SchemaTestEntity struct {
+	Entity
+}
+
+func (e *SchemaTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("health", "Persistent")
+	desc.SetSchemaVersion(2)
+	desc.AddMigration(0, func(attrs *MapAttr) {
+		// rename hp to health
+		if attrs.HasKey("hp") {
+			attrs.SetInt("health", attrs.PopInt("hp"))
+		}
+	})
+	desc.AddMigration(1, func(attrs *MapAttr) {
+		attrs.SetInt("health", attrs.GetInt("health")*10)
+	})
+}
+
+func TestSchemaMigration(t *testing.T) {
+	desc := RegisterEntity("SchemaTestEntity", &SchemaTestEntity{}, false)
+
+	data, migrated := desc.migrateData(map[string]interface{}{"hp": 5})
+	if !migrated || data["health"] != int64(50) || len(data) != 1 {
+		t.Errorf("data of version 0 is not migrated correctly: migrated=%v, data=%v", migrated, data)
+	}
+
+	data, migrated = desc.migrateData(map[string]interface{}{storagecommon.SchemaVersionKey: 1, "health": 5})
+	if !migrated || data["health"] != int64(50) || len(data) != 1 {
+		t.Errorf("data of version 1 is not migrated correctly: migrated=%v, data=%v", migrated, data)
+	}
+
+	data, migrated = desc.migrateData(map[string]interface{}{storagecommon.SchemaVersionKey: 2, "health": 5})
+	if migrated || data["health"] != 5 || len(data) != 1 {
+		t.Errorf("data of current version should not be migrated: migrated=%v, data=%v", migrated, data)
+	}
+
+	e := CreateEntityLocally("SchemaTestEntity", map[string]interface{}{"health": 3})
+	md := e.GetMigrateData("", Vector3{})
+	if md.SchemaVersion != 2 {
+		t.Errorf("schema version should be in migrate data, but got %d", md.SchemaVersion)
+	}
+
+	// restore entity from freeze data of version 0
+	eid := common.GenEntityID()
+	restoreEntity(eid, &entityMigrateData{Type: "SchemaTestEntity", Attrs: map[string]interface{}{"hp": int64(3)}}, true)
+	if health := GetEntity(eid).Attrs.GetInt("health"); health != 30 {
+		t.Errorf("restored entity is not migrated: health=%d", health)
+	}
+}
+
+type BadDataTestEntity struct {
+	Entity
+}
+
+func (e *BadDataTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineTypedAttr("health", IntType(), "Persistent")
+	desc.SetSchemaVersion(1)
+	desc.AddMigration(0, func(attrs *MapAttr) {
+		attrs.SetInt("health", attrs.PopInt("hp"))
+	})
+}
+
+func TestPrepareLoadedData(t *testing.T) {
+	desc := RegisterEntity("BadDataTestEntity", &BadDataTestEntity{}, false)
+
+	data, migrated, err := desc.prepareLoadedData(map[string]interface{}{"hp": int64(5), "temp": 1})
+	if err != nil || !migrated || data["health"] != int64(5) || len(data) != 1 {
+		t.Errorf("valid data is not loaded correctly: migrated=%v, data=%v, err=%v", migrated, data, err)
+	}
+
+	// loading invalid data should fail without panicking
+	if _, _, err := desc.prepareLoadedData(map[string]interface{}{"hp": "5"}); err == nil {
+		t.Errorf("failed migration should return error")
+	}
+	if _, _, err := desc.prepareLoadedData(map[string]interface{}{storagecommon.SchemaVersionKey: 1, "health": "5"}); err == nil {
+		t.Errorf("data of wrong attr type should return error")
+	}
+	if _, _, err := desc.prepareLoadedData([]interface{}{}); err == nil {
+		t.Errorf("data of wrong type should return error")
+	}
+}
@@ -0,0 +1,211 @@
+package entity
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Attribute changes are not sent to clients immediately, but collected as deltas of entity and sent to clients in
+// batches (MT_NOTIFY_ATTR_CHANGES_ON_CLIENT) on each game tick, one packet per client.
+//
+// Deltas setting or deleting the same attribute are coalesced (last write wins), and other deltas are kept in order.
+// Pending deltas of entity are flushed before any other message of the entity is sent to clients (entity creation,
+// client calls, etc.), so that clients always see attribute changes in the same order as messages.
+
+// attrDelta is an attribute change to be sent to clients
+type attrDelta struct {
+	op         proto.AttrChangeOp
+	allClients bool          // if the change is sent to all clients or the own client only
+	path       []interface{} // path from leaf, as sent to clients
+	key        interface{}   // key of MapAttr or index of ListAttr
+	val        interface{}
+}
+
+func (d *attrDelta) toList() []interface{} {
+	switch d.op {
+	case proto.ATTR_CHANGE_MAP_SET, proto.ATTR_CHANGE_LIST_SET, proto.ATTR_CHANGE_LIST_INSERT, proto.ATTR_CHANGE_LIST_SWAP:
+		return []interface{}{d.op, d.path, d.key, d.val}
+	case proto.ATTR_CHANGE_MAP_DEL, proto.ATTR_CHANGE_LIST_REMOVE, proto.ATTR_CHANGE_LIST_TRUNCATE:
+		return []interface{}{d.op, d.path, d.key}
+	case proto.ATTR_CHANGE_LIST_APPEND:
+		return []interface{}{d.op, d.path, d.val}
+	default:
+		return []interface{}{d.op, d.path}
+	}
+}
+
+// attrDeltaSlot returns the slot of attribute at key of path, deltas of the same slot are coalesced
+func attrDeltaSlot(path []interface{}, key interface{}) string {
+	var sb strings.Builder
+	for i := len(path) - 1; i >= -1; i-- {
+		k := key
+		if i >= 0 {
+			k = path[i]
+		}
+		switch k := k.(type) {
+		case int:
+			sb.WriteByte('#')
+			sb.WriteString(strconv.Itoa(k))
+		case string:
+			sb.WriteByte('.')
+			sb.WriteString(k)
+		}
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// entities with pending attribute deltas
+var attrSyncEntities = EntitySet{}
+
+// addAttrDelta adds the attribute change to be sent to clients according to flag
+func (e *Entity) addAttrDelta(flag attrFlag, d attrDelta) {
+	if e.destroyed {
+		return
+	}
+
+	if flag&afAllClient != 0 {
+		if e.client == nil && len(e.InterestedBy) == 0 {
+			return
+		}
+		d.allClients = true
+	} else if flag&afClient != 0 {
+		if e.client == nil {
+			return
+		}
+	} else {
+		return
+	}
+
+	switch d.op {
+	case proto.ATTR_CHANGE_MAP_SET, proto.ATTR_CHANGE_MAP_DEL, proto.ATTR_CHANGE_LIST_SET:
+		slot := attrDeltaSlot(d.path, d.key)
+		if i, ok := e.attrDeltaSlots[slot]; ok {
+			e.attrDeltas[i] = nil // overwritten by the new delta
+		}
+
+		switch d.val.(type) {
+		case map[string]interface{}, []interface{}:
+			// later deltas might change attributes inside the new MapAttr or ListAttr, so this delta can not be removed
+			delete(e.attrDeltaSlots, slot)
+		default:
+			if e.attrDeltaSlots == nil {
+				e.attrDeltaSlots = map[string]int{}
+			}
+			e.attrDeltaSlots[slot] = len(e.attrDeltas)
+		}
+	case proto.ATTR_CHANGE_LIST_INSERT, proto.ATTR_CHANGE_LIST_REMOVE, proto.ATTR_CHANGE_LIST_SWAP:
+		// items are moved to other indexes, so deltas before can not be coalesced with deltas after
+		e.attrDeltaSlots = nil
+	}
+
+	if len(e.attrDeltas) == 0 {
+		attrSyncEntities.Add(e)
+	}
+	e.attrDeltas = append(e.attrDeltas, &d)
+}
+
+// attrChangesPacket is the packet of attribute changes to be sent to client
+type attrChangesPacket struct {
+	client *GameClient
+	packet *netutil.Packet
+}
+
+// packAttrDeltas packs pending attribute deltas to packets of clients
+func (e *Entity) packAttrDeltas(packets map[common.ClientID]*attrChangesPacket) {
+	deltas := e.attrDeltas
+	e.attrDeltas, e.attrDeltaSlots = nil, nil
+
+	var ownChanges, allChanges []interface{}
+	for _, d := range deltas {
+		if d == nil {
+			continue
+		}
+
+		change := d.toList()
+		ownChanges = append(ownChanges, change)
+		if d.allClients {
+			allChanges = append(allChanges, change)
+		}
+	}
+
+	var allData []byte
+	if len(allChanges) > 0 && len(e.InterestedBy) > 0 {
+		allData = packAttrChanges(allChanges)
+		for neighbor := range e.InterestedBy {
+			appendAttrChanges(packets, neighbor.client, e.ID, allData)
+		}
+	}
+
+	if len(ownChanges) > 0 && e.client != nil {
+		ownData := allData
+		if ownData == nil || len(ownChanges) != len(allChanges) {
+			ownData = packAttrChanges(ownChanges)
+		}
+		appendAttrChanges(packets, e.client, e.ID, ownData)
+	}
+}
+
+func packAttrChanges(changes []interface{}) []byte {
+	data, err := netutil.MSG_PACKER.PackMsg(changes, nil)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	return data
+}
+
+func appendAttrChanges(packets map[common.ClientID]*attrChangesPacket, client *GameClient, entityID common.EntityID, data []byte) {
+	if client == nil {
+		return
+	}
+
+	p := packets[client.clientid]
+	if p == nil {
+		packet := netutil.NewPacket()
+		packet.AppendUint16(proto.MT_NOTIFY_ATTR_CHANGES_ON_CLIENT)
+		packet.AppendUint16(client.gateid)
+		packet.AppendClientID(client.clientid)
+		p = &attrChangesPacket{client: client, packet: packet}
+		packets[client.clientid] = p
+	}
+	p.packet.AppendEntityID(entityID)
+	p.packet.AppendVarBytes(data)
+}
+
+func sendAttrChangesPackets(packets map[common.ClientID]*attrChangesPacket) {
+	for _, p := range packets {
+		p.client.selectDispatcher().SendPacket(p.packet)
+		p.packet.Release()
+	}
+}
+
+// flushAttrDeltas sends pending attribute deltas of entity to clients
+func (e *Entity) flushAttrDeltas() {
+	if len(e.attrDeltas) == 0 {
+		return
+	}
+
+	attrSyncEntities.Del(e)
+	packets := map[common.ClientID]*attrChangesPacket{}
+	e.packAttrDeltas(packets)
+	sendAttrChangesPackets(packets)
+}
+
+// FlushAttrChanges is called by game service on each tick to send pending attribute changes of all entities to clients
+func FlushAttrChanges() {
+	if len(attrSyncEntities) == 0 {
+		return
+	}
+
+	packets := map[common.ClientID]*attrChangesPacket{}
+	for e := range attrSyncEntities {
+		e.packAttrDeltas(packets)
+	}
+	attrSyncEntities = EntitySet{}
+	sendAttrChangesPackets(packets)
+}
@@ -0,0 +1,156 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/typeconv"
+)
+
+type SyncTestEntity struct {
+	Entity
+}
+
+func (e *SyncTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("hp", "AllClients")
+	desc.DefineAttr("equip", "AllClients")
+	desc.DefineAttr("bag", "Client")
+	desc.DefineAttr("secret")
+}
+
+// readAttrChanges reads attribute changes of entities from MT_NOTIFY_ATTR_CHANGES_ON_CLIENT packet
+func readAttrChanges(t *testing.T, packet *netutil.Packet) map[common.EntityID][][]interface{} {
+	if msgtype := packet.ReadUint16(); msgtype != proto.MT_NOTIFY_ATTR_CHANGES_ON_CLIENT {
+		t.Fatalf("wrong msgtype: %d", msgtype)
+	}
+	packet.ReadUint16()
+	packet.ReadClientID()
+
+	changes := map[common.EntityID][][]interface{}{}
+	for packet.HasUnreadPayload() {
+		eid := packet.ReadEntityID()
+		var entityChanges [][]interface{}
+		packet.ReadData(&entityChanges)
+		for _, c := range entityChanges {
+			c[0] = proto.AttrChangeOp(typeconv.Int(c[0]))
+			switch c[0] {
+			case proto.ATTR_CHANGE_LIST_SET, proto.ATTR_CHANGE_LIST_INSERT, proto.ATTR_CHANGE_LIST_REMOVE, proto.ATTR_CHANGE_LIST_TRUNCATE:
+				c[2] = int(typeconv.Int(c[2]))
+			case proto.ATTR_CHANGE_LIST_SWAP:
+				c[2], c[3] = int(typeconv.Int(c[2])), int(typeconv.Int(c[3]))
+			}
+		}
+		changes[eid] = entityChanges
+	}
+	return changes
+}
+
+func TestAttrSync(t *testing.T) {
+	RegisterEntity("SyncTestEntity", &SyncTestEntity{}, false)
+	e := CreateEntityLocally("SyncTestEntity", nil)
+	neighbor := CreateEntityLocally("SyncTestEntity", nil)
+	e.assignClient(MakeGameClient(common.GenClientID(), 1, ""))
+	neighbor.assignClient(MakeGameClient(common.GenClientID(), 1, ""))
+	e.InterestedBy.Add(neighbor)
+	defer func() {
+		e.InterestedBy.Del(neighbor)
+		e.assignClient(nil)
+		neighbor.assignClient(nil)
+		attrSyncEntities.Del(e)
+	}()
+
+	for i := 0; i < 30; i++ {
+		e.Attrs.SetInt("hp", int64(i))
+	}
+	e.Attrs.SetInt("secret", 1)
+	equip := e.Attrs.GetMapAttr("equip")
+	equip.SetStr("weapon", "sword")
+	equip.Clear()
+	equip.SetStr("weapon", "axe")
+	bag := e.Attrs.GetListAttr("bag")
+	bag.AppendStr("apple")
+	bag.SetStr(0, "pear")
+	bag.SetStr(0, "peach")
+	e.Attrs.Del("hp")
+
+	if !attrSyncEntities.Contains(e) {
+		t.Fatalf("entity should have pending attribute changes")
+	}
+
+	packets := map[common.ClientID]*attrChangesPacket{}
+	e.packAttrDeltas(packets)
+	if len(packets) != 2 || len(e.attrDeltas) != 0 {
+		t.Fatalf("should pack changes to 2 clients, but got %d", len(packets))
+	}
+
+	allChanges := [][]interface{}{
+		{proto.ATTR_CHANGE_MAP_SET, nil, "equip", map[string]interface{}{}},
+		{proto.ATTR_CHANGE_MAP_CLEAR, []interface{}{"equip"}},
+		{proto.ATTR_CHANGE_MAP_SET, []interface{}{"equip"}, "weapon", "axe"},
+		{proto.ATTR_CHANGE_MAP_DEL, nil, "hp"},
+	}
+	ownChanges := [][]interface{}{
+		allChanges[0], allChanges[1], allChanges[2],
+		{proto.ATTR_CHANGE_MAP_SET, nil, "bag", []interface{}{}},
+		{proto.ATTR_CHANGE_LIST_APPEND, []interface{}{"bag"}, "apple"},
+		{proto.ATTR_CHANGE_LIST_SET, []interface{}{"bag"}, 0, "peach"},
+		allChanges[3],
+	}
+
+	for clientid, p := range packets {
+		changes := readAttrChanges(t, p.packet)
+		expect := allChanges
+		if clientid == e.client.clientid {
+			expect = ownChanges
+		}
+		if !reflect.DeepEqual(changes[e.ID], expect) {
+			t.Errorf("client %s: expect changes %v, but got %v", clientid, expect, changes[e.ID])
+		}
+		p.packet.Release()
+	}
+}
+
+func TestAttrSyncListMoves(t *testing.T) {
+	e := CreateEntityLocally("SyncTestEntity", nil)
+	e.assignClient(MakeGameClient(common.GenClientID(), 1, ""))
+	defer func() {
+		e.assignClient(nil)
+		attrSyncEntities.Del(e)
+	}()
+
+	bag := e.Attrs.GetListAttr("bag")
+	bag.AppendStr("a")
+	bag.AppendStr("b")
+	bag.SetStr(1, "c")
+	bag.InsertStr(0, "d") // "c" is moved to index 2, so setting index 1 before should not be coalesced
+	bag.SetStr(1, "e")
+	bag.SetStr(1, "f")
+	bag.Swap(0, 2)
+	bag.RemoveAt(1)
+	bag.Truncate(1)
+
+	packets := map[common.ClientID]*attrChangesPacket{}
+	e.packAttrDeltas(packets)
+	p := packets[e.client.clientid]
+	changes := readAttrChanges(t, p.packet)[e.ID]
+	p.packet.Release()
+
+	path := []interface{}{"bag"}
+	expect := [][]interface{}{
+		{proto.ATTR_CHANGE_MAP_SET, nil, "bag", []interface{}{}},
+		{proto.ATTR_CHANGE_LIST_APPEND, path, "a"},
+		{proto.ATTR_CHANGE_LIST_APPEND, path, "b"},
+		{proto.ATTR_CHANGE_LIST_SET, path, 1, "c"},
+		{proto.ATTR_CHANGE_LIST_INSERT, path, 0, "d"},
+		{proto.ATTR_CHANGE_LIST_SET, path, 1, "f"},
+		{proto.ATTR_CHANGE_LIST_SWAP, path, 0, 2},
+		{proto.ATTR_CHANGE_LIST_REMOVE, path, 1},
+		{proto.ATTR_CHANGE_LIST_TRUNCATE, path, 1},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Errorf("expect changes %v, but got %v", expect, changes)
+	}
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"math"
+	"reflect"
 	"testing"
 
 	"strconv"
@@ -103,3 +104,70 @@ func BenchmarkConvertBsonMToMap(b *testing.B) {
 		mm["b"] = 1
 	}
 }
+
+type ListTestEntity struct {
+	Entity
+}
+
+func (e *ListTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("bag", "Persistent", "Client")
+}
+
+func TestListAttrMoves(t *testing.T) {
+	RegisterEntity("ListTestEntity", &ListTestEntity{}, false)
+	e := CreateEntityLocally("ListTestEntity", nil)
+	bag := e.Attrs.GetListAttr("bag")
+	for i := 0; i < 4; i++ {
+		item := NewMapAttr()
+		item.SetInt("id", int64(i))
+		bag.AppendMapAttr(item)
+	}
+
+	checkBag := func(ids ...int64) {
+		t.Helper()
+		if bag.Size() != len(ids) {
+			t.Fatalf("expect %v, but got %s", ids, bag)
+		}
+		for i, id := range ids {
+			item := bag.GetMapAttr(i)
+			if item.GetInt("id") != id {
+				t.Fatalf("expect %v, but got %s", ids, bag)
+			}
+			if path := item.getPathFromOwner(); !reflect.DeepEqual(path, []interface{}{i, "bag"}) {
+				t.Errorf("item %d: wrong path %v", i, path)
+			}
+		}
+	}
+
+	e.dirtyAttrs.clear()
+	item := NewMapAttr()
+	item.SetInt("id", 4)
+	bag.InsertMapAttr(1, item)
+	checkBag(0, 4, 1, 2, 3)
+	if _, updates := e.collectSaveData(); len(updates) != 1 || !reflect.DeepEqual(updates[0].Path, []interface{}{"bag"}) {
+		t.Errorf("bag should be saved as a whole after insert: %v", updates)
+	}
+
+	removed := bag.RemoveAt(0).(*MapAttr)
+	checkBag(4, 1, 2, 3)
+	if removed.GetInt("id") != 0 || removed.owner != nil || removed.parent != nil {
+		t.Errorf("removed item is not detached: %v", removed)
+	}
+
+	bag.Swap(0, 3)
+	checkBag(3, 1, 2, 4)
+	bag.GetMapAttr(3).SetInt("count", 1)
+	if v, ok := resolveAttrPath(e.Attrs, []interface{}{"bag", 3, "count"}); !ok || v != int64(1) {
+		t.Errorf("wrong path after swap: %v", v)
+	}
+
+	bag.Truncate(2)
+	checkBag(3, 1)
+	bag.Truncate(3)
+	checkBag(3, 1)
+	bag.InsertInt(2, 100)
+	if bag.GetInt(2) != 100 {
+		t.Errorf("insert at the end failed: %s", bag)
+	}
+}
@@ -0,0 +1,289 @@
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// AttrKind is the kind of attribute value
+type AttrKind int
+
+// Attribute kinds
+const (
+	AttrInt AttrKind = iota + 1
+	AttrFloat
+	AttrBool
+	AttrStr
+	AttrMap
+	AttrList
+)
+
+var attrKindNames = map[AttrKind]string{
+	AttrInt:   "int",
+	AttrFloat: "float",
+	AttrBool:  "bool",
+	AttrStr:   "str",
+	AttrMap:   "map",
+	AttrList:  "list",
+}
+
+// AttrType declares the type of attribute, which is checked when attribute is set or loaded
+type AttrType struct {
+	Kind    AttrKind
+	Fields  map[string]*AttrType // declared fields of map, other keys are not allowed if Fields is not nil
+	Elem    *AttrType            // type of list items or values of map without declared fields, nil means any type
+	Default interface{}          // default value set when entity is created or loaded without the attribute
+}
+
+// IntType returns the type of int attribute
+func IntType() *AttrType {
+	return &AttrType{Kind: AttrInt}
+}
+
+// FloatType returns the type of float attribute
+func FloatType() *AttrType {
+	return &AttrType{Kind: AttrFloat}
+}
+
+// BoolType returns the type of bool attribute
+func BoolType() *AttrType {
+	return &AttrType{Kind: AttrBool}
+}
+
+// StrType returns the type of string attribute
+func StrType() *AttrType {
+	return &AttrType{Kind: AttrStr}
+}
+
+// MapType returns the type of map attribute with declared fields
+func MapType(fields map[string]*AttrType) *AttrType {
+	return &AttrType{Kind: AttrMap, Fields: fields}
+}
+
+// MapOfType returns the type of map attribute with values of elem type, elem can be nil for any type
+func MapOfType(elem *AttrType) *AttrType {
+	return &AttrType{Kind: AttrMap, Elem: elem}
+}
+
+// ListType returns the type of list attribute with items of elem type, elem can be nil for any type
+func ListType(elem *AttrType) *AttrType {
+	return &AttrType{Kind: AttrList, Elem: elem}
+}
+
+// WithDefault returns the type with default value
+func (t *AttrType) WithDefault(val interface{}) *AttrType {
+	if err := t.check("default", val); err != nil {
+		gwlog.Panicf("invalid default value: %s", err)
+	}
+
+	nt := *t
+	nt.Default = val
+	return &nt
+}
+
+func (t *AttrType) String() string {
+	switch t.Kind {
+	case AttrMap:
+		if t.Fields != nil {
+			keys := make([]string, 0, len(t.Fields))
+			for k := range t.Fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fields := make([]string, len(keys))
+			for i, k := range keys {
+				fields[i] = k + ": " + t.Fields[k].String()
+			}
+			return "map{" + strings.Join(fields, ", ") + "}"
+		} else if t.Elem != nil {
+			return "map<" + t.Elem.String() + ">"
+		}
+	case AttrList:
+		if t.Elem != nil {
+			return "list<" + t.Elem.String() + ">"
+		}
+	}
+	return attrKindNames[t.Kind]
+}
+
+// childType returns the declared type of the item in map or list attribute, or nil if not declared
+func (t *AttrType) childType(key interface{}) *AttrType {
+	if t.Kind == AttrMap && t.Fields != nil {
+		if k, ok := key.(string); ok {
+			return t.Fields[k]
+		}
+		return nil
+	}
+	return t.Elem
+}
+
+// check checks if val matches the type, path is the attribute path used in errors
+func (t *AttrType) check(path string, val interface{}) error {
+	ok := true
+	switch t.Kind {
+	case AttrInt:
+		switch val.(type) {
+		case int64, int, int32, int16, int8, uint64, uint, uint32, uint16, uint8:
+		default:
+			ok = false
+		}
+	case AttrFloat:
+		switch val.(type) {
+		case float64, float32:
+		default:
+			ok = false
+		}
+	case AttrBool:
+		_, ok = val.(bool)
+	case AttrStr:
+		_, ok = val.(string)
+	case AttrMap:
+		var items map[string]interface{}
+		switch m := val.(type) {
+		case *MapAttr:
+			items = m.attrs
+		case map[string]interface{}:
+			items = m
+		default:
+			ok = false
+		}
+		for k, v := range items {
+			if t.Fields != nil && t.Fields[k] == nil {
+				return errors.Errorf("attribute %s: key %s is not declared in %s", path, k, t)
+			}
+			if et := t.childType(k); et != nil {
+				if err := et.check(path+"."+k, v); err != nil {
+					return err
+				}
+			}
+		}
+	case AttrList:
+		var items []interface{}
+		switch l := val.(type) {
+		case *ListAttr:
+			items = l.items
+		case []interface{}:
+			items = l
+		default:
+			ok = false
+		}
+		if t.Elem != nil {
+			for i, v := range items {
+				if err := t.Elem.check(fmt.Sprintf("%s[%d]", path, i), v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !ok {
+		return errors.Errorf("attribute %s: expect %s, but got %T(%v)", path, t, val, val)
+	}
+	return nil
+}
+
+// DefineTypedAttr defines the attribute with declared type, the type is checked when the attribute is set or loaded
+func (desc *EntityTypeDesc) DefineTypedAttr(attr string, t *AttrType, defs ...string) *EntityTypeDesc {
+	desc.DefineAttr(attr, defs...)
+	desc.attrTypes[attr] = t
+	return desc
+}
+
+// attrTypeAt returns the declared type of attribute at the path, or nil if not declared
+func (desc *EntityTypeDesc) attrTypeAt(pathFromLeaf []interface{}) *AttrType {
+	if len(pathFromLeaf) == 0 {
+		return nil
+	}
+
+	t := desc.attrTypes[pathFromLeaf[len(pathFromLeaf)-1].(string)]
+	for i := len(pathFromLeaf) - 2; i >= 0 && t != nil; i-- {
+		t = t.childType(pathFromLeaf[i])
+	}
+	return t
+}
+
+// checkData checks types of attributes in data
+func (desc *EntityTypeDesc) checkData(data map[string]interface{}) error {
+	for k, v := range data {
+		if t := desc.attrTypes[k]; t != nil {
+			if err := t.check(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkAttr checks the type of val to be set at key of the attribute, panics if type mismatches
+func (e *Entity) checkAttr(pathFromLeaf []interface{}, key interface{}, val interface{}) {
+	if len(e.typeDesc.attrTypes) == 0 {
+		return
+	}
+
+	var t *AttrType
+	if len(pathFromLeaf) == 0 {
+		t = e.typeDesc.attrTypes[key.(string)]
+	} else if pt := e.typeDesc.attrTypeAt(pathFromLeaf); pt != nil {
+		if pt.Kind == AttrMap && pt.Fields != nil && pt.Fields[key.(string)] == nil {
+			gwlog.Panicf("%s: attribute %s: key %s is not declared in %s", e.TypeName, formatAttrPath(pathFromLeaf), key, pt)
+		}
+		t = pt.childType(key)
+	}
+
+	if t == nil {
+		return
+	}
+	path := formatAttrPath(append([]interface{}{key}, pathFromLeaf...))
+	if err := t.check(path, val); err != nil {
+		gwlog.Panicf("%s: %s", e.TypeName, err)
+	}
+}
+
+// setAttrDefaults sets default values of declared attributes which are not set
+func setAttrDefaults(attrs *MapAttr, fields map[string]*AttrType) {
+	for k, t := range fields {
+		if !attrs.HasKey(k) && t.Default != nil {
+			attrs.set(k, newAttrValue(t.Default))
+		}
+		if sub, ok := attrs.attrs[k].(*MapAttr); ok && t.Fields != nil {
+			setAttrDefaults(sub, t.Fields)
+		}
+	}
+}
+
+// newAttrValue converts value to attribute value, maps and lists are converted to MapAttr and ListAttr
+func newAttrValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		a := NewMapAttr()
+		a.AssignMap(v)
+		return a
+	case []interface{}:
+		a := NewListAttr()
+		a.AssignList(v)
+		return a
+	default:
+		return uniformAttrType(v)
+	}
+}
+
+// formatAttrPath formats attribute path like a.b[0].c
+func formatAttrPath(pathFromLeaf []interface{}) string {
+	var sb strings.Builder
+	for i := len(pathFromLeaf) - 1; i >= 0; i-- {
+		switch k := pathFromLeaf[i].(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", k)
+		default:
+			if sb.Len() > 0 {
+				sb.WriteByte('.')
+			}
+			fmt.Fprintf(&sb, "%v", k)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+)
+
+type TypedAttrTestEntity struct {
+	Entity
+}
+
+func (e *TypedAttrTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineTypedAttr("level", IntType().WithDefault(1), "Persistent", "Client")
+	desc.DefineTypedAttr("name", StrType(), "Persistent")
+	desc.DefineTypedAttr("bag", ListType(IntType()), "Persistent")
+	desc.DefineTypedAttr("profile", MapType(map[string]*AttrType{
+		"title": StrType().WithDefault("newbie"),
+		"stats": MapOfType(FloatType()),
+	}).WithDefault(map[string]interface{}{}), "Persistent")
+	desc.DefineAttr("any")
+}
+
+// expectPanic calls f and returns the panic message
+func expectPanic(t *testing.T, f func()) (msg string) {
+	defer func() {
+		if err := recover(); err != nil {
+			msg = strings.TrimSpace(strings.SplitN(stringify(err), "\n", 2)[0])
+		}
+	}()
+	f()
+	t.Fatalf("should panic")
+	return
+}
+
+func stringify(v interface{}) string {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v.(string)
+}
+
+func TestTypedAttrs(t *testing.T) {
+	desc := RegisterEntity("TypedAttrTestEntity", &TypedAttrTestEntity{}, false)
+	e := CreateEntityLocally("TypedAttrTestEntity", nil)
+
+	// default values
+	if e.Attrs.GetInt("level") != 1 || e.Attrs.GetMapAttr("profile").GetStr("title") != "newbie" {
+		t.Errorf("default values are not set: %v", e.Attrs)
+	}
+
+	e.Attrs.SetInt("level", 2)
+	e.Attrs.SetStr("any", "anything")
+	e.Attrs.SetFloat("any", 1.0)
+	e.Attrs.GetListAttr("bag").AppendInt(1)
+	e.Attrs.GetMapAttr("profile").GetMapAttr("stats").SetFloat("atk", 1.5)
+
+	if msg := expectPanic(t, func() { e.Attrs.SetStr("level", "2") }); !strings.Contains(msg, "TypedAttrTestEntity: attribute level: expect int") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	if msg := expectPanic(t, func() { e.Attrs.GetListAttr("bag").AppendStr("x") }); !strings.Contains(msg, "attribute bag[1]: expect int") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	if msg := expectPanic(t, func() { e.Attrs.GetListAttr("bag").SetBool(0, true) }); !strings.Contains(msg, "attribute bag[0]: expect int") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	if msg := expectPanic(t, func() { e.Attrs.GetMapAttr("profile").SetInt("unknown", 1) }); !strings.Contains(msg, "attribute profile: key unknown is not declared") {
+		t.Errorf("wrong error: %s", msg)
+	}
+	if msg := expectPanic(t, func() { e.Attrs.GetMapAttr("profile").GetMapAttr("stats").SetStr("def", "1") }); !strings.Contains(msg, "attribute profile.stats.def: expect float") {
+		t.Errorf("wrong error: %s", msg)
+	}
+
+	// set a whole MapAttr which is checked recursively
+	profile := NewMapAttr()
+	stats := NewMapAttr()
+	stats.SetInt("atk", 1)
+	profile.SetMapAttr("stats", stats)
+	if msg := expectPanic(t, func() { e.Attrs.SetMapAttr("profile", profile) }); !strings.Contains(msg, "attribute profile.stats.atk: expect float") {
+		t.Errorf("wrong error: %s", msg)
+	}
+
+	if err := desc.checkData(map[string]interface{}{"level": int8(3), "bag": []interface{}{int8(1), "2"}}); err == nil || !strings.Contains(err.Error(), "attribute bag[1]: expect int") {
+		t.Errorf("wrong error when checking data: %v", err)
+	}
+	if err := desc.checkData(map[string]interface{}{"level": int8(3), "profile": map[string]interface{}{"title": "hero"}}); err != nil {
+		t.Error(err)
+	}
+
+	if s := desc.attrTypes["profile"].String(); s != "map{stats: map<float>, title: str}" {
+		t.Errorf("wrong type string: %s", s)
+	}
+}
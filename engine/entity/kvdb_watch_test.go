@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/kvdb"
+)
+
+type KVDBWatchTestEntity struct {
+	Entity
+	changes []string
+}
+
+func (e *KVDBWatchTestEntity) DescribeEntityType(desc *EntityTypeDesc) {
+}
+
+func (e *KVDBWatchTestEntity) OnKVDBChanged(key string, val string) {
+	e.changes = append(e.changes, key+"="+val)
+}
+
+func TestEntityWatchKVDB(t *testing.T) {
+	RegisterEntity("KVDBWatchTestEntity", &KVDBWatchTestEntity{}, false)
+	e := CreateEntityLocally("KVDBWatchTestEntity", nil)
+	e.WatchKVDB("event:", "OnKVDBChanged")
+	watcher := e.I.(*KVDBWatchTestEntity)
+
+	kvdb.HandleNotifyKVDBChange("event:1", "start")
+	kvdb.HandleNotifyKVDBChange("flag:1", "on")
+	e.unwatchKVDB() // called when entity is destroyed
+	kvdb.HandleNotifyKVDBChange("event:1", "")
+
+	changes := watcher.changes
+	if len(changes) != 1 || changes[0] != "event:1=start" {
+		t.Errorf("entity should be notified of event:1=start only, but got %v", changes)
+	}
+}
@@ -46,4 +46,7 @@ func TestMigrateData(t *testing.T) {
 	if bv, ok := umd.Attrs["bool"]; !ok || bv.(bool) != true {
 		t.Fatalf("bool is not true")
 	}
+	if umd.Epoch != e.epoch || umd.Epoch == 0 {
+		t.Fatalf("Epoch mismatch: %d & %d", umd.Epoch, e.epoch)
+	}
 }
@@ -0,0 +1,181 @@
+package entity
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/typeconv"
+)
+
+var (
+	// ErrCallTimeout is the error given to CallReplyCallback when the reply does not arrive in time.
+	// Target entity might be not found, migrating for too long, or its game might be down.
+	ErrCallTimeout = errors.New("call timeout")
+
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// CallReplyCallback is the type of callback for receiving return values of CallWithReply
+//
+// Return values from remote games are decoded by msgpack, so use ConvertReply to get values of the real types
+type CallReplyCallback func(rets []interface{}, err error)
+
+type pendingCall struct {
+	callback CallReplyCallback
+	timer    *timer.Timer
+}
+
+var (
+	lastCallID   uint32
+	pendingCalls = map[uint32]*pendingCall{}
+)
+
+func genCallID() uint32 {
+	lastCallID += 1
+	if lastCallID == 0 { // call id 0 is never used
+		lastCallID = 1
+	}
+	return lastCallID
+}
+
+// CallWithReply calls the method of entity and receives the return values of the method in callback
+//
+// The callback is always executed in the main routine, either with the return values, or with an error
+// if the method failed or the reply does not arrive in timeout.
+func CallWithReply(id common.EntityID, method string, timeout time.Duration, callback CallReplyCallback, args []interface{}) {
+	callID := genCallID()
+	pendingCalls[callID] = &pendingCall{
+		callback: callback,
+		timer: timer.AddCallback(timeout, func() {
+			onCallReply(callID, nil, ErrCallTimeout)
+		}),
+	}
+
+	if consts.OPTIMIZE_LOCAL_ENTITY_CALL {
+		e := entityManager.get(id)
+		if e != nil { // this entity is local, just call entity directly
+			e.Post(func() {
+				rets, err := e.onCallFromLocal(method, args)
+				if err == nil {
+					var retvals []interface{}
+					retvals, err = collectCallReturns(rets)
+					onCallReply(callID, retvals, err)
+				} else {
+					onCallReply(callID, nil, err)
+				}
+			})
+			return
+		}
+	}
+
+	dispatchercluster.SendCallEntityMethodWithReply(id, method, callID, args)
+}
+
+// OnCallWithReply is called by engine when method call with reply reaches in the game
+func OnCallWithReply(id common.EntityID, method string, args [][]byte, callerGameID uint16, callID uint32) {
+	var rets []interface{}
+	var err error
+
+	e := entityManager.get(id)
+	if e != nil {
+		var retvals []reflect.Value
+		if retvals, err = e.onCallFromRemote(method, args, ""); err == nil {
+			rets, err = collectCallReturns(retvals)
+		}
+	} else {
+		// entity not found, may destroyed before call
+		err = errors.Errorf("entity %s is not found while calling %s", id, method)
+	}
+
+	var errmsg string
+	if err != nil {
+		errmsg = err.Error()
+	}
+	dispatchercluster.SendCallEntityMethodReply(id, callerGameID, callID, errmsg, rets)
+}
+
+// OnCallReply is called by engine when the reply of a method call reaches in the caller game
+func OnCallReply(callID uint32, errmsg string, rets [][]byte) {
+	if errmsg != "" {
+		onCallReply(callID, nil, errors.New(errmsg))
+		return
+	}
+
+	retvals := make([]interface{}, len(rets))
+	for i, ret := range rets {
+		if err := netutil.MSG_PACKER.UnpackMsg(ret, &retvals[i]); err != nil {
+			onCallReply(callID, nil, errors.Wrapf(err, "unpack return value %d failed", i))
+			return
+		}
+	}
+	onCallReply(callID, retvals, nil)
+}
+
+func onCallReply(callID uint32, rets []interface{}, err error) {
+	call := pendingCalls[callID]
+	if call == nil {
+		// the call is already timeout
+		gwlog.Warnf("call %d is replied, but it is already finished or timeout", callID)
+		return
+	}
+
+	delete(pendingCalls, callID)
+	call.timer.Cancel()
+	gwutils.RunPanicless(func() {
+		call.callback(rets, err)
+	})
+}
+
+// collectCallReturns converts return values of RPC to interfaces
+//
+// If the last return value of the RPC is an error, it is returned as the error of the call
+func collectCallReturns(rets []reflect.Value) ([]interface{}, error) {
+	if n := len(rets); n > 0 && rets[n-1].Type() == errorType {
+		if !rets[n-1].IsNil() {
+			return nil, rets[n-1].Interface().(error)
+		}
+		rets = rets[:n-1]
+	}
+
+	retvals := make([]interface{}, len(rets))
+	for i, ret := range rets {
+		retvals[i] = ret.Interface()
+	}
+	return retvals, nil
+}
+
+// ConvertReply converts the return values given to CallReplyCallback to specified types
+//
+// Example:
+//
+//	var level int
+//	var name string
+//	err := entity.ConvertReply(rets, &level, &name)
+func ConvertReply(rets []interface{}, ptrs ...interface{}) error {
+	if len(rets) < len(ptrs) {
+		return errors.Errorf("expect %d return values, but got %d", len(ptrs), len(rets))
+	}
+
+	for i, ptr := range ptrs {
+		ptrVal := reflect.ValueOf(ptr)
+		if ptrVal.Kind() != reflect.Ptr {
+			return errors.Errorf("argument %d should be a pointer, but is %T", i, ptr)
+		}
+
+		elem := ptrVal.Elem()
+		if err := gwutils.CatchPanic(func() {
+			elem.Set(typeconv.Convert(rets[i], elem.Type()))
+		}); err != nil {
+			return errors.Errorf("convert return value %d failed: %v", i, err)
+		}
+	}
+	return nil
+}
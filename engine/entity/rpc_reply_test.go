@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+type TestReplyEntity struct {
+	Entity
+}
+
+func (e *TestReplyEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestReplyEntity) Add(a, b int) int {
+	return a + b
+}
+
+func (e *TestReplyEntity) Div(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("divided by zero")
+	}
+	return a / b, nil
+}
+
+func TestCallWithReplyLocal(t *testing.T) {
+	RegisterEntity("TestReplyEntity", &TestReplyEntity{}, false)
+	e := CreateEntityLocally("TestReplyEntity", nil)
+
+	var sum int
+	replied := false
+	CallWithReply(e.ID, "Add", time.Second, func(rets []interface{}, err error) {
+		replied = true
+		if err != nil {
+			t.Fatalf("call Add failed: %s", err)
+		}
+		if err := ConvertReply(rets, &sum); err != nil {
+			t.Fatal(err)
+		}
+	}, []interface{}{1, 2})
+	post.Tick()
+	if !replied || sum != 3 {
+		t.Fatalf("call Add: replied=%v, sum=%d", replied, sum)
+	}
+
+	var divErr error
+	CallWithReply(e.ID, "Div", time.Second, func(rets []interface{}, err error) {
+		divErr = err
+	}, []interface{}{1, 0})
+	post.Tick()
+	if divErr == nil {
+		t.Fatalf("call Div should fail")
+	}
+
+	var invalidErr error
+	CallWithReply(e.ID, "NotExists", time.Second, func(rets []interface{}, err error) {
+		invalidErr = err
+	}, nil)
+	post.Tick()
+	if invalidErr == nil {
+		t.Fatalf("call NotExists should fail")
+	}
+
+	if len(pendingCalls) != 0 {
+		t.Fatalf("%d calls are still pending", len(pendingCalls))
+	}
+}
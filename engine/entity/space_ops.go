@@ -15,7 +15,7 @@ func CreateSpaceLocally(kind int) *Space {
 	}
 	e := createEntity(_SPACE_ENTITY_TYPE, nil, Vector3{}, "", map[string]interface{}{
 		_SPACE_KIND_ATTR_KEY: kind,
-	})
+	}, false)
 	return e.AsSpace()
 }
 
@@ -34,7 +34,7 @@ func CreateNilSpace(gameid uint16) *Space {
 	spaceID := GetNilSpaceID(gameid)
 	e := createEntity(_SPACE_ENTITY_TYPE, nil, Vector3{}, spaceID, map[string]interface{}{
 		_SPACE_KIND_ATTR_KEY: 0,
-	})
+	}, false)
 	return e.AsSpace()
 }
 
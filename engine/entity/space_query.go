@@ -0,0 +1,242 @@
+package entity
+
+import (
+	"math"
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+type spaceCell struct {
+	x, z int
+}
+
+// spaceIndex is a uniform grid on X-Z plane for space queries
+//
+// spaceIndex is maintained when entities enter, move in and leave space, no matter whether AOI is enabled.
+type spaceIndex struct {
+	cells       map[spaceCell]EntitySet
+	entityCells map[*Entity]spaceCell
+}
+
+func newSpaceIndex() *spaceIndex {
+	return &spaceIndex{
+		cells:       map[spaceCell]EntitySet{},
+		entityCells: map[*Entity]spaceCell{},
+	}
+}
+
+func cellOf(x, z Coord) spaceCell {
+	return spaceCell{
+		x: int(math.Floor(float64(x / consts.SPACE_QUERY_CELL_SIZE))),
+		z: int(math.Floor(float64(z / consts.SPACE_QUERY_CELL_SIZE))),
+	}
+}
+
+func (idx *spaceIndex) add(entity *Entity, pos Vector3) {
+	cell := cellOf(pos.X, pos.Z)
+	idx.entityCells[entity] = cell
+	es := idx.cells[cell]
+	if es == nil {
+		es = EntitySet{}
+		idx.cells[cell] = es
+	}
+	es.Add(entity)
+}
+
+func (idx *spaceIndex) remove(entity *Entity) {
+	cell := idx.entityCells[entity]
+	delete(idx.entityCells, entity)
+	es := idx.cells[cell]
+	es.Del(entity)
+	if len(es) == 0 {
+		delete(idx.cells, cell)
+	}
+}
+
+func (idx *spaceIndex) move(entity *Entity, pos Vector3) {
+	if cellOf(pos.X, pos.Z) != idx.entityCells[entity] {
+		idx.remove(entity)
+		idx.add(entity, pos)
+	}
+}
+
+// visitRange visits all entities in cells overlapping with the range on X-Z plane
+func (idx *spaceIndex) visitRange(minX, minZ, maxX, maxZ Coord, f func(e *Entity)) {
+	mincell, maxcell := cellOf(minX, minZ), cellOf(maxX, maxZ)
+	if (maxcell.x-mincell.x+1)*(maxcell.z-mincell.z+1) > len(idx.cells) {
+		// the range covers too many cells, just visit all cells
+		for cell, es := range idx.cells {
+			if cell.x >= mincell.x && cell.x <= maxcell.x && cell.z >= mincell.z && cell.z <= maxcell.z {
+				es.ForEach(f)
+			}
+		}
+		return
+	}
+
+	for x := mincell.x; x <= maxcell.x; x++ {
+		for z := mincell.z; z <= maxcell.z; z++ {
+			if es := idx.cells[spaceCell{x, z}]; es != nil {
+				es.ForEach(f)
+			}
+		}
+	}
+}
+
+// entityTypeFilter returns a function which checks if an entity is one of the specified types
+//
+// All entities pass the filter if no type is specified
+func entityTypeFilter(typeNames []string) func(e *Entity) bool {
+	if len(typeNames) == 0 {
+		return func(e *Entity) bool { return true }
+	}
+
+	return func(e *Entity) bool {
+		for _, typeName := range typeNames {
+			if e.TypeName == typeName {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// QueryRadius returns entities in space within radius of the center
+//
+// If typeNames are specified, only entities of these types are returned
+func (space *Space) QueryRadius(center Vector3, radius Coord, typeNames ...string) []*Entity {
+	var res []*Entity
+	filter := entityTypeFilter(typeNames)
+	space.index.visitRange(center.X-radius, center.Z-radius, center.X+radius, center.Z+radius, func(e *Entity) {
+		if filter(e) && e.Position.DistanceTo(center) <= radius {
+			res = append(res, e)
+		}
+	})
+	return res
+}
+
+// QueryBox returns entities in space inside the axis-aligned box between min and max
+//
+// If typeNames are specified, only entities of these types are returned
+func (space *Space) QueryBox(min, max Vector3, typeNames ...string) []*Entity {
+	var res []*Entity
+	filter := entityTypeFilter(typeNames)
+	space.index.visitRange(min.X, min.Z, max.X, max.Z, func(e *Entity) {
+		pos := e.Position
+		if filter(e) && pos.X >= min.X && pos.X <= max.X && pos.Y >= min.Y && pos.Y <= max.Y && pos.Z >= min.Z && pos.Z <= max.Z {
+			res = append(res, e)
+		}
+	})
+	return res
+}
+
+// QueryCone returns entities in space inside the cone
+//
+// The cone starts from apex towards dir, with length of radius. halfAngle is the angle in degrees between dir
+// and the edge of the cone. If dir is zero, the cone has no direction and it is queried as a sphere like QueryRadius.
+// If typeNames are specified, only entities of these types are returned
+func (space *Space) QueryCone(apex Vector3, dir Vector3, halfAngle Yaw, radius Coord, typeNames ...string) []*Entity {
+	if dir == (Vector3{}) {
+		return space.QueryRadius(apex, radius, typeNames...)
+	}
+
+	var res []*Entity
+	filter := entityTypeFilter(typeNames)
+	dir.Normalize()
+	cosHalfAngle := Coord(math.Cos(float64(halfAngle) / 180 * math.Pi))
+	space.index.visitRange(apex.X-radius, apex.Z-radius, apex.X+radius, apex.Z+radius, func(e *Entity) {
+		if !filter(e) {
+			return
+		}
+
+		d := e.Position.Sub(apex)
+		dist := d.DistanceTo(Vector3{})
+		if dist > radius {
+			return
+		}
+		// entity at the apex is always in the cone
+		if dist == 0 || (d.X*dir.X+d.Y*dir.Y+d.Z*dir.Z)/dist >= cosHalfAngle {
+			res = append(res, e)
+		}
+	})
+	return res
+}
+
+// NearestN returns at most n entities in space which are nearest to the center, sorted by distance
+//
+// If typeNames are specified, only entities of these types are returned
+func (space *Space) NearestN(center Vector3, n int, typeNames ...string) []*Entity {
+	if n <= 0 {
+		return nil
+	}
+
+	type candidate struct {
+		entity *Entity
+		dist   Coord
+	}
+
+	var candidates []candidate
+	filter := entityTypeFilter(typeNames)
+	idx := space.index
+	centerCell := cellOf(center.X, center.Z)
+	visited := 0
+	// search cells ring by ring, entities in unvisited cells are farther than r * cell size
+	for r := 0; visited < len(idx.entityCells); r++ {
+		if (2*r+1)*(2*r+1) > len(idx.cells) {
+			// entities are sparse, just check all entities
+			candidates = candidates[:0]
+			for e := range idx.entityCells {
+				if filter(e) {
+					candidates = append(candidates, candidate{e, e.Position.DistanceTo(center)})
+				}
+			}
+			break
+		}
+
+		visitCell := func(x, z int) {
+			es := idx.cells[spaceCell{x, z}]
+			visited += len(es)
+			for e := range es {
+				if filter(e) {
+					candidates = append(candidates, candidate{e, e.Position.DistanceTo(center)})
+				}
+			}
+		}
+
+		if r == 0 {
+			visitCell(centerCell.x, centerCell.z)
+		} else {
+			for i := -r; i <= r; i++ {
+				visitCell(centerCell.x+i, centerCell.z-r)
+				visitCell(centerCell.x+i, centerCell.z+r)
+			}
+			for i := -r + 1; i <= r-1; i++ {
+				visitCell(centerCell.x-r, centerCell.z+i)
+				visitCell(centerCell.x+r, centerCell.z+i)
+			}
+		}
+
+		found := 0
+		for _, c := range candidates {
+			if c.dist <= Coord(r)*consts.SPACE_QUERY_CELL_SIZE {
+				found += 1
+			}
+		}
+		if found >= n {
+			break
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].dist < candidates[j].dist
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	res := make([]*Entity, len(candidates))
+	for i, c := range candidates {
+		res[i] = c.entity
+	}
+	return res
+}
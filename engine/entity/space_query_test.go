@@ -0,0 +1,115 @@
+package entity
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func newTestQuerySpace(n int) (*Space, []*Entity) {
+	space := &Space{index: newSpaceIndex()}
+	var entities []*Entity
+	for i := 0; i < n; i++ {
+		e := &Entity{TypeName: "Monster"}
+		if i%3 == 0 {
+			e.TypeName = "Avatar"
+		}
+		e.Position = Vector3{Coord(rand.Intn(200) - 100), Coord(rand.Intn(10)), Coord(rand.Intn(200) - 100)}
+		space.index.add(e, e.Position)
+		entities = append(entities, e)
+	}
+	return space, entities
+}
+
+func sameEntities(a, b []*Entity) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := EntitySet{}
+	for _, e := range a {
+		set.Add(e)
+	}
+	for _, e := range b {
+		if !set.Contains(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSpaceQuery(t *testing.T) {
+	space, entities := newTestQuerySpace(500)
+	// move some entities around to test index updates
+	for _, e := range entities[:100] {
+		e.Position = Vector3{Coord(rand.Intn(200) - 100), Coord(rand.Intn(10)), Coord(rand.Intn(200) - 100)}
+		space.index.move(e, e.Position)
+	}
+
+	center := Vector3{10, 0, -20}
+	var expect []*Entity
+	for _, e := range entities {
+		if e.TypeName == "Monster" && e.Position.DistanceTo(center) <= 30 {
+			expect = append(expect, e)
+		}
+	}
+	if res := space.QueryRadius(center, 30, "Monster"); !sameEntities(res, expect) {
+		t.Fatalf("QueryRadius: got %d entities, expect %d", len(res), len(expect))
+	}
+
+	min, max := Vector3{-50, 2, -10}, Vector3{0, 8, 40}
+	expect = nil
+	for _, e := range entities {
+		p := e.Position
+		if p.X >= min.X && p.X <= max.X && p.Y >= min.Y && p.Y <= max.Y && p.Z >= min.Z && p.Z <= max.Z {
+			expect = append(expect, e)
+		}
+	}
+	if res := space.QueryBox(min, max); !sameEntities(res, expect) {
+		t.Fatalf("QueryBox: got %d entities, expect %d", len(res), len(expect))
+	}
+
+	expect = nil
+	for _, e := range entities {
+		d := e.Position.Sub(center)
+		if e.TypeName == "Avatar" && d.X > 0 && d.X*d.X >= 3*(d.Y*d.Y+d.Z*d.Z) && e.Position.DistanceTo(center) <= 50 {
+			expect = append(expect, e)
+		}
+	}
+	if res := space.QueryCone(center, Vector3{1, 0, 0}, 30, 50, "Avatar"); !sameEntities(res, expect) {
+		t.Fatalf("QueryCone: got %d entities, expect %d", len(res), len(expect))
+	}
+
+	// cone without direction is queried as a sphere
+	if res, expect := space.QueryCone(center, Vector3{}, 30, 50, "Avatar"), space.QueryRadius(center, 50, "Avatar"); len(expect) == 0 || !sameEntities(res, expect) {
+		t.Fatalf("QueryCone with zero direction: got %d entities, expect %d", len(res), len(expect))
+	}
+}
+
+func TestSpaceNearestN(t *testing.T) {
+	space, entities := newTestQuerySpace(500)
+	center := Vector3{33, 0, 44}
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].Position.DistanceTo(center) < entities[j].Position.DistanceTo(center)
+	})
+
+	res := space.NearestN(center, 10)
+	if len(res) != 10 {
+		t.Fatalf("NearestN: got %d entities", len(res))
+	}
+	for i, e := range res {
+		if e.Position.DistanceTo(center) != entities[i].Position.DistanceTo(center) {
+			t.Fatalf("NearestN: entity %d is at distance %v, expect %v", i, e.Position.DistanceTo(center), entities[i].Position.DistanceTo(center))
+		}
+	}
+
+	if res := space.NearestN(center, 1000); len(res) != len(entities) {
+		t.Fatalf("NearestN: should return all %d entities, but got %d", len(entities), len(res))
+	}
+
+	sparse := &Space{index: newSpaceIndex()}
+	far := &Entity{TypeName: "Avatar", Position: Vector3{10000, 0, 10000}}
+	sparse.index.add(far, far.Position)
+	if res := sparse.NearestN(Vector3{}, 1, "Avatar"); len(res) != 1 || res[0] != far {
+		t.Fatalf("NearestN: should find the far entity")
+	}
+}
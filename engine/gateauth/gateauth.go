@@ -0,0 +1,55 @@
+// Package gateauth provides client authentication for gates
+//
+// When auth_type is configured in gate config, clients must send an auth ticket to the gate before the boot entity
+// is created. The verified identity of client is passed to the boot entity through GameClient.Identity.
+package gateauth
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// Verifier verifies auth tickets sent by clients
+//
+// Verify might be called in multiple goroutines at the same time, and might block for a while
+type Verifier interface {
+	// Verify verifies the ticket and returns the identity of the client
+	Verify(ticket string) (identity string, err error)
+}
+
+// VerifierFactory creates a Verifier using gate config
+type VerifierFactory func(cfg *config.GateConfig) (Verifier, error)
+
+var (
+	// ErrInvalidTicket is returned if the ticket is malformed or the signature is wrong
+	ErrInvalidTicket = errors.New("invalid ticket")
+	// ErrTicketExpired is returned if the ticket is expired
+	ErrTicketExpired = errors.New("ticket expired")
+
+	verifierFactories = map[string]VerifierFactory{
+		"hmac":  newHMACVerifier,
+		"token": newTokenVerifier,
+	}
+)
+
+// RegisterVerifier registers a custom Verifier factory for auth type
+//
+// RegisterVerifier should be called in init function of gate
+func RegisterVerifier(authType string, factory VerifierFactory) {
+	verifierFactories[authType] = factory
+}
+
+// NewVerifier creates the Verifier according to gate config
+//
+// NewVerifier returns nil if auth is not enabled
+func NewVerifier(cfg *config.GateConfig) (Verifier, error) {
+	if cfg.AuthType == "" {
+		return nil, nil
+	}
+
+	factory := verifierFactories[cfg.AuthType]
+	if factory == nil {
+		return nil, errors.Errorf("unknown auth type: %s", cfg.AuthType)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,63 @@
+package gateauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+func TestHMACVerifier(t *testing.T) {
+	v, err := NewVerifier(&config.GateConfig{AuthType: "hmac", AuthSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket := GenHMACTicket("secret", "user:1", time.Now().Add(time.Minute))
+	if identity, err := v.Verify(ticket); err != nil || identity != "user:1" {
+		t.Fatalf("verify failed: identity=%s, err=%v", identity, err)
+	}
+
+	if _, err := v.Verify(GenHMACTicket("wrong", "user:1", time.Now().Add(time.Minute))); err != ErrInvalidTicket {
+		t.Fatalf("ticket with wrong secret should be invalid, but err=%v", err)
+	}
+	if _, err := v.Verify("user:1" + ticket[len("user:1")+1:]); err != ErrInvalidTicket {
+		t.Fatalf("tampered ticket should be invalid, but err=%v", err)
+	}
+	if _, err := v.Verify(GenHMACTicket("secret", "user:1", time.Now().Add(-time.Minute))); err != ErrTicketExpired {
+		t.Fatalf("ticket should be expired, but err=%v", err)
+	}
+}
+
+func TestTokenVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("token") != "good" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("user1"))
+	}))
+	defer server.Close()
+
+	v, err := NewVerifier(&config.GateConfig{AuthType: "token", AuthURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, err := v.Verify("good"); err != nil || identity != "user1" {
+		t.Fatalf("verify failed: identity=%s, err=%v", identity, err)
+	}
+	if _, err := v.Verify("bad"); err != ErrInvalidTicket {
+		t.Fatalf("bad token should be invalid, but err=%v", err)
+	}
+}
+
+func TestNoAuth(t *testing.T) {
+	if v, err := NewVerifier(&config.GateConfig{}); v != nil || err != nil {
+		t.Fatalf("verifier should be nil if auth type is not set")
+	}
+	if _, err := NewVerifier(&config.GateConfig{AuthType: "unknown"}); err == nil {
+		t.Fatalf("unknown auth type should fail")
+	}
+}
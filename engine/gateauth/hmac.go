@@ -0,0 +1,60 @@
+package gateauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// hmacVerifier verifies tickets signed by login servers using the shared secret
+//
+// Ticket format: <identity>:<expire unix time>:<hex of HMAC-SHA256(secret, "<identity>:<expire unix time>")>
+type hmacVerifier struct {
+	secret []byte
+}
+
+func newHMACVerifier(cfg *config.GateConfig) (Verifier, error) {
+	return &hmacVerifier{secret: []byte(cfg.AuthSecret)}, nil
+}
+
+// GenHMACTicket generates a ticket for hmac auth type, which should be used by login servers
+func GenHMACTicket(secret string, identity string, expire time.Time) string {
+	msg := identity + ":" + strconv.FormatInt(expire.Unix(), 10)
+	return msg + ":" + hex.EncodeToString(signHMAC([]byte(secret), msg))
+}
+
+func signHMAC(secret []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func (v *hmacVerifier) Verify(ticket string) (string, error) {
+	sigIdx := strings.LastIndexByte(ticket, ':')
+	if sigIdx < 0 {
+		return "", ErrInvalidTicket
+	}
+	msg := ticket[:sigIdx]
+	sig, err := hex.DecodeString(ticket[sigIdx+1:])
+	if err != nil || !hmac.Equal(sig, signHMAC(v.secret, msg)) {
+		return "", ErrInvalidTicket
+	}
+
+	expireIdx := strings.LastIndexByte(msg, ':')
+	if expireIdx < 0 {
+		return "", ErrInvalidTicket
+	}
+	expire, err := strconv.ParseInt(msg[expireIdx+1:], 10, 64)
+	if err != nil {
+		return "", ErrInvalidTicket
+	}
+	if time.Now().Unix() > expire {
+		return "", ErrTicketExpired
+	}
+	return msg[:expireIdx], nil
+}
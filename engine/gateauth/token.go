@@ -0,0 +1,52 @@
+package gateauth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+const tokenVerifyTimeout = time.Second * 5
+
+// tokenVerifier verifies tokens by posting them to the auth URL
+//
+// The auth server should reply 200 with the identity as body if the token is valid
+type tokenVerifier struct {
+	url    string
+	client *http.Client
+}
+
+func newTokenVerifier(cfg *config.GateConfig) (Verifier, error) {
+	if _, err := url.Parse(cfg.AuthURL); err != nil {
+		return nil, errors.Wrap(err, "parse auth url failed")
+	}
+
+	return &tokenVerifier{
+		url:    cfg.AuthURL,
+		client: &http.Client{Timeout: tokenVerifyTimeout},
+	}, nil
+}
+
+func (v *tokenVerifier) Verify(token string) (string, error) {
+	resp, err := v.client.PostForm(v.url, url.Values{"token": {token}})
+	if err != nil {
+		return "", errors.Wrap(err, "verify token failed")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read verify response failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrInvalidTicket
+	}
+	if len(body) == 0 {
+		return "", errors.New("auth server returns empty identity")
+	}
+	return string(body), nil
+}
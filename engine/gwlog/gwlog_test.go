@@ -1,10 +1,13 @@
 package gwlog
 
-import "testing"
+import (
+	"path/filepath"
+	"testing"
+)
 
 func TestGWLog(t *testing.T) {
 	SetSource("gwlog_test")
-	SetOutput([]string{"stderr", "gwlog_test.log"})
+	SetOutput([]string{"stderr", filepath.Join(t.TempDir(), "gwlog_test.log")})
 	SetLevel(DebugLevel)
 
 	if lv := ParseLevel("debug"); lv != DebugLevel {
@@ -5,6 +5,11 @@ import (
 
 	"io"
 
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
 	"gopkg.in/mgo.v2/bson"
@@ -13,8 +18,30 @@ import (
 const (
 	_DEFAULT_DB_NAME = "goworld"
 	_VAL_KEY         = "_"
+	_EXPIRE_KEY      = "e"
 )
 
+type kvDoc struct {
+	Key    string    `bson:"_id"`
+	Val    string    `bson:"_"`
+	Expire time.Time `bson:"e,omitempty"` // zero if the key never expires
+}
+
+func (doc *kvDoc) expired(now time.Time) bool {
+	return !doc.Expire.IsZero() && !doc.Expire.After(now)
+}
+
+// notExpired returns the query of documents that are not expired
+func notExpired(now time.Time) bson.M {
+	return bson.M{"$or": []bson.M{
+		{_EXPIRE_KEY: bson.M{"$exists": false}},
+		{_EXPIRE_KEY: bson.M{"$gt": now}},
+	}}
+}
+
+// mongoKVDB does not implement KVDBBatcher, because documents are also written and expired (by the TTL index) outside
+// transactions of mgo/txn, so batches can not be atomic. It does not implement KVDBNotifier either, since change streams
+// are not supported by mgo, so changes are watched by relaying of dispatchers only
 type mongoKVDB struct {
 	s *mgo.Session
 	c *mgo.Collection
@@ -35,6 +62,11 @@ func OpenMongoKVDB(url string, dbname string, collectionName string) (kvdbtypes.
 	}
 	db := session.DB(dbname)
 	c := db.C(collectionName)
+	// expired documents are filtered out when read, and removed by the TTL index later
+	if err := c.EnsureIndex(mgo.Index{Key: []string{_EXPIRE_KEY}, ExpireAfter: time.Second}); err != nil {
+		session.Close()
+		return nil, err
+	}
 	return &mongoKVDB{
 		s: session,
 		c: c,
@@ -50,7 +82,7 @@ func (kvdb *mongoKVDB) Put(key string, val string) error {
 
 func (kvdb *mongoKVDB) Get(key string) (val string, err error) {
 	q := kvdb.c.FindId(key)
-	var doc map[string]string
+	var doc kvDoc
 	err = q.One(&doc)
 	if err != nil {
 		if err == mgo.ErrNotFound {
@@ -58,21 +90,106 @@ func (kvdb *mongoKVDB) Get(key string) (val string, err error) {
 		}
 		return
 	}
-	val = doc[_VAL_KEY]
+	if doc.expired(time.Now()) {
+		return
+	}
+	val = doc.Val
 	return
 }
 
+func (kvdb *mongoKVDB) Delete(key string) error {
+	err := kvdb.c.RemoveId(key)
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
+func (kvdb *mongoKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return kvdb.Put(key, val)
+	}
+	_, err := kvdb.c.UpsertId(key, bson.M{
+		_VAL_KEY:    val,
+		_EXPIRE_KEY: time.Now().Add(ttl),
+	})
+	return err
+}
+
+func (kvdb *mongoKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	update := bson.M{"$set": bson.M{_VAL_KEY: newVal}}
+	if oldVal != "" {
+		query := notExpired(time.Now())
+		query["_id"], query[_VAL_KEY] = key, oldVal
+		err := kvdb.c.Update(query, update)
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	// oldVal "" matches missing, expired or empty value
+	for {
+		now := time.Now()
+		err := kvdb.c.Remove(bson.M{"_id": key, _EXPIRE_KEY: bson.M{"$lte": now}})
+		if err != nil && err != mgo.ErrNotFound {
+			return false, err
+		}
+
+		query := notExpired(now)
+		query["_id"], query[_VAL_KEY] = key, ""
+		_, err = kvdb.c.Upsert(query, update)
+		if err == nil {
+			return true, nil
+		}
+		if !mgo.IsDup(err) {
+			return false, err
+		}
+
+		// key exists with other value, unless it has expired or been deleted since
+		val, err := kvdb.Get(key)
+		if err != nil || val != "" {
+			return false, err
+		}
+	}
+}
+
+func (kvdb *mongoKVDB) Incr(key string, delta int64) (int64, error) {
+	for {
+		oldVal, err := kvdb.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var val int64
+		if oldVal != "" {
+			if val, err = strconv.ParseInt(oldVal, 10, 64); err != nil {
+				return 0, errors.Errorf("value of key %s is not an integer: %s", key, oldVal)
+			}
+		}
+		val += delta
+
+		swapped, err := kvdb.CompareAndSwap(key, oldVal, strconv.FormatInt(val, 10))
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return val, nil
+		}
+	}
+}
+
 type mongoKVIterator struct {
 	it *mgo.Iter
 }
 
 func (it *mongoKVIterator) Next() (kvdbtypes.KVItem, error) {
-	var doc map[string]string
+	var doc kvDoc
 	ok := it.it.Next(&doc)
 	if ok {
 		return kvdbtypes.KVItem{
-			Key: doc["_id"],
-			Val: doc["_"],
+			Key: doc.Key,
+			Val: doc.Val,
 		}, nil
 	}
 
@@ -83,8 +200,30 @@ func (it *mongoKVIterator) Next() (kvdbtypes.KVItem, error) {
 	return kvdbtypes.KVItem{}, io.EOF
 }
 
+func (it *mongoKVIterator) Close() error {
+	return it.it.Close()
+}
+
 func (kvdb *mongoKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
-	q := kvdb.c.Find(bson.M{"_id": bson.M{"$gte": beginKey, "$lt": endKey}})
+	return kvdb.FindRange(beginKey, endKey, false, 0)
+}
+
+func (kvdb *mongoKVDB) FindRange(beginKey string, endKey string, reverse bool, limit int) (kvdbtypes.Iterator, error) {
+	keyRange := bson.M{"$gte": beginKey}
+	if endKey != "" {
+		keyRange["$lt"] = endKey
+	}
+	query := notExpired(time.Now())
+	query["_id"] = keyRange
+
+	sort := "_id"
+	if reverse {
+		sort = "-_id"
+	}
+	q := kvdb.c.Find(query).Sort(sort)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
 	it := q.Iter()
 	return &mongoKVIterator{
 		it: it,
@@ -0,0 +1,303 @@
+package kvdbembedded
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Each value is stored as 8 bytes big-endian expire time in unix nanoseconds (0 if never expire) followed by the value.
+// Expired keys are read as missing, and removed periodically.
+const (
+	expireSize          = 8
+	purgeExpireInterval = time.Minute
+	iteratorBatchSize   = 100
+)
+
+var bucketName = []byte("__kv__")
+
+type embeddedKVDB struct {
+	db        *bolt.DB
+	closeChan chan struct{}
+}
+
+// OpenEmbeddedKVDB opens the embedded database file at path as KVDB engine
+//
+// The database file is locked by the process which opens it, so it can not be shared by multiple games.
+func OpenEmbeddedKVDB(path string) (kvdbtypes.KVDBEngine, error) {
+	gwlog.Debugf("Opening embedded KVDB %s ...", path)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open embedded KVDB %s failed", path)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	kvdb := &embeddedKVDB{
+		db:        db,
+		closeChan: make(chan struct{}),
+	}
+	go kvdb.purgeExpiredRoutine()
+	return kvdb, nil
+}
+
+func encodeValue(val string, expire int64) []byte {
+	b := make([]byte, expireSize+len(val))
+	binary.BigEndian.PutUint64(b, uint64(expire))
+	copy(b[expireSize:], val)
+	return b
+}
+
+// decodeValue returns the value and expire time, ok is false if the value is missing or expired
+func decodeValue(b []byte, now int64) (val string, expire int64, ok bool) {
+	if len(b) < expireSize {
+		return "", 0, false
+	}
+	expire = int64(binary.BigEndian.Uint64(b))
+	if expire != 0 && expire <= now {
+		return "", 0, false
+	}
+	return string(b[expireSize:]), expire, true
+}
+
+func (kvdb *embeddedKVDB) Get(key string) (val string, err error) {
+	err = kvdb.db.View(func(tx *bolt.Tx) error {
+		val, _, _ = decodeValue(tx.Bucket(bucketName).Get([]byte(key)), time.Now().UnixNano())
+		return nil
+	})
+	return
+}
+
+func (kvdb *embeddedKVDB) Put(key string, val string) error {
+	return kvdb.PutWithTTL(key, val, 0)
+}
+
+func (kvdb *embeddedKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return kvdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(key), encodeValue(val, expire))
+	})
+}
+
+func (kvdb *embeddedKVDB) Delete(key string) error {
+	return kvdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(key))
+	})
+}
+
+func (kvdb *embeddedKVDB) CompareAndSwap(key string, oldVal string, newVal string) (swapped bool, err error) {
+	err = kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		val, expire, _ := decodeValue(bucket.Get([]byte(key)), time.Now().UnixNano())
+		if swapped = val == oldVal; !swapped {
+			return nil
+		}
+		return bucket.Put([]byte(key), encodeValue(newVal, expire))
+	})
+	return
+}
+
+func (kvdb *embeddedKVDB) Incr(key string, delta int64) (val int64, err error) {
+	err = kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		oldVal, expire, _ := decodeValue(bucket.Get([]byte(key)), time.Now().UnixNano())
+		val = 0
+		if oldVal != "" {
+			var err error
+			if val, err = strconv.ParseInt(oldVal, 10, 64); err != nil {
+				return errors.Errorf("value of key %s is not an integer: %s", key, oldVal)
+			}
+		}
+		val += delta
+		return bucket.Put([]byte(key), encodeValue(strconv.FormatInt(val, 10), expire))
+	})
+	return
+}
+
+func (kvdb *embeddedKVDB) WriteBatch(ops []kvdbtypes.BatchOp) (committed bool, err error) {
+	err = kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		now := time.Now().UnixNano()
+		for _, op := range ops {
+			if op.Type != kvdbtypes.BatchCheck {
+				continue
+			}
+			if val, _, _ := decodeValue(bucket.Get([]byte(op.Key)), now); val != op.Val {
+				return nil
+			}
+		}
+
+		for _, op := range ops {
+			var err error
+			switch op.Type {
+			case kvdbtypes.BatchPut:
+				err = bucket.Put([]byte(op.Key), encodeValue(op.Val, 0))
+			case kvdbtypes.BatchDelete:
+				err = bucket.Delete([]byte(op.Key))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		committed = true
+		return nil
+	})
+	if err != nil {
+		committed = false
+	}
+	return
+}
+
+// embeddedKVDBIterator reads items in batches, each in a short read transaction, so that it holds no transaction
+// between calls of Next
+type embeddedKVDBIterator struct {
+	db       *bolt.DB
+	beginKey []byte
+	endKey   []byte // exclusive, empty means no upper bound
+	reverse  bool
+	left     int // number of items left to return, negative means no limit
+	items    []kvdbtypes.KVItem
+	eof      bool
+}
+
+func (it *embeddedKVDBIterator) Next() (kvdbtypes.KVItem, error) {
+	if it.left == 0 {
+		return kvdbtypes.KVItem{}, io.EOF
+	}
+
+	for len(it.items) == 0 && !it.eof { // batches might contain only expired items
+		if err := it.readBatch(); err != nil {
+			return kvdbtypes.KVItem{}, err
+		}
+	}
+
+	if len(it.items) == 0 {
+		return kvdbtypes.KVItem{}, io.EOF
+	}
+
+	item := it.items[0]
+	it.items = it.items[1:]
+	it.left--
+	return item, nil
+}
+
+func (it *embeddedKVDBIterator) inRange(k []byte) bool {
+	return bytes.Compare(k, it.beginKey) >= 0 && (len(it.endKey) == 0 || bytes.Compare(k, it.endKey) < 0)
+}
+
+// readBatch reads next batch of items and narrows the range to the rest keys
+func (it *embeddedKVDBIterator) readBatch() error {
+	return it.db.View(func(tx *bolt.Tx) error {
+		now := time.Now().UnixNano()
+		c := tx.Bucket(bucketName).Cursor()
+
+		var k, v []byte
+		if !it.reverse {
+			k, v = c.Seek(it.beginKey)
+		} else if len(it.endKey) == 0 {
+			k, v = c.Last()
+		} else if k, v = c.Seek(it.endKey); k != nil {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+
+		batchSize := iteratorBatchSize
+		if it.left >= 0 && it.left < batchSize {
+			batchSize = it.left // read no more than the limit
+		}
+		for n := 0; k != nil && it.inRange(k); n++ {
+			if n == batchSize {
+				return nil // more items to read in next batch
+			}
+			if val, _, ok := decodeValue(v, now); ok {
+				it.items = append(it.items, kvdbtypes.KVItem{Key: string(k), Val: val})
+			}
+
+			if !it.reverse {
+				it.beginKey = append(append(it.beginKey[:0:0], k...), 0) // next key larger than k
+				k, v = c.Next()
+			} else {
+				it.endKey = append(it.endKey[:0:0], k...)
+				k, v = c.Prev()
+			}
+		}
+		it.eof = true
+		return nil
+	})
+}
+
+func (kvdb *embeddedKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
+	return kvdb.FindRange(beginKey, endKey, false, 0)
+}
+
+func (kvdb *embeddedKVDB) FindRange(beginKey string, endKey string, reverse bool, limit int) (kvdbtypes.Iterator, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return &embeddedKVDBIterator{db: kvdb.db, beginKey: []byte(beginKey), endKey: []byte(endKey), reverse: reverse, left: limit}, nil
+}
+
+func (kvdb *embeddedKVDB) purgeExpiredRoutine() {
+	ticker := time.NewTicker(purgeExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := kvdb.purgeExpired(); err != nil {
+				gwlog.Errorf("embedded KVDB: purge expired keys failed: %s", err)
+			}
+		case <-kvdb.closeChan:
+			return
+		}
+	}
+}
+
+func (kvdb *embeddedKVDB) purgeExpired() error {
+	return kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		now := time.Now().UnixNano()
+		var expired [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if _, _, ok := decodeValue(v, now); !ok {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (kvdb *embeddedKVDB) Close() {
+	close(kvdb.closeChan)
+	kvdb.db.Close()
+}
+
+func (kvdb *embeddedKVDB) IsConnectionError(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == bolt.ErrDatabaseNotOpen
+}
@@ -1,10 +1,15 @@
 package kvdbredis
 
 import (
+	"fmt"
 	"io"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
 )
 
@@ -12,8 +17,45 @@ const (
 	keyPrefix = "_KV_"
 )
 
+// compareAndSwapScript sets KEYS[1] to ARGV[2] if the current value is ARGV[1] and keeps the TTL of key
+var compareAndSwapScript = redis.NewScript(1, `
+local v = redis.call('GET', KEYS[1])
+if (v or '') ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// writeBatchScript checks conditions and applies writes of a batch at once, each op has one key in KEYS and
+// type and value in ARGV
+var writeBatchScript = redis.NewScript(-1, `
+for i = 1, #KEYS do
+	if ARGV[2*i-1] == 'check' and (redis.call('GET', KEYS[i]) or '') ~= ARGV[2*i] then
+		return 0
+	end
+end
+for i = 1, #KEYS do
+	if ARGV[2*i-1] == 'put' then
+		redis.call('SET', KEYS[i], ARGV[2*i])
+	elseif ARGV[2*i-1] == 'delete' then
+		redis.call('DEL', KEYS[i])
+	end
+end
+return 1
+`)
+
 type redisKVDB struct {
-	c redis.Conn
+	c       redis.Conn
+	url     string
+	dbindex int
+	psc     *redis.PubSubConn // connection subscribing keyspace notifications
+	closed  int32             // set to 1 when closed, accessed atomically
 }
 
 // OpenRedisKVDB opens Redis for KVDB backend
@@ -24,7 +66,9 @@ func OpenRedisKVDB(url string, dbindex int) (kvdbtypes.KVDBEngine, error) {
 	}
 
 	db := &redisKVDB{
-		c: c,
+		c:       c,
+		url:     url,
+		dbindex: dbindex,
 	}
 
 	if err := db.initialize(dbindex); err != nil {
@@ -64,6 +108,50 @@ func (db *redisKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (db *redisKVDB) Delete(key string) error {
+	_, err := db.c.Do("DEL", keyPrefix+key)
+	return err
+}
+
+func (db *redisKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return db.Put(key, val)
+	}
+	_, err := db.c.Do("SET", keyPrefix+key, val, "PX", int64((ttl+time.Millisecond-1)/time.Millisecond))
+	return err
+}
+
+func (db *redisKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	return redis.Bool(compareAndSwapScript.Do(db.c, keyPrefix+key, oldVal, newVal))
+}
+
+func (db *redisKVDB) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(db.c.Do("INCRBY", keyPrefix+key, delta))
+}
+
+func (db *redisKVDB) WriteBatch(ops []kvdbtypes.BatchOp) (bool, error) {
+	return redis.Bool(writeBatchScript.Do(db.c, batchScriptArgs(ops)...))
+}
+
+// batchScriptArgs returns the arguments of writeBatchScript: number of keys, keys, and type and value of each op
+func batchScriptArgs(ops []kvdbtypes.BatchOp) []interface{} {
+	args := make([]interface{}, 0, 1+len(ops)*3)
+	args = append(args, len(ops))
+	for _, op := range ops {
+		args = append(args, keyPrefix+op.Key)
+	}
+	for _, op := range ops {
+		args = append(args, batchOpNames[op.Type], op.Val)
+	}
+	return args
+}
+
+var batchOpNames = map[kvdbtypes.BatchOpType]string{
+	kvdbtypes.BatchPut:    "put",
+	kvdbtypes.BatchDelete: "delete",
+	kvdbtypes.BatchCheck:  "check",
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
@@ -88,7 +176,69 @@ func (db *redisKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, e
 	return nil, errors.Errorf("operation not supported on redis")
 }
 
+func (db *redisKVDB) FindRange(beginKey string, endKey string, reverse bool, limit int) (kvdbtypes.Iterator, error) {
+	return nil, errors.Errorf("operation not supported on redis")
+}
+
+// Subscribe subscribes keyspace notifications of KVDB keys, which must be enabled by notify-keyspace-events of redis server
+func (db *redisKVDB) Subscribe(onChange func(key string), onStop func(err error)) error {
+	events, err := redis.Strings(db.c.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return errors.Wrap(err, "get notify-keyspace-events failed")
+	}
+	if len(events) != 2 || !keyspaceEventsEnabled(events[1]) {
+		return errors.Errorf("notify-keyspace-events is %v, should be KA or contain K, g, $ and x", events)
+	}
+
+	c, err := redis.DialURL(db.url)
+	if err != nil {
+		return errors.Wrap(err, "redis dail failed")
+	}
+
+	dbindex := db.dbindex
+	if dbindex < 0 {
+		dbindex = 0
+	}
+	channelPrefix := fmt.Sprintf("__keyspace@%d__:%s", dbindex, keyPrefix)
+	psc := &redis.PubSubConn{Conn: c}
+	if err := psc.PSubscribe(channelPrefix + "*"); err != nil {
+		c.Close()
+		return err
+	}
+
+	db.psc = psc
+	go func() {
+		for {
+			switch msg := psc.Receive().(type) {
+			case redis.PMessage:
+				onChange(strings.TrimPrefix(msg.Channel, channelPrefix))
+			case error:
+				if atomic.LoadInt32(&db.closed) == 0 {
+					// KVDB is subscribed again with a new connection by onStop
+					gwlog.Errorf("redis kvdb: receive keyspace notifications failed: %s", msg)
+					psc.Close()
+					onStop(msg)
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// keyspaceEventsEnabled returns if notify-keyspace-events enables keyspace notifications of all KVDB operations
+func keyspaceEventsEnabled(events string) bool {
+	if !strings.Contains(events, "K") {
+		return false
+	}
+	return strings.Contains(events, "A") || (strings.Contains(events, "g") && strings.Contains(events, "$") && strings.Contains(events, "x"))
+}
+
 func (db *redisKVDB) Close() {
+	atomic.StoreInt32(&db.closed, 1)
+	if db.psc != nil {
+		db.psc.Close()
+	}
 	db.c.Close()
 }
 
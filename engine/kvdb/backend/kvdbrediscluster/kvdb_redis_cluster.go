@@ -2,6 +2,7 @@ package kvdbrediscluster
 
 import (
 	"io"
+	"strings"
 
 	"time"
 
@@ -14,6 +15,39 @@ const (
 	keyPrefix = "_KV_"
 )
 
+// compareAndSwapScript sets KEYS[1] to ARGV[2] if the current value is ARGV[1] and keeps the TTL of key
+const compareAndSwapScript = `
+local v = redis.call('GET', KEYS[1])
+if (v or '') ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// writeBatchScript checks conditions and applies writes of a batch at once, each op has one key in KEYS and
+// type and value in ARGV
+const writeBatchScript = `
+for i = 1, #KEYS do
+	if ARGV[2*i-1] == 'check' and (redis.call('GET', KEYS[i]) or '') ~= ARGV[2*i] then
+		return 0
+	end
+end
+for i = 1, #KEYS do
+	if ARGV[2*i-1] == 'put' then
+		redis.call('SET', KEYS[i], ARGV[2*i])
+	elseif ARGV[2*i-1] == 'delete' then
+		redis.call('DEL', KEYS[i])
+	end
+end
+return 1
+`
+
 type redisKVDB struct {
 	c redis.Cluster
 }
@@ -93,6 +127,71 @@ func (db *redisKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (db *redisKVDB) Delete(key string) error {
+	_, err := db.c.Do("DEL", keyPrefix+key)
+	return err
+}
+
+func (db *redisKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return db.Put(key, val)
+	}
+	_, err := db.c.Do("SET", keyPrefix+key, val, "PX", int64((ttl+time.Millisecond-1)/time.Millisecond))
+	return err
+}
+
+func (db *redisKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	// cluster client routes commands by the first argument, which is the script for EVAL, so the command might be sent
+	// to a wrong node at first, and then redirected to the node serving the key by the MOVED reply
+	return redis.Bool(db.c.Do("EVAL", compareAndSwapScript, 1, keyPrefix+key, oldVal, newVal))
+}
+
+func (db *redisKVDB) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(db.c.Do("INCRBY", keyPrefix+key, delta))
+}
+
+// WriteBatch executes the batch by a script, which requires all keys to be served by one node.
+// Keys of the batch should have the same hash tag, e.g. {account:1}name and {account:1}index, otherwise
+// ErrBatchNotAtomic is returned.
+func (db *redisKVDB) WriteBatch(ops []kvdbtypes.BatchOp) (bool, error) {
+	if len(ops) == 0 {
+		return true, nil
+	}
+
+	tag := hashTag(ops[0].Key)
+	args := make([]interface{}, 0, 2+len(ops)*3)
+	args = append(args, writeBatchScript, len(ops))
+	for _, op := range ops {
+		if op.Key != ops[0].Key && (tag == "" || hashTag(op.Key) != tag) {
+			return false, errors.Wrapf(kvdbtypes.ErrBatchNotAtomic, "keys %s and %s might be in different hash slots of redis cluster", ops[0].Key, op.Key)
+		}
+		args = append(args, keyPrefix+op.Key)
+	}
+	for _, op := range ops {
+		args = append(args, batchOpNames[op.Type], op.Val)
+	}
+	return redis.Bool(db.c.Do("EVAL", args...))
+}
+
+var batchOpNames = map[kvdbtypes.BatchOpType]string{
+	kvdbtypes.BatchPut:    "put",
+	kvdbtypes.BatchDelete: "delete",
+	kvdbtypes.BatchCheck:  "check",
+}
+
+// hashTag returns the hash tag of key, which is hashed instead of the whole key by redis cluster, or "" if no hash tag
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return ""
+	}
+	return key[start+1 : start+1+end]
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
@@ -117,6 +216,10 @@ func (db *redisKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, e
 	return nil, errors.Errorf("operation not supported on redis")
 }
 
+func (db *redisKVDB) FindRange(beginKey string, endKey string, reverse bool, limit int) (kvdbtypes.Iterator, error) {
+	return nil, errors.Errorf("operation not supported on redis")
+}
+
 func (db *redisKVDB) Close() {
 }
 
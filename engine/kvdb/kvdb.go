@@ -11,6 +11,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbembedded"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbrediscluster"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
@@ -36,6 +37,25 @@ type KVDBGetRangeCallback func(items []kvdbtypes.KVItem, err error)
 // KVDBGetOrPutCallback is type of KVDB GetOrPut callback
 type KVDBGetOrPutCallback func(oldVal string, err error)
 
+// KVDBGetRangePageCallback is type of KVDB GetRangePage callback, nextCursor is "" if there are no more items
+type KVDBGetRangePageCallback func(items []kvdbtypes.KVItem, nextCursor string, err error)
+
+// KVDBRangeOptions is the options of KVDB GetRangePage
+type KVDBRangeOptions struct {
+	Limit   int    // max number of items in one page, 0 means no limit
+	Reverse bool   // iterate items in descending order of keys
+	Cursor  string // continuation token returned by the previous page, "" for the first page
+}
+
+// KVDBDeleteCallback is type of KVDB Delete callback
+type KVDBDeleteCallback func(err error)
+
+// KVDBCompareAndSwapCallback is type of KVDB CompareAndSwap callback
+type KVDBCompareAndSwapCallback func(swapped bool, err error)
+
+// KVDBIncrCallback is type of KVDB Incr callback
+type KVDBIncrCallback func(val int64, err error)
+
 // Initialize the KVDB
 //
 // Called by game server engine
@@ -46,6 +66,7 @@ func Initialize() {
 	}
 
 	gwlog.Infof("KVDB initializing, config:\n%s", config.DumpPretty(kvdbCfg))
+	relayPrefixes = kvdbCfg.RelayPrefixes.ToList()
 	assureKVDBEngineReady()
 }
 
@@ -69,9 +90,15 @@ func assureKVDBEngineReady() (err error) {
 		kvdbEngine, err = kvdbredis.OpenRedisKVDB(kvdbCfg.Url, dbindex)
 	} else if kvdbCfg.Type == "redis_cluster" {
 		kvdbEngine, err = kvdbrediscluster.OpenRedisKVDB(kvdbCfg.StartNodes.ToList())
+	} else if kvdbCfg.Type == "embedded" {
+		kvdbEngine, err = kvdbembedded.OpenEmbeddedKVDB(kvdbCfg.Path)
 	} else {
 		gwlog.Fatalf("KVDB type %s is not implemented", kvdbCfg.Type)
 	}
+
+	if err == nil {
+		subscribeEngineChanges(kvdbEngine)
+	}
 	return
 }
 
@@ -119,9 +146,11 @@ func kvdbRoutine(r func() (res interface{}, err error)) func() (res interface{},
 
 // Put puts key-value item to KVDB, returns in callback
 func Put(key string, val string, callback KVDBPutCallback) {
-	var ac async.AsyncCallback
-	if callback != nil {
-		ac = func(res interface{}, err error) {
+	ac := func(res interface{}, err error) {
+		if err == nil {
+			notifyWritten(key, val)
+		}
+		if callback != nil {
 			callback(err)
 		}
 	}
@@ -134,14 +163,17 @@ func Put(key string, val string, callback KVDBPutCallback) {
 
 // GetOrPut gets value of key from KVDB, if val not exists or is "", put key-value to KVDB.
 func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
-	var ac async.AsyncCallback
-	if callback != nil {
-		ac = func(res interface{}, err error) {
-			if err == nil {
-				callback(res.(string), err)
-			} else {
-				callback("", err)
-			}
+	ac := func(res interface{}, err error) {
+		if err == nil && res.(string) == "" {
+			notifyWritten(key, val)
+		}
+		if callback == nil {
+			return
+		}
+		if err == nil {
+			callback(res.(string), err)
+		} else {
+			callback("", err)
 		}
 	}
 
@@ -157,7 +189,87 @@ func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
 	}), ac)
 }
 
-// GetRange retrives key-value items of specified key range, returns in callback
+// PutWithTTL puts key-value item which expires after ttl to KVDB, returns in callback
+func PutWithTTL(key string, val string, ttl time.Duration, callback KVDBPutCallback) {
+	ac := func(res interface{}, err error) {
+		if err == nil {
+			notifyWritten(key, val)
+		}
+		if callback != nil {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.PutWithTTL(key, val, ttl)
+		return
+	}), ac)
+}
+
+// Delete deletes key from KVDB, returns in callback
+func Delete(key string, callback KVDBDeleteCallback) {
+	ac := func(res interface{}, err error) {
+		if err == nil {
+			notifyWritten(key, "")
+		}
+		if callback != nil {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.Delete(key)
+		return
+	}), ac)
+}
+
+// CompareAndSwap atomically sets key to newVal if the current value is oldVal, returns if swapped in callback.
+// oldVal "" matches keys that do not exist.
+func CompareAndSwap(key string, oldVal string, newVal string, callback KVDBCompareAndSwapCallback) {
+	ac := func(res interface{}, err error) {
+		if err == nil && res.(bool) {
+			notifyWritten(key, newVal)
+		}
+		if callback == nil {
+			return
+		}
+		if err == nil {
+			callback(res.(bool), nil)
+		} else {
+			callback(false, err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		res, err = kvdbEngine.CompareAndSwap(key, oldVal, newVal)
+		return
+	}), ac)
+}
+
+// Incr atomically adds delta to the integer value of key, returns the new value in callback.
+// Keys that do not exist are treated as 0.
+func Incr(key string, delta int64, callback KVDBIncrCallback) {
+	ac := func(res interface{}, err error) {
+		if err == nil {
+			notifyWritten(key, strconv.FormatInt(res.(int64), 10))
+		}
+		if callback == nil {
+			return
+		}
+		if err == nil {
+			callback(res.(int64), nil)
+		} else {
+			callback(0, err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		res, err = kvdbEngine.Incr(key, delta)
+		return
+	}), ac)
+}
+
+// GetRange retrives key-value items of specified key range, returns in callback. endKey "" means no upper bound.
 func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
@@ -193,6 +305,92 @@ func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
 	}), ac)
 }
 
+// GetRangePage retrieves one page of key-value items of specified key range, returns in callback with the cursor of next page
+//
+// Only Limit items (and one more to know if there is next page) are read from KVDB, so large ranges can be iterated
+// page by page. endKey "" means no upper bound.
+func GetRangePage(beginKey string, endKey string, opts KVDBRangeOptions, callback KVDBGetRangePageCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			if err == nil {
+				page := res.(rangePage)
+				callback(page.items, page.nextCursor, nil)
+			} else {
+				callback(nil, "", err)
+			}
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		items, nextCursor, err := readRangePage(kvdbEngine, beginKey, endKey, opts)
+		return rangePage{items, nextCursor}, err
+	}), ac)
+}
+
+type rangePage struct {
+	items      []kvdbtypes.KVItem
+	nextCursor string
+}
+
+// readRangePage reads one page of items from KVDB engine
+//
+// The cursor is the last key of the previous page, which narrows the range of the next page.
+func readRangePage(engine kvdbtypes.KVDBEngine, beginKey string, endKey string, opts KVDBRangeOptions) (items []kvdbtypes.KVItem, nextCursor string, err error) {
+	if opts.Cursor != "" && !opts.Reverse && NextLargerKey(opts.Cursor) > beginKey {
+		beginKey = NextLargerKey(opts.Cursor)
+	} else if opts.Cursor != "" && opts.Reverse && (endKey == "" || opts.Cursor < endKey) {
+		endKey = opts.Cursor
+	}
+
+	limit := opts.Limit
+	if limit > 0 {
+		limit++ // read one more item to know if there is next page
+	}
+	it, err := engine.FindRange(beginKey, endKey, opts.Reverse, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if closer, ok := it.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	for {
+		item, err := it.Next()
+		if err == io.EOF {
+			return items, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		if opts.Limit > 0 && len(items) == opts.Limit {
+			// there are more items after this page
+			return items, items[len(items)-1].Key, nil
+		}
+		items = append(items, item)
+	}
+}
+
+// PrefixRange returns the key range of keys with prefix, which can be used by GetRange and GetRangePage
+func PrefixRange(prefix string) (beginKey string, endKey string) {
+	return prefix, PrefixEndKey(prefix)
+}
+
+// PrefixEndKey finds the smallest key that is larger than any key with the prefix
+//
+// It returns "", which means no upper bound of key range, if there is no such key (the prefix is empty or all 0xff).
+func PrefixEndKey(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
 // NextLargerKey finds the next key that is larger than the specified key,
 // but smaller than any other keys that is larger than the specified key
 func NextLargerKey(key string) string {
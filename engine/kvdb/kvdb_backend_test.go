@@ -7,10 +7,15 @@ import (
 
 	"fmt"
 	"io"
+	"path/filepath"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbembedded"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common/storagetest"
 )
 
 func TestMongoBackendSet(t *testing.T) {
@@ -21,6 +26,10 @@ func TestRedisBackendSet(t *testing.T) {
 	testKVDBBackendSet(t, openTestRedisKVDB(t))
 }
 
+func TestEmbeddedBackendSet(t *testing.T) {
+	testKVDBBackendSet(t, openTestEmbeddedKVDB(t))
+}
+
 func testKVDBBackendSet(t *testing.T, kvdb KVDBEngine) {
 	val, err := kvdb.Get("__key_not_exists__")
 	if err != nil || val != "" {
@@ -47,10 +56,225 @@ func testKVDBBackendSet(t *testing.T, kvdb KVDBEngine) {
 
 }
 
+func TestMongoBackendOps(t *testing.T) {
+	testKVDBBackendOps(t, openTestMongoKVDB(t))
+}
+
+func TestRedisBackendOps(t *testing.T) {
+	testKVDBBackendOps(t, openTestRedisKVDB(t))
+}
+
+func TestEmbeddedBackendOps(t *testing.T) {
+	testKVDBBackendOps(t, openTestEmbeddedKVDB(t))
+}
+
+func testKVDBBackendOps(t *testing.T, kvdb KVDBEngine) {
+	key := "__test_ops_" + strconv.Itoa(rand.Intn(10000))
+	if err := kvdb.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// CompareAndSwap
+	if swapped, err := kvdb.CompareAndSwap(key, "", "a"); err != nil || !swapped {
+		t.Fatalf("swap missing key: swapped=%v, err=%v", swapped, err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "", "b"); err != nil || swapped {
+		t.Fatalf("swap existing key with empty value: swapped=%v, err=%v", swapped, err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "b", "c"); err != nil || swapped {
+		t.Fatalf("swap with wrong value: swapped=%v, err=%v", swapped, err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "a", "c"); err != nil || !swapped {
+		t.Fatalf("swap with right value: swapped=%v, err=%v", swapped, err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "c" {
+		t.Fatalf("get after swap: val=%q, err=%v", val, err)
+	}
+
+	// Delete
+	if err := kvdb.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("get after delete: val=%q, err=%v", val, err)
+	}
+
+	// Incr
+	for i, expect := range []int64{3, 5, 1} {
+		delta := []int64{3, 2, -4}[i]
+		if val, err := kvdb.Incr(key, delta); err != nil || val != expect {
+			t.Fatalf("incr %d: val=%d, err=%v, expect %d", delta, val, err, expect)
+		}
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "1" {
+		t.Fatalf("get after incr: val=%q, err=%v", val, err)
+	}
+
+	// PutWithTTL
+	if err := kvdb.PutWithTTL(key, "ttl", 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "ttl" {
+		t.Fatalf("get before expired: val=%q, err=%v", val, err)
+	}
+	time.Sleep(time.Second)
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("get after expired: val=%q, err=%v", val, err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "", "new"); err != nil || !swapped {
+		t.Fatalf("swap expired key: swapped=%v, err=%v", swapped, err)
+	}
+
+	// Put clears TTL
+	if err := kvdb.PutWithTTL(key, "ttl", 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvdb.Put(key, "forever"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if val, err := kvdb.Get(key); err != nil || val != "forever" {
+		t.Fatalf("get after put: val=%q, err=%v", val, err)
+	}
+	kvdb.Delete(key)
+}
+
 func TestMongoBackendFind(t *testing.T) {
 	testBackendFind(t, openTestMongoKVDB(t))
 }
 
+func TestEmbeddedBackendFind(t *testing.T) {
+	testBackendFind(t, openTestEmbeddedKVDB(t))
+}
+
+func TestMongoBackendRangePage(t *testing.T) {
+	testBackendRangePage(t, openTestMongoKVDB(t))
+}
+
+func TestEmbeddedBackendRangePage(t *testing.T) {
+	testBackendRangePage(t, openTestEmbeddedKVDB(t))
+}
+
+func testBackendRangePage(t *testing.T, kvdb KVDBEngine) {
+	prefix := fmt.Sprintf("__test_page_%d:", rand.Intn(10000))
+	var keys []string
+	for i := 0; i < 250; i++ {
+		key := fmt.Sprintf("%s%03d", prefix, i)
+		if err := kvdb.Put(key, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := kvdb.Put(PrefixEndKey(prefix), "out of range"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		beginKey, endKey := PrefixRange(prefix)
+		opts := KVDBRangeOptions{Limit: 30, Reverse: reverse}
+		var visited []string
+		for pages := 1; ; pages++ {
+			items, nextCursor, err := readRangePage(kvdb, beginKey, endKey, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nextCursor != "" && len(items) != opts.Limit {
+				t.Fatalf("page %d has %d items, but has next page", pages, len(items))
+			}
+			for _, item := range items {
+				visited = append(visited, item.Key)
+			}
+			if nextCursor == "" {
+				break
+			}
+			opts.Cursor = nextCursor
+		}
+
+		if len(visited) != len(keys) {
+			t.Fatalf("reverse=%v: should visit %d keys, but visited %d", reverse, len(keys), len(visited))
+		}
+		for i, key := range visited {
+			expected := keys[i]
+			if reverse {
+				expected = keys[len(keys)-1-i]
+			}
+			if key != expected {
+				t.Fatalf("reverse=%v: key %d should be %s, but is %s", reverse, i, expected, key)
+			}
+		}
+	}
+
+	// the backend reads no more than limit items
+	it, err := kvdb.FindRange(prefix, "", true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		item, err := it.Next()
+		if err == io.EOF {
+			if i != 10 {
+				t.Fatalf("should read 10 items, but read %d", i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && item.Key < PrefixEndKey(prefix) {
+			t.Fatalf("range without upper bound should include keys after the prefix, but starts from %s", item.Key)
+		}
+	}
+}
+
+func TestRedisBackendBatch(t *testing.T) {
+	testBackendBatch(t, openTestRedisKVDB(t))
+}
+
+func TestEmbeddedBackendBatch(t *testing.T) {
+	testBackendBatch(t, openTestEmbeddedKVDB(t))
+}
+
+func TestMongoBackendBatch(t *testing.T) {
+	kvdb := openTestMongoKVDB(t)
+	if _, err := writeBatch(kvdb, []BatchOp{{Type: BatchPut, Key: "__test_batch", Val: "a"}}); errors.Cause(err) != ErrBatchNotAtomic {
+		t.Fatalf("batch on mongodb should fail with ErrBatchNotAtomic, but err=%v", err)
+	}
+}
+
+func testBackendBatch(t *testing.T, kvdb KVDBEngine) {
+	prefix := fmt.Sprintf("__test_batch_%d:", rand.Intn(10000))
+	name, index, counter := prefix+"name", prefix+"index", prefix+"counter"
+	if err := kvdb.Put(counter, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvdb.Put(index, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatch().Check(name, "").Put(name, "account1").Delete(index).CompareAndSwap(counter, "1", "2")
+	if committed, err := writeBatch(kvdb, batch.ops); err != nil || !committed {
+		t.Fatalf("commit batch: committed=%v, err=%v", committed, err)
+	}
+	for key, expected := range map[string]string{name: "account1", index: "", counter: "2"} {
+		if val, err := kvdb.Get(key); err != nil || val != expected {
+			t.Fatalf("get %s after commit: val=%q, err=%v, expect %q", key, val, err, expected)
+		}
+	}
+
+	// name is reserved, so nothing should be written
+	batch = NewBatch().Put(index, "new").Check(name, "").Put(name, "account2").Put(counter, "3")
+	if committed, err := writeBatch(kvdb, batch.ops); err != nil || committed {
+		t.Fatalf("commit batch with failed condition: committed=%v, err=%v", committed, err)
+	}
+	for key, expected := range map[string]string{name: "account1", index: "", counter: "2"} {
+		if val, err := kvdb.Get(key); err != nil || val != expected {
+			t.Fatalf("get %s after failed commit: val=%q, err=%v, expect %q", key, val, err, expected)
+		}
+	}
+
+	kvdb.Delete(name)
+	kvdb.Delete(counter)
+}
+
 //func TestRedisBackendFind(t *testing.T) {
 //	testBackendFind(t, openTestRedisKVDB(t))
 //}
@@ -123,6 +347,10 @@ func BenchmarkRedisBackendGetSet(b *testing.B) {
 	benchmarkBackendGetSet(b, openTestRedisKVDB(b))
 }
 
+func BenchmarkEmbeddedBackendGetSet(b *testing.B) {
+	benchmarkBackendGetSet(b, openTestEmbeddedKVDB(b))
+}
+
 func benchmarkBackendGetSet(b *testing.B, kvdb KVDBEngine) {
 	key := "testkey"
 
@@ -148,6 +376,10 @@ func BenchmarkRedisBackendFind(b *testing.B) {
 	benchmarkBackendFind(b, openTestRedisKVDB(b))
 }
 
+func BenchmarkEmbeddedBackendFind(b *testing.B) {
+	benchmarkBackendFind(b, openTestEmbeddedKVDB(b))
+}
+
 func benchmarkBackendFind(b *testing.B, kvdb KVDBEngine) {
 	var keys []string
 	for i := 1; i <= 10; i++ {
@@ -180,11 +412,8 @@ func benchmarkBackendFind(b *testing.B, kvdb KVDBEngine) {
 	}
 }
 
-type _Fataler interface {
-	Fatal(args ...interface{})
-}
-
-func openTestMongoKVDB(f _Fataler) KVDBEngine {
+func openTestMongoKVDB(f testing.TB) KVDBEngine {
+	storagetest.RequireServer(f, "127.0.0.1:27017")
 	kvdb, err := kvdbmongo.OpenMongoKVDB("mongodb://127.0.0.1:27017/goworld", "goworld", "__kv__")
 	if err != nil {
 		f.Fatal(err)
@@ -192,10 +421,20 @@ func openTestMongoKVDB(f _Fataler) KVDBEngine {
 	return kvdb
 }
 
-func openTestRedisKVDB(f _Fataler) KVDBEngine {
+func openTestRedisKVDB(f testing.TB) KVDBEngine {
+	storagetest.RequireServer(f, "127.0.0.1:6379")
 	kvdb, err := kvdbredis.OpenRedisKVDB("redis://127.0.0.1:6379", 0)
 	if err != nil {
 		f.Fatal(err)
 	}
 	return kvdb
 }
+
+func openTestEmbeddedKVDB(f testing.TB) KVDBEngine {
+	kvdb, err := kvdbembedded.OpenEmbeddedKVDB(filepath.Join(f.TempDir(), "kvdb.db"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(kvdb.Close)
+	return kvdb
+}
@@ -0,0 +1,83 @@
+package kvdb
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// KVDBBatchCallback is type of KVDB Batch Commit callback, committed is false if any condition of the batch fails
+type KVDBBatchCallback func(committed bool, err error)
+
+// Batch groups KVDB puts, deletes and conditions, which are executed atomically by Commit
+//
+// All conditions are checked against values before the batch, and writes are applied in order only if all conditions hold.
+// Batches are supported by embedded and redis KVDB. On redis cluster, all keys of a batch must have the same hash tag
+// (e.g. {account:1}name and {account:1}index). Commit fails with kvdbtypes.ErrBatchNotAtomic if atomicity can not be guaranteed.
+type Batch struct {
+	ops []kvdbtypes.BatchOp
+}
+
+// NewBatch creates an empty KVDB batch
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put adds put of key-value to the batch
+func (b *Batch) Put(key string, val string) *Batch {
+	b.ops = append(b.ops, kvdbtypes.BatchOp{Type: kvdbtypes.BatchPut, Key: key, Val: val})
+	return b
+}
+
+// Delete adds delete of key to the batch
+func (b *Batch) Delete(key string) *Batch {
+	b.ops = append(b.ops, kvdbtypes.BatchOp{Type: kvdbtypes.BatchDelete, Key: key})
+	return b
+}
+
+// Check adds the condition that key has value val to the batch, val "" matches keys that do not exist
+func (b *Batch) Check(key string, val string) *Batch {
+	b.ops = append(b.ops, kvdbtypes.BatchOp{Type: kvdbtypes.BatchCheck, Key: key, Val: val})
+	return b
+}
+
+// CompareAndSwap adds the condition that key has value oldVal and put of key-newVal to the batch
+func (b *Batch) CompareAndSwap(key string, oldVal string, newVal string) *Batch {
+	return b.Check(key, oldVal).Put(key, newVal)
+}
+
+// Commit executes the batch atomically, returns if committed in callback
+//
+// The batch should not be modified after Commit.
+func (b *Batch) Commit(callback KVDBBatchCallback) {
+	ops := b.ops
+	ac := func(res interface{}, err error) {
+		if err == nil && res.(bool) {
+			for _, op := range ops {
+				if op.Type != kvdbtypes.BatchCheck {
+					notifyWritten(op.Key, op.Val)
+				}
+			}
+		}
+		if callback == nil {
+			return
+		}
+		if err == nil {
+			callback(res.(bool), nil)
+		} else {
+			callback(false, err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		return writeBatch(kvdbEngine, ops)
+	}), ac)
+}
+
+func writeBatch(engine kvdbtypes.KVDBEngine, ops []kvdbtypes.BatchOp) (committed bool, err error) {
+	batcher, ok := engine.(kvdbtypes.KVDBBatcher)
+	if !ok {
+		return false, errors.Wrapf(kvdbtypes.ErrBatchNotAtomic, "KVDB engine %T does not support batches", engine)
+	}
+	return batcher.WriteBatch(ops)
+}
@@ -0,0 +1,20 @@
+package kvdb
+
+import "testing"
+
+func TestPrefixEndKey(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"a":            "b",
+		"rank:":        "rank;",
+		"a\xff":        "b",
+		"a\xfe\xff":    "a\xff",
+		"\x00":         "\x01",
+		"log:\xff\xff": "log;",
+		"":             "",
+		"\xff\xff":     "",
+	} {
+		if end := PrefixEndKey(prefix); end != expected {
+			t.Errorf("end key of prefix %q should be %q, but is %q", prefix, expected, end)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package kvdb
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Watchers are notified of key changes in one of two ways:
+//
+// If KVDB engine implements KVDBNotifier (e.g. redis with keyspace notifications enabled), changes made by all clients
+// are notified by the engine, and new values are read from KVDB before watchers are called. If notifications stop
+// (e.g. the subscribing connection fails), changes are relayed as below until the engine is subscribed again.
+//
+// Otherwise (e.g. mongodb, embedded and redis cluster), changes of keys with relay prefixes of KVDB config
+// (relay_prefix_N) made by writes of this package are relayed to all games by dispatchers (MT_NOTIFY_KVDB_CHANGE)
+// with new values, and changes made by other clients are not notified.
+
+// KVDBWatchCallback is type of KVDB Watch callback, val is "" if the key is deleted or expired
+type KVDBWatchCallback func(key string, val string)
+
+type kvdbWatcher struct {
+	prefix   string
+	callback KVDBWatchCallback
+}
+
+const (
+	_RESUBSCRIBE_MIN_DELAY = time.Second
+	_RESUBSCRIBE_MAX_DELAY = time.Minute
+)
+
+var (
+	watchers       []*kvdbWatcher
+	engineNotifies int32    // 1 if changes are notified by KVDB engine, accessed atomically
+	relayPrefixes  []string // changes of keys with these prefixes are relayed by dispatchers
+)
+
+// Watch watches changes of keys with prefix, returns the function to stop watching
+//
+// Callback is called in the game goroutine with the changed key and the new value.
+// If KVDB engine can not notify changes (e.g. mongodb), only changes relayed by dispatchers are watched, so the prefix
+// should be covered by relay prefixes of KVDB config.
+func Watch(prefix string, callback KVDBWatchCallback) (unwatch func()) {
+	w := &kvdbWatcher{prefix: prefix, callback: callback}
+	watchers = append(watchers, w)
+	return func() {
+		for i, _w := range watchers {
+			if _w == w {
+				watchers = append(watchers[:i:i], watchers[i+1:]...) // copy, since watchers might be being notified
+				return
+			}
+		}
+	}
+}
+
+func isWatched(key string) bool {
+	for _, w := range watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func notifyWatchers(key string, val string) {
+	for _, w := range watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			gwutils.RunPanicless(func() {
+				w.callback(key, val)
+			})
+		}
+	}
+}
+
+// subscribeEngineChanges subscribes changes from the newly opened KVDB engine if available
+func subscribeEngineChanges(engine kvdbtypes.KVDBEngine) {
+	notifier, ok := engine.(kvdbtypes.KVDBNotifier)
+	if !ok {
+		atomic.StoreInt32(&engineNotifies, 0)
+		return
+	}
+
+	if err := notifier.Subscribe(onEngineChange, onEngineNotifyStop); err != nil {
+		gwlog.Warnf("KVDB change notifications are not available, only changes made by GoWorld can be watched: %s", err)
+		atomic.StoreInt32(&engineNotifies, 0)
+		return
+	}
+	atomic.StoreInt32(&engineNotifies, 1)
+}
+
+// onEngineChange is called by KVDB engine in other goroutines
+func onEngineChange(key string) {
+	post.Post(func() {
+		if !isWatched(key) {
+			return
+		}
+
+		Get(key, func(val string, err error) {
+			if err != nil {
+				gwlog.Errorf("KVDB: read changed key %s failed: %s", key, err)
+				return
+			}
+			notifyWatchers(key, val)
+		})
+	})
+}
+
+// onEngineNotifyStop is called by KVDB engine in other goroutines if change notifications stop unexpectedly
+func onEngineNotifyStop(err error) {
+	gwlog.Errorf("KVDB change notifications stopped, changes are relayed by dispatchers until subscribed again: %s", err)
+	atomic.StoreInt32(&engineNotifies, 0)
+	post.Post(func() {
+		resubscribeEngineChangesLater(_RESUBSCRIBE_MIN_DELAY)
+	})
+}
+
+// resubscribeEngineChangesLater subscribes changes from KVDB engine again after delay, and retries with doubled delay if failed
+func resubscribeEngineChangesLater(delay time.Duration) {
+	timer.AddCallback(delay, func() {
+		async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(resubscribeEngineChanges), func(res interface{}, err error) {
+			if err != nil {
+				delay = nextResubscribeDelay(delay)
+				gwlog.Warnf("KVDB: subscribe change notifications failed, retry in %s: %s", delay, err)
+				resubscribeEngineChangesLater(delay)
+			}
+		})
+	})
+}
+
+func nextResubscribeDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > _RESUBSCRIBE_MAX_DELAY {
+		delay = _RESUBSCRIBE_MAX_DELAY
+	}
+	return delay
+}
+
+// resubscribeEngineChanges is called in KVDB routine, after the KVDB engine is ready
+func resubscribeEngineChanges() (interface{}, error) {
+	if atomic.LoadInt32(&engineNotifies) == 1 {
+		return nil, nil // already subscribed when KVDB engine is reopened
+	}
+	notifier, ok := kvdbEngine.(kvdbtypes.KVDBNotifier)
+	if !ok {
+		return nil, nil
+	}
+	if err := notifier.Subscribe(onEngineChange, onEngineNotifyStop); err != nil {
+		return nil, err
+	}
+	atomic.StoreInt32(&engineNotifies, 1)
+	gwlog.Infof("KVDB change notifications are subscribed again")
+	return nil, nil
+}
+
+// notifyWritten relays the change written by this game to all games, if KVDB engine does not notify changes
+func notifyWritten(key string, val string) {
+	if atomic.LoadInt32(&engineNotifies) == 0 && isRelayed(key) {
+		dispatchercluster.SendNotifyKVDBChange(key, val)
+	}
+}
+
+func isRelayed(key string) bool {
+	for _, prefix := range relayPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleNotifyKVDBChange handles changes relayed by dispatchers
+//
+// Called by game server engine
+func HandleNotifyKVDBChange(key string, val string) {
+	notifyWatchers(key, val)
+}
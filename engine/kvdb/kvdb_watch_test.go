@@ -0,0 +1,122 @@
+package kvdb
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+func TestWatch(t *testing.T) {
+	var changes []string
+	unwatchA := Watch("watch:a:", func(key string, val string) {
+		changes = append(changes, "a "+key+"="+val)
+	})
+	unwatchAll := Watch("watch:", func(key string, val string) {
+		changes = append(changes, "all "+key+"="+val)
+	})
+
+	HandleNotifyKVDBChange("watch:a:1", "x")
+	HandleNotifyKVDBChange("watch:b:1", "y")
+	HandleNotifyKVDBChange("other", "z")
+	unwatchA()
+	HandleNotifyKVDBChange("watch:a:2", "")
+	unwatchAll()
+	HandleNotifyKVDBChange("watch:a:3", "w")
+
+	expected := []string{"a watch:a:1=x", "all watch:a:1=x", "all watch:b:1=y", "all watch:a:2="}
+	if len(changes) != len(expected) {
+		t.Fatalf("changes should be %v, but are %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("changes should be %v, but are %v", expected, changes)
+			break
+		}
+	}
+}
+
+func TestWatchUnwatchInCallback(t *testing.T) {
+	calls := 0
+	var unwatch func()
+	unwatch = Watch("unwatch:", func(key string, val string) {
+		calls++
+		unwatch()
+	})
+	Watch("unwatch:", func(key string, val string) {
+		calls++
+	})()
+
+	HandleNotifyKVDBChange("unwatch:1", "1")
+	HandleNotifyKVDBChange("unwatch:2", "2")
+	if calls != 1 {
+		t.Errorf("callback should be called once, but called %d times", calls)
+	}
+	if isWatched("unwatch:1") {
+		t.Errorf("key should not be watched after unwatch")
+	}
+}
+
+func TestRelayPrefixes(t *testing.T) {
+	relayPrefixes = []string{"notice:", "rank:"}
+	defer func() {
+		relayPrefixes = nil
+	}()
+
+	for key, relayed := range map[string]bool{"notice:1": true, "rank:": true, "counter:1": false, "notice": false} {
+		if isRelayed(key) != relayed {
+			t.Errorf("isRelayed(%q) should be %v", key, relayed)
+		}
+	}
+
+	atomic.StoreInt32(&engineNotifies, 1)
+	onEngineNotifyStop(errors.New("connection lost"))
+	if atomic.LoadInt32(&engineNotifies) != 0 {
+		t.Errorf("changes should be relayed after engine notifications stop")
+	}
+}
+
+// notifierEngine is a KVDB engine whose Subscribe fails for the first failures times
+type notifierEngine struct {
+	kvdbtypes.KVDBEngine
+	failures   int
+	subscribed int
+}
+
+func (engine *notifierEngine) Subscribe(onChange func(key string), onStop func(err error)) error {
+	if engine.failures > 0 {
+		engine.failures--
+		return errors.New("connection refused")
+	}
+	engine.subscribed++
+	return nil
+}
+
+func TestResubscribeEngineChanges(t *testing.T) {
+	engine := &notifierEngine{failures: 1}
+	kvdbEngine = engine
+	defer func() {
+		kvdbEngine = nil
+		atomic.StoreInt32(&engineNotifies, 0)
+	}()
+
+	atomic.StoreInt32(&engineNotifies, 0)
+	if _, err := resubscribeEngineChanges(); err == nil || atomic.LoadInt32(&engineNotifies) != 0 {
+		t.Fatalf("failed subscribing should be retried, err=%v", err)
+	}
+	if _, err := resubscribeEngineChanges(); err != nil || atomic.LoadInt32(&engineNotifies) != 1 {
+		t.Fatalf("changes should be notified by engine after subscribed again, err=%v", err)
+	}
+	if _, err := resubscribeEngineChanges(); err != nil || engine.subscribed != 1 {
+		t.Errorf("engine should not be subscribed twice: subscribed=%d, err=%v", engine.subscribed, err)
+	}
+
+	delay := _RESUBSCRIBE_MIN_DELAY
+	for i := 0; i < 10; i++ {
+		delay = nextResubscribeDelay(delay)
+	}
+	if delay != _RESUBSCRIBE_MAX_DELAY {
+		t.Errorf("retry delay should be limited to %s, but is %s", _RESUBSCRIBE_MAX_DELAY, delay)
+	}
+}
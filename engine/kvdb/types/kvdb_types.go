@@ -1,19 +1,77 @@
 package kvdbtypes
 
+import (
+	"errors"
+	"time"
+)
+
 // KVDBEngine defines the interface of a KVDB engine implementation
+//
+// Missing keys are read as "". Put clears the TTL of key, while CompareAndSwap and Incr keep it.
 type KVDBEngine interface {
 	Get(key string) (val string, err error)
 	Put(key string, val string) (err error)
+	// Delete deletes the key, deleting missing key is not an error
+	Delete(key string) error
+	// PutWithTTL puts key-value which expires after ttl, ttl <= 0 means never expire
+	PutWithTTL(key string, val string, ttl time.Duration) error
+	// CompareAndSwap sets key to newVal if current value is oldVal, oldVal "" matches missing key
+	CompareAndSwap(key string, oldVal string, newVal string) (swapped bool, err error)
+	// Incr adds delta to the integer value of key and returns the new value, missing key is treated as 0
+	Incr(key string, delta int64) (val int64, err error)
+	// Find iterates items with beginKey <= key < endKey in ascending order of keys, endKey "" means no upper bound
 	Find(beginKey string, endKey string) (Iterator, error)
+	// FindRange iterates at most limit items (no limit if limit <= 0) with beginKey <= key < endKey in ascending order
+	// of keys, or descending order if reverse is true. endKey "" means no upper bound.
+	FindRange(beginKey string, endKey string, reverse bool, limit int) (Iterator, error)
 	Close()
 	IsConnectionError(err error) bool
 }
 
+// KVDBNotifier is implemented by KVDB engines which can notify changes of keys made by all clients
+type KVDBNotifier interface {
+	// Subscribe starts calling onChange in other goroutines with changed keys until the engine is closed.
+	// If notifications stop before the engine is closed, onStop is called with the error.
+	// It returns error if change notifications are not available.
+	Subscribe(onChange func(key string), onStop func(err error)) error
+}
+
+// KVDBBatcher is implemented by KVDB engines which can execute batches atomically
+type KVDBBatcher interface {
+	// WriteBatch checks all conditions of ops against values before the batch, and applies all writes in order if
+	// all conditions hold, all at once. It returns committed=false without writing anything if any condition fails.
+	WriteBatch(ops []BatchOp) (committed bool, err error)
+}
+
+// ErrBatchNotAtomic is returned (maybe wrapped) when the KVDB engine can not execute the batch atomically
+var ErrBatchNotAtomic = errors.New("KVDB batch can not be executed atomically")
+
+// BatchOpType is the type of operations in KVDB batches
+type BatchOpType int
+
+const (
+	// BatchPut puts Key-Val and clears the TTL of Key
+	BatchPut BatchOpType = iota
+	// BatchDelete deletes Key
+	BatchDelete
+	// BatchCheck is the condition that Key has value Val, "" matches missing key
+	BatchCheck
+)
+
+// BatchOp is one operation of KVDB batches
+type BatchOp struct {
+	Type BatchOpType
+	Key  string
+	Val  string
+}
+
 // Iterator is the interface for iterators for KVDB
 //
 // Next should returns the next item with error=nil whenever has next item
 // otherwise returns KVItem{}, io.EOF
 // When failed, returns KVItem{}, error
+//
+// Iterators holding resources also implement io.Closer, which should be called if the iterator is not read to the end.
 type Iterator interface {
 	Next() (KVItem, error)
 }
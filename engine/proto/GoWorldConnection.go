@@ -66,11 +66,12 @@ func (gwc *GoWorldConnection) SendNotifyDestroyEntity(id common.EntityID) {
 }
 
 // SendNotifyClientConnected sends MT_NOTIFY_CLIENT_CONNECTED message
-func (gwc *GoWorldConnection) SendNotifyClientConnected(id common.ClientID, bootEid common.EntityID) {
+func (gwc *GoWorldConnection) SendNotifyClientConnected(id common.ClientID, bootEid common.EntityID, identity string) {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_NOTIFY_CLIENT_CONNECTED)
 	packet.AppendClientID(id)
 	packet.AppendEntityID(bootEid)
+	packet.AppendVarStr(identity)
 	gwc.SendPacketRelease(packet)
 }
 
@@ -114,6 +115,15 @@ func (gwc *GoWorldConnection) SendKvregRegister(srvid string, info string, force
 	gwc.SendPacketRelease(packet)
 }
 
+// SendNotifyKVDBChange sends MT_NOTIFY_KVDB_CHANGE message
+func (gwc *GoWorldConnection) SendNotifyKVDBChange(key string, val string) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_KVDB_CHANGE)
+	packet.AppendVarStr(key)
+	packet.AppendVarStr(val)
+	gwc.SendPacketRelease(packet)
+}
+
 // SendCallEntityMethod sends MT_CALL_ENTITY_METHOD message
 func (gwc *GoWorldConnection) SendCallEntityMethod(id common.EntityID, method string, args []interface{}) {
 	packet := gwc.packetConn.NewPacket()
@@ -124,6 +134,29 @@ func (gwc *GoWorldConnection) SendCallEntityMethod(id common.EntityID, method st
 	gwc.SendPacketRelease(packet)
 }
 
+// SendCallEntityMethodWithReply sends MT_CALL_ENTITY_METHOD_WITH_REPLY message
+func (gwc *GoWorldConnection) SendCallEntityMethodWithReply(id common.EntityID, method string, callerGameID uint16, callID uint32, args []interface{}) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_REPLY)
+	packet.AppendEntityID(id)
+	packet.AppendUint16(callerGameID)
+	packet.AppendUint32(callID)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	gwc.SendPacketRelease(packet)
+}
+
+// SendCallEntityMethodReply sends MT_CALL_ENTITY_METHOD_REPLY message
+func (gwc *GoWorldConnection) SendCallEntityMethodReply(callerGameID uint16, callID uint32, errmsg string, rets []interface{}) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_REPLY)
+	packet.AppendUint16(callerGameID)
+	packet.AppendUint32(callID)
+	packet.AppendVarStr(errmsg)
+	packet.AppendArgs(rets)
+	gwc.SendPacketRelease(packet)
+}
+
 // SendCallEntityMethodFromClient sends MT_CALL_ENTITY_METHOD_FROM_CLIENT message
 func (gwc *GoWorldConnection) SendCallEntityMethodFromClient(id common.EntityID, method string, args []interface{}) {
 	packet := gwc.packetConn.NewPacket()
@@ -170,86 +203,31 @@ func (gwc *GoWorldConnection) SetHeartbeatFromClient() {
 	gwc.SendPacketRelease(packet)
 }
 
-// SendDestroyEntityOnClient sends MT_DESTROY_ENTITY_ON_CLIENT message
-func (gwc *GoWorldConnection) SendDestroyEntityOnClient(gateid uint16, clientid common.ClientID, typeName string, entityid common.EntityID) {
+// SendAuthFromClient sends MT_AUTH_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendAuthFromClient(ticket string) {
 	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_DESTROY_ENTITY_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendVarStr(typeName)
-	packet.AppendEntityID(entityid)
+	packet.AppendUint16(MT_AUTH_FROM_CLIENT)
+	packet.AppendVarStr(ticket)
 	gwc.SendPacketRelease(packet)
 }
 
-// SendNotifyMapAttrChangeOnClient sends MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyMapAttrChangeOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}, key string, val interface{}) {
+// SendResumeSessionFromClient sends MT_RESUME_SESSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendResumeSessionFromClient(token string, recvSeq uint32) {
 	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	packet.AppendVarStr(key)
-	packet.AppendData(val)
+	packet.AppendUint16(MT_RESUME_SESSION_FROM_CLIENT)
+	packet.AppendVarStr(token)
+	packet.AppendUint32(recvSeq)
 	gwc.SendPacketRelease(packet)
 }
 
-// SendNotifyMapAttrDelOnClient sends MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyMapAttrDelOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}, key string) {
-	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	packet.AppendVarStr(key)
-	gwc.SendPacketRelease(packet)
-}
-
-// SendNotifyMapAttrClearOnClient sends MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyMapAttrClearOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}) {
-	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	gwc.SendPacketRelease(packet)
-}
-
-// SendNotifyListAttrChangeOnClient sends MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyListAttrChangeOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}, index uint32, val interface{}) {
-	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	packet.AppendUint32(index)
-	packet.AppendData(val)
-	gwc.SendPacketRelease(packet)
-}
-
-// SendNotifyListAttrPopOnClient sends MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyListAttrPopOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}) {
-	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT)
-	packet.AppendUint16(gateid)
-	packet.AppendClientID(clientid)
-	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	gwc.SendPacketRelease(packet)
-}
-
-// SendNotifyListAttrAppendOnClient sends MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT message
-func (gwc *GoWorldConnection) SendNotifyListAttrAppendOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, path []interface{}, val interface{}) {
+// SendDestroyEntityOnClient sends MT_DESTROY_ENTITY_ON_CLIENT message
+func (gwc *GoWorldConnection) SendDestroyEntityOnClient(gateid uint16, clientid common.ClientID, typeName string, entityid common.EntityID) {
 	packet := gwc.packetConn.NewPacket()
-	packet.AppendUint16(MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT)
+	packet.AppendUint16(MT_DESTROY_ENTITY_ON_CLIENT)
 	packet.AppendUint16(gateid)
 	packet.AppendClientID(clientid)
+	packet.AppendVarStr(typeName)
 	packet.AppendEntityID(entityid)
-	packet.AppendData(path)
-	packet.AppendData(val)
 	gwc.SendPacketRelease(packet)
 }
 
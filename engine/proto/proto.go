@@ -62,6 +62,12 @@ const (
 	MT_NOTIFY_DEPLOYMENT_READY
 	// MT_GAME_LBC_INFO contains game load balacing info
 	MT_GAME_LBC_INFO
+	// MT_CALL_ENTITY_METHOD_WITH_REPLY is a message type for calling entity methods which reply results to the caller
+	MT_CALL_ENTITY_METHOD_WITH_REPLY
+	// MT_CALL_ENTITY_METHOD_REPLY is a message type for replying results of MT_CALL_ENTITY_METHOD_WITH_REPLY to the caller game
+	MT_CALL_ENTITY_METHOD_REPLY
+	// MT_NOTIFY_KVDB_CHANGE is a message type for relaying KVDB changes to all games
+	MT_NOTIFY_KVDB_CHANGE
 )
 
 // Alias message types
@@ -81,14 +87,24 @@ const (
 	// MT_DESTROY_ENTITY_ON_CLIENT message type
 	MT_DESTROY_ENTITY_ON_CLIENT
 	// MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT
 	// MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT
 	// MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT
 	// MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT
 	// MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT
 	// MT_CALL_ENTITY_METHOD_ON_CLIENT message type
 	MT_CALL_ENTITY_METHOD_ON_CLIENT
@@ -97,7 +113,15 @@ const (
 	// MT_CLEAR_CLIENTPROXY_FILTER_PROPS message type
 	MT_CLEAR_CLIENTPROXY_FILTER_PROPS
 	// MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT message type
+	//
+	// Deprecated: not sent by server any more, use MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT
+	// MT_NOTIFY_ATTR_CHANGES_ON_CLIENT message type: batched attribute changes of entities
+	//
+	// This message replaces all MT_NOTIFY_*_ATTR_*_ON_CLIENT messages: attribute changes are only sent to clients
+	// in this message, so client SDKs which only handle the old messages can not receive attribute changes.
+	// The old message types are kept so that the values of other message types are not changed.
+	MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
 	// MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP message type
 	MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP = 1499
 )
@@ -115,8 +139,33 @@ const (
 const (
 	// MT_HEARTBEAT_FROM_CLIENT is sent by client to notify the gate server that the client is alive
 	MT_HEARTBEAT_FROM_CLIENT = 2001 + iota
+	// MT_AUTH_FROM_CLIENT is sent by client to authenticate with the auth ticket
+	MT_AUTH_FROM_CLIENT
+	// MT_AUTH_RESULT_ON_CLIENT is sent by gate to client to notify the result of authentication
+	MT_AUTH_RESULT_ON_CLIENT
+	// MT_RESUME_SESSION_FROM_CLIENT is sent by client to start a new session (with empty token) or resume a session
+	MT_RESUME_SESSION_FROM_CLIENT
+	// MT_SET_SESSION_TOKEN_ON_CLIENT is sent by gate to client to notify the token for resuming the session
+	MT_SET_SESSION_TOKEN_ON_CLIENT
+	// MT_RESUME_SESSION_RESULT_ON_CLIENT is sent by gate to client to notify the result of resuming session
+	MT_RESUME_SESSION_RESULT_ON_CLIENT
 )
 
+// IsSessionMsgType returns if messages of the type sent by gate to client are numbered and replayed in sessions
+//
+// When sessions are enabled, the gate numbers session messages sent to the client from 1, starting after
+// MT_SET_SESSION_TOKEN_ON_CLIENT. The client counts session messages it has received in the same way, and sends the count
+// as recvSeq in MT_RESUME_SESSION_FROM_CLIENT after reconnecting, so that the gate replays session messages after recvSeq.
+//
+// All messages sent by gate to client are session messages, except:
+//
+//	messages between gate and client (MT_AUTH_RESULT_ON_CLIENT, MT_SET_SESSION_TOKEN_ON_CLIENT, MT_RESUME_SESSION_RESULT_ON_CLIENT),
+//	which belong to the connection rather than the session
+//	MT_SYNC_POSITION_YAW_ON_CLIENTS, which is not replayed since positions and yaws are synced again when entities move
+func IsSessionMsgType(msgtype MsgType) bool {
+	return msgtype <= MT_GATE_SERVICE_MSG_TYPE_STOP && msgtype != MT_SYNC_POSITION_YAW_ON_CLIENTS
+}
+
 const (
 	// SYNC_INFO_SIZE_PER_ENTITY is the size of sync info per entity
 	SYNC_INFO_SIZE_PER_ENTITY = 16
@@ -134,6 +183,35 @@ const (
 	FILTER_CLIENTS_OP_LTE
 )
 
+// AttrChangeOp is the operation of attribute change in MT_NOTIFY_ATTR_CHANGES_ON_CLIENT
+//
+// Each attribute change is packed as a list: [op, path, args...], in which args are:
+//
+//	ATTR_CHANGE_MAP_SET: key, val
+//	ATTR_CHANGE_MAP_DEL: key
+//	ATTR_CHANGE_MAP_CLEAR: (none)
+//	ATTR_CHANGE_LIST_SET: index, val
+//	ATTR_CHANGE_LIST_APPEND: val
+//	ATTR_CHANGE_LIST_POP: (none)
+//	ATTR_CHANGE_LIST_INSERT: index, val
+//	ATTR_CHANGE_LIST_REMOVE: index
+//	ATTR_CHANGE_LIST_SWAP: index1, index2
+//	ATTR_CHANGE_LIST_TRUNCATE: size
+type AttrChangeOp byte
+
+const (
+	ATTR_CHANGE_MAP_SET AttrChangeOp = iota
+	ATTR_CHANGE_MAP_DEL
+	ATTR_CHANGE_MAP_CLEAR
+	ATTR_CHANGE_LIST_SET
+	ATTR_CHANGE_LIST_APPEND
+	ATTR_CHANGE_LIST_POP
+	ATTR_CHANGE_LIST_INSERT
+	ATTR_CHANGE_LIST_REMOVE
+	ATTR_CHANGE_LIST_SWAP
+	ATTR_CHANGE_LIST_TRUNCATE
+)
+
 // EntitySyncInfo defines fields of entity sync info
 type EntitySyncInfo struct {
 	X, Y, Z float32
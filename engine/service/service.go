@@ -323,6 +323,26 @@ func CallServiceShardKey(serviceName string, shardKey string, method string, arg
 	entity.Call(eid, method, args)
 }
 
+func CallServiceShardKeyWithReply(serviceName string, shardKey string, method string, timeout time.Duration, callback entity.CallReplyCallback, args []interface{}) {
+	serviceEids := serviceMap[serviceName]
+
+	if len(serviceEids) <= 0 {
+		gwlog.Errorf("CallServiceShardKeyWithReply %s.%s: no service entities", serviceName, method)
+		callback(nil, errors.Errorf("service %s has no service entities", serviceName))
+		return
+	}
+
+	shardIndex := shardByKey(shardKey, len(serviceEids))
+	eid := serviceEids[shardIndex]
+	if eid.IsNil() {
+		gwlog.Errorf("CallServiceShardKeyWithReply %s.%s: service entity %d (shard key %+v) is nil!", serviceName, method, shardIndex, shardKey)
+		callback(nil, errors.Errorf("service entity %s#%d is nil", serviceName, shardIndex))
+		return
+	}
+
+	entity.CallWithReply(eid, method, timeout, callback, args)
+}
+
 func shardByKey(key string, shardCount int) int {
 	return int(common.HashString(key)) % shardCount
 }
@@ -0,0 +1,206 @@
+package entitystorageembedded
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Entities of each type are stored in bucket "entity:<TypeName>", keyed by entity ID.
+// Each value is the 8 bytes big-endian owner epoch followed by msgpack encoded entity data.
+// Indexes of each type are stored in bucket "index:<TypeName>", see embedded_index.go.
+const (
+	entityBucketPrefix = "entity:"
+	indexBucketPrefix  = "index:"
+	epochSize          = 8
+)
+
+type embeddedEntityStorage struct {
+	db *bolt.DB
+}
+
+// OpenEmbedded opens the embedded database file at path as entity storage
+//
+// The database file is locked by the process which opens it, so it can not be shared by multiple games.
+func OpenEmbedded(path string) (storagecommon.EntityStorage, error) {
+	gwlog.Debugf("Opening embedded storage %s ...", path)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open embedded storage %s failed", path)
+	}
+
+	return &embeddedEntityStorage{
+		db: db,
+	}, nil
+}
+
+func entityBucketName(typeName string) []byte {
+	return []byte(entityBucketPrefix + typeName)
+}
+
+func encodeEntity(epoch int64, data interface{}) ([]byte, error) {
+	b := make([]byte, epochSize, 256)
+	binary.BigEndian.PutUint64(b, uint64(epoch))
+	return netutil.MSG_PACKER.PackMsg(data, b)
+}
+
+func decodeEntity(b []byte) (epoch int64, data map[string]interface{}, err error) {
+	if len(b) < epochSize {
+		return 0, nil, errors.Errorf("invalid entity data: %d bytes", len(b))
+	}
+	epoch = int64(binary.BigEndian.Uint64(b))
+	err = netutil.MSG_PACKER.UnpackMsg(b[epochSize:], &data)
+	return
+}
+
+func (es *embeddedEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	var entityIDs []common.EntityID
+	err := es.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entityBucketName(typeName))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			entityIDs = append(entityIDs, common.EntityID(k))
+			return nil
+		})
+	})
+	return entityIDs, err
+}
+
+func (es *embeddedEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.WriteBatch([]storagecommon.WriteItem{{TypeName: typeName, EntityID: entityID, Data: data}})
+}
+
+// WriteBatch writes all entities in one transaction
+func (es *embeddedEntityStorage) WriteBatch(items []storagecommon.WriteItem) error {
+	var stale []storagecommon.WriteItem
+	err := es.db.Update(func(tx *bolt.Tx) error {
+		stale = nil // the transaction function might be retried
+		for _, item := range items {
+			written, err := es.write(tx, item)
+			if err != nil {
+				return err
+			}
+			if !written {
+				stale = append(stale, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(stale) > 0 {
+		return storagecommon.StaleWriteError(stale...)
+	}
+	return nil
+}
+
+// write writes entity data in transaction, returns false if the epoch of data is less than the stored epoch
+func (es *embeddedEntityStorage) write(tx *bolt.Tx, item storagecommon.WriteItem) (bool, error) {
+	bucket, err := tx.CreateBucketIfNotExists(entityBucketName(item.TypeName))
+	if err != nil {
+		return false, err
+	}
+
+	var stored int64
+	var oldData map[string]interface{}
+	if b := bucket.Get([]byte(item.EntityID)); b != nil {
+		if stored, oldData, err = decodeEntity(b); err != nil {
+			return false, err
+		}
+	}
+
+	epoch := storagecommon.DataEpoch(item.Data)
+	if epoch == 0 {
+		epoch = stored // data without epoch does not change the stored epoch
+	} else if epoch < stored {
+		return false, nil
+	}
+
+	b, err := encodeEntity(epoch, item.Data)
+	if err != nil {
+		return false, err
+	}
+	if err = bucket.Put([]byte(item.EntityID), b); err != nil {
+		return false, err
+	}
+
+	newData, _ := item.Data.(map[string]interface{})
+	return true, writeIndex(tx, item.TypeName, item.EntityID, oldData, newData)
+}
+
+func (es *embeddedEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	var data map[string]interface{}
+	err := es.db.View(func(tx *bolt.Tx) error {
+		var b []byte
+		if bucket := tx.Bucket(entityBucketName(typeName)); bucket != nil {
+			b = bucket.Get([]byte(entityID))
+		}
+		if b == nil {
+			return errors.Errorf("%s %s not found", typeName, entityID)
+		}
+
+		var err error
+		_, data, err = decodeEntity(b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *embeddedEntityStorage) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	return storagecommon.UpdateByRewrite(es, typeName, entityID, updates)
+}
+
+func (es *embeddedEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	exists := false
+	err := es.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(entityBucketName(typeName)); bucket != nil {
+			exists = bucket.Get([]byte(entityID)) != nil
+		}
+		return nil
+	})
+	return exists, err
+}
+
+func (es *embeddedEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	return es.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entityBucketName(typeName))
+		if bucket == nil {
+			return nil
+		}
+		b := bucket.Get([]byte(entityID))
+		if b == nil {
+			return nil
+		}
+
+		_, oldData, err := decodeEntity(b)
+		if err != nil {
+			return err
+		}
+		if err = bucket.Delete([]byte(entityID)); err != nil {
+			return err
+		}
+		return writeIndex(tx, typeName, entityID, oldData, nil)
+	})
+}
+
+func (es *embeddedEntityStorage) Close() {
+	es.db.Close()
+}
+
+func (es *embeddedEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == bolt.ErrDatabaseNotOpen
+}
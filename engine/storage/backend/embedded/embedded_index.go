@@ -0,0 +1,195 @@
+package entitystorageembedded
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Each indexed attribute has a sub bucket in the index bucket of entity type, which contains one key for each entity.
+// The key is the encoded index value followed by entity ID, and the value is entity ID.
+//
+// Index values are encoded so that byte order is the same as value order: numbers are encoded as 'n' and 8 bytes of
+// order preserving float64 bits, strings are encoded as 's' and the string ending with 0 (0 in string is escaped as 0 0xff).
+// So encoded values are never prefixes of each other, and numbers are always less than strings.
+
+func indexBucketName(typeName string) []byte {
+	return []byte(indexBucketPrefix + typeName)
+}
+
+func encodeIndexValue(val interface{}) []byte {
+	switch v := val.(type) {
+	case float64:
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits // negative numbers are reversed
+		} else {
+			bits |= 1 << 63
+		}
+		b := make([]byte, 9)
+		b[0] = 'n'
+		binary.BigEndian.PutUint64(b[1:], bits)
+		return b
+	case string:
+		b := make([]byte, 0, len(v)+2)
+		b = append(b, 's')
+		for i := 0; i < len(v); i++ {
+			b = append(b, v[i])
+			if v[i] == 0 {
+				b = append(b, 0xff)
+			}
+		}
+		return append(b, 0)
+	default:
+		return nil
+	}
+}
+
+func indexKey(val interface{}, entityID common.EntityID) []byte {
+	iv, ok := storagecommon.IndexValue(val)
+	if !ok {
+		return nil
+	}
+	return append(encodeIndexValue(iv), entityID...)
+}
+
+func (es *embeddedEntityStorage) EnsureIndex(typeName string, attr string) error {
+	return es.db.Update(func(tx *bolt.Tx) error {
+		indexes, err := tx.CreateBucketIfNotExists(indexBucketName(typeName))
+		if err != nil {
+			return err
+		}
+		if indexes.Bucket([]byte(attr)) != nil {
+			return nil
+		}
+
+		// new index, index all existing entities
+		gwlog.Infof("embedded storage: building index %s.%s ...", typeName, attr)
+		index, err := indexes.CreateBucket([]byte(attr))
+		if err != nil {
+			return err
+		}
+		entities := tx.Bucket(entityBucketName(typeName))
+		if entities == nil {
+			return nil
+		}
+		return entities.ForEach(func(k, v []byte) error {
+			_, data, err := decodeEntity(v)
+			if err != nil {
+				return err
+			}
+			return insertIndex(index, typeName, attr, common.EntityID(k), data)
+		})
+	})
+}
+
+func insertIndex(index *bolt.Bucket, typeName string, attr string, entityID common.EntityID, data map[string]interface{}) error {
+	val, ok := data[attr]
+	if !ok {
+		return nil
+	}
+
+	key := indexKey(val, entityID)
+	if key == nil {
+		gwlog.Warnf("%s.%s of %s can not be indexed: %v", typeName, attr, entityID, val)
+		return nil
+	}
+	return index.Put(key, []byte(entityID))
+}
+
+// writeIndex replaces index keys of the old entity data with the new data, data is nil if entity does not exist
+func writeIndex(tx *bolt.Tx, typeName string, entityID common.EntityID, oldData, newData map[string]interface{}) error {
+	indexes := tx.Bucket(indexBucketName(typeName))
+	if indexes == nil {
+		return nil
+	}
+
+	return indexes.ForEach(func(attr, v []byte) error {
+		index := indexes.Bucket(attr)
+		if index == nil {
+			return nil
+		}
+
+		if val, ok := oldData[string(attr)]; ok {
+			if key := indexKey(val, entityID); key != nil {
+				if err := index.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return insertIndex(index, typeName, string(attr), entityID, newData)
+	})
+}
+
+func (es *embeddedEntityStorage) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	var results [][]common.EntityID
+	err := es.db.View(func(tx *bolt.Tx) error {
+		indexes := tx.Bucket(indexBucketName(typeName))
+		for _, cond := range filter {
+			var index *bolt.Bucket
+			if indexes != nil {
+				index = indexes.Bucket([]byte(cond.Attr))
+			}
+			if index == nil {
+				results = append(results, nil)
+				continue
+			}
+			results = append(results, queryIndex(index, cond))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storagecommon.IntersectEntityIDs(results), nil
+}
+
+// queryIndex scans index keys in the range of condition
+func queryIndex(index *bolt.Bucket, cond storagecommon.QueryCond) []common.EntityID {
+	val := encodeIndexValue(cond.Value)
+	if val == nil {
+		return nil
+	}
+	kind := val[:1] // numbers and strings are never compared
+
+	c := index.Cursor()
+	var k, v []byte
+	switch cond.Op {
+	case storagecommon.QueryLt, storagecommon.QueryLte:
+		k, v = c.Seek(kind)
+	default:
+		k, v = c.Seek(val)
+	}
+
+	var entityIDs []common.EntityID
+	for ; k != nil && bytes.HasPrefix(k, kind); k, v = c.Next() {
+		equal := bytes.HasPrefix(k, val)
+		less := !equal && bytes.Compare(k, val) < 0
+
+		var match bool
+		switch cond.Op {
+		case storagecommon.QueryEq:
+			match = equal
+		case storagecommon.QueryGt:
+			match = !equal
+		case storagecommon.QueryGte:
+			match = true
+		case storagecommon.QueryLt:
+			match = less
+		case storagecommon.QueryLte:
+			match = less || equal
+		}
+
+		if match {
+			entityIDs = append(entityIDs, common.EntityID(v))
+		} else if cond.Op != storagecommon.QueryGt {
+			break // keys after never match
+		}
+	}
+	return entityIDs
+}
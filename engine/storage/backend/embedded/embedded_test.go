@@ -0,0 +1,65 @@
+package entitystorageembedded
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common/storagetest"
+)
+
+func TestEmbeddedEntityStorage(t *testing.T) {
+	es, err := OpenEmbedded(filepath.Join(t.TempDir(), "goworld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	storagetest.TestEntityStorage(t, es)
+	storagetest.TestEntityStorageQuery(t, es)
+	storagetest.TestEntityStorageUpdate(t, es)
+	storagetest.TestEntityStorageWriteBatch(t, es)
+	storagetest.TestEntityStorageEpoch(t, es)
+}
+
+func TestEmbeddedEntityStorageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goworld.db")
+	es, err := OpenEmbedded(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Write("Avatar", "A", map[string]interface{}{"level": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.EnsureIndex("Avatar", "level"); err != nil {
+		t.Fatal(err)
+	}
+	es.Close()
+
+	es, err = OpenEmbedded(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	if exists, err := es.Exists("Avatar", "A"); err != nil || !exists {
+		t.Fatalf("Avatar A should exist after reopen: exists=%v, err=%v", exists, err)
+	}
+	if err := es.Write("Avatar", "B", map[string]interface{}{"level": -1.5}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		op       string
+		val      float64
+		expected int
+	}{{">", 0, 1}, {"<", 0, 1}, {"<=", 3, 2}, {"==", -1.5, 1}, {">=", 4, 0}} {
+		filter := storagecommon.QueryFilter{{Attr: "level", Op: storagecommon.QueryOp(c.op), Value: c.val}}
+		if err := filter.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		eids, err := es.Query("Avatar", filter)
+		if err != nil || len(eids) != c.expected {
+			t.Errorf("level %s %v should return %d entities, but returns %v, %v", c.op, c.val, c.expected, eids, err)
+		}
+	}
+}
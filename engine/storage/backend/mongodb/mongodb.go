@@ -5,6 +5,8 @@ import (
 	"gopkg.in/mgo.v2/bson"
 
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -44,12 +46,76 @@ func OpenMongoDB(url string, dbname string) (storagecommon.EntityStorage, error)
 
 func (es *mongoDBEntityStorge) Write(typeName string, entityID common.EntityID, data interface{}) error {
 	col := es.getCollection(typeName)
-	_, err := col.UpsertId(entityID, bson.M{
+	_, err := col.Upsert(es.epochSelector(entityID, storagecommon.DataEpoch(data)), bson.M{
 		"data": data,
 	})
+	if mgo.IsDup(err) {
+		// document exists with greater epoch, so upsert tried to insert the document with the same _id
+		return storagecommon.StaleWriteError(storagecommon.WriteItem{TypeName: typeName, EntityID: entityID, Data: data})
+	}
 	return err
 }
 
+// epochSelector selects the entity document if its epoch is not greater than epoch
+func (es *mongoDBEntityStorge) epochSelector(entityID common.EntityID, epoch int64) bson.M {
+	selector := bson.M{"_id": entityID}
+	if epoch > 0 {
+		selector["$or"] = []bson.M{
+			{"data." + storagecommon.EpochKey: bson.M{"$lte": epoch}},
+			{"data." + storagecommon.EpochKey: bson.M{"$exists": false}},
+		}
+	}
+	return selector
+}
+
+// WriteBatch upserts entities in bulk for each collection
+func (es *mongoDBEntityStorge) WriteBatch(items []storagecommon.WriteItem) error {
+	bulks := map[string]*mgo.Bulk{}
+	var typeNames []string
+	for _, item := range items {
+		bulk := bulks[item.TypeName]
+		if bulk == nil {
+			bulk = es.getCollection(item.TypeName).Bulk()
+			bulk.Unordered()
+			bulks[item.TypeName] = bulk
+			typeNames = append(typeNames, item.TypeName)
+		}
+		bulk.Upsert(es.epochSelector(item.EntityID, storagecommon.DataEpoch(item.Data)), bson.M{"data": item.Data})
+	}
+
+	var stale []storagecommon.WriteItem
+	for _, typeName := range typeNames {
+		_, err := bulks[typeName].Run()
+		if err == nil {
+			continue
+		} else if !mgo.IsDup(err) {
+			return err
+		}
+
+		// some documents exist with greater epoch
+		var typeItems []storagecommon.WriteItem
+		for _, item := range items {
+			if item.TypeName == typeName {
+				typeItems = append(typeItems, item)
+			}
+		}
+		berr, ok := err.(*mgo.BulkError)
+		if !ok {
+			stale = append(stale, typeItems...)
+			continue
+		}
+		for _, ecase := range berr.Cases() {
+			if ecase.Index >= 0 && ecase.Index < len(typeItems) {
+				stale = append(stale, typeItems[ecase.Index])
+			}
+		}
+	}
+	if len(stale) > 0 {
+		return storagecommon.StaleWriteError(stale...)
+	}
+	return nil
+}
+
 func (es *mongoDBEntityStorge) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	col := es.getCollection(typeName)
 	q := col.FindId(entityID)
@@ -61,6 +127,65 @@ func (es *mongoDBEntityStorge) Read(typeName string, entityID common.EntityID) (
 	return es.convertM2Map(doc["data"].(bson.M)), nil
 }
 
+func (es *mongoDBEntityStorge) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	set, unset := bson.M{}, bson.M{}
+	for _, u := range updates {
+		key, ok := es.updateKey(u.Path)
+		if !ok {
+			// attribute name can not be used in update, so rewrite entity data
+			return storagecommon.UpdateByRewrite(es, typeName, entityID, updates)
+		}
+		if u.Unset {
+			unset[key] = 1
+		} else {
+			set[key] = u.Value
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	col := es.getCollection(typeName)
+	epoch := storagecommon.UpdatesEpoch(updates)
+	err := col.Update(es.epochSelector(entityID, epoch), update)
+	if err == mgo.ErrNotFound && epoch > 0 {
+		// not found because of epoch or entity does not exist
+		if exists, _ := es.Exists(typeName, entityID); exists {
+			return storagecommon.StaleWriteError(storagecommon.WriteItem{TypeName: typeName, EntityID: entityID, Data: map[string]interface{}{storagecommon.EpochKey: epoch}})
+		}
+	}
+	return err
+}
+
+// updateKey returns the dotted key of attribute path in update
+func (es *mongoDBEntityStorge) updateKey(path []interface{}) (string, bool) {
+	var sb strings.Builder
+	sb.WriteString("data")
+	for _, p := range path {
+		sb.WriteByte('.')
+		switch k := p.(type) {
+		case string:
+			if k == "" || strings.ContainsRune(k, '.') || k[0] == '$' {
+				return "", false
+			}
+			sb.WriteString(k)
+		case int:
+			sb.WriteString(strconv.Itoa(k))
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
 func (es *mongoDBEntityStorge) convertM2Map(m bson.M) map[string]interface{} {
 	ma := map[string]interface{}(m)
 	es.convertM2MapInMap(ma)
@@ -127,6 +252,54 @@ func (es *mongoDBEntityStorge) Exists(typeName string, entityID common.EntityID)
 	}
 }
 
+func (es *mongoDBEntityStorge) Delete(typeName string, entityID common.EntityID) error {
+	col := es.getCollection(typeName)
+	err := col.RemoveId(entityID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (es *mongoDBEntityStorge) EnsureIndex(typeName string, attr string) error {
+	col := es.getCollection(typeName)
+	return col.EnsureIndexKey("data." + attr)
+}
+
+var mongoQueryOps = map[storagecommon.QueryOp]string{
+	storagecommon.QueryEq:  "$eq",
+	storagecommon.QueryLt:  "$lt",
+	storagecommon.QueryLte: "$lte",
+	storagecommon.QueryGt:  "$gt",
+	storagecommon.QueryGte: "$gte",
+}
+
+func (es *mongoDBEntityStorge) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	query := bson.M{}
+	for _, cond := range filter {
+		key := "data." + cond.Attr
+		m, ok := query[key].(bson.M)
+		if !ok {
+			m = bson.M{}
+			query[key] = m
+		}
+		m[mongoQueryOps[cond.Op]] = cond.Value
+	}
+
+	col := es.getCollection(typeName)
+	var docs []bson.M
+	err := col.Find(query).Select(bson.M{"_id": 1}).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	entityIDs := make([]common.EntityID, len(docs))
+	for i, doc := range docs {
+		entityIDs[i] = common.EntityID(doc["_id"].(string))
+	}
+	return entityIDs, nil
+}
+
 func (es *mongoDBEntityStorge) Close() {
 	es.db.Session.Close()
 }
@@ -0,0 +1,173 @@
+package entitystorageredis
+
+import (
+	"io"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redisindex"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	keyPrefix      = "_ES_"       // prefix of entity data keys: _ES_<type>_<entityID>
+	idsKeyPrefix   = "_ES_IDS_"   // prefix of entity ID set keys: _ES_IDS_<type>
+	epochKeyPrefix = "_ES_EPOCH_" // prefix of entity owner epoch keys: _ES_EPOCH_<type>_<entityID>
+)
+
+type redisEntityStorage struct {
+	c     redis.Conn
+	index *redisindex.Index
+}
+
+// OpenRedis opens redis as entity storage
+func OpenRedis(url string, dbindex int) (storagecommon.EntityStorage, error) {
+	gwlog.Debugf("Connecting Redis ...")
+	c, err := redis.DialURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "redis dail failed")
+	}
+
+	if dbindex >= 0 {
+		if _, err := c.Do("SELECT", dbindex); err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "redis select db failed")
+		}
+	}
+
+	return &redisEntityStorage{
+		c:     c,
+		index: redisindex.New(c.Do),
+	}, nil
+}
+
+func entityKey(typeName string, entityID common.EntityID) string {
+	return keyPrefix + typeName + "_" + string(entityID)
+}
+
+func epochKey(typeName string, entityID common.EntityID) string {
+	return epochKeyPrefix + typeName + "_" + string(entityID)
+}
+
+func (es *redisEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	eids, err := redis.Strings(es.c.Do("SMEMBERS", idsKeyPrefix+typeName))
+	if err != nil {
+		return nil, err
+	}
+
+	entityIDs := make([]common.EntityID, len(eids))
+	for i, eid := range eids {
+		entityIDs[i] = common.EntityID(eid)
+	}
+	return entityIDs, nil
+}
+
+func (es *redisEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.WriteBatch([]storagecommon.WriteItem{{TypeName: typeName, EntityID: entityID, Data: data}})
+}
+
+// writeScript writes entity data if its epoch is not less than the stored epoch, returns 0 if rejected
+const writeScript = `
+local epoch = tonumber(ARGV[2])
+if epoch > 0 then
+	if epoch < tonumber(redis.call('GET', KEYS[2]) or '0') then
+		return 0
+	end
+	redis.call('SET', KEYS[2], epoch)
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`
+
+// WriteBatch writes all entities in one transaction
+func (es *redisEntityStorage) WriteBatch(items []storagecommon.WriteItem) error {
+	packed := make([][]byte, len(items))
+	for i, item := range items {
+		b, err := netutil.MSG_PACKER.PackMsg(item.Data, nil)
+		if err != nil {
+			return err
+		}
+		packed[i] = b
+	}
+
+	es.c.Send("MULTI")
+	for i, item := range items {
+		es.c.Send("EVAL", writeScript, 2, entityKey(item.TypeName, item.EntityID), epochKey(item.TypeName, item.EntityID),
+			packed[i], storagecommon.DataEpoch(item.Data))
+		es.c.Send("SADD", idsKeyPrefix+item.TypeName, string(item.EntityID))
+	}
+	replies, err := redis.Values(es.c.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	var stale []storagecommon.WriteItem
+	for i, item := range items {
+		if written, err := redis.Bool(replies[i*2], nil); err != nil {
+			return err
+		} else if !written {
+			stale = append(stale, item)
+			continue
+		}
+		if err := es.index.Write(item.TypeName, item.EntityID, item.Data); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		return storagecommon.StaleWriteError(stale...)
+	}
+	return nil
+}
+
+func (es *redisEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	b, err := redis.Bytes(es.c.Do("GET", entityKey(typeName, entityID)))
+	if err == redis.ErrNil {
+		return nil, errors.Errorf("%s %s not found", typeName, entityID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *redisEntityStorage) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	return storagecommon.UpdateByRewrite(es, typeName, entityID, updates)
+}
+
+func (es *redisEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return redis.Bool(es.c.Do("EXISTS", entityKey(typeName, entityID)))
+}
+
+// Delete deletes entity data but keeps the owner epoch, so that delayed writes of superseded owners are still rejected
+func (es *redisEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	es.c.Send("MULTI")
+	es.c.Send("DEL", entityKey(typeName, entityID))
+	es.c.Send("SREM", idsKeyPrefix+typeName, string(entityID))
+	if _, err := es.c.Do("EXEC"); err != nil {
+		return err
+	}
+	return es.index.Delete(typeName, entityID)
+}
+
+func (es *redisEntityStorage) EnsureIndex(typeName string, attr string) error {
+	return es.index.Ensure(es, typeName, attr)
+}
+
+func (es *redisEntityStorage) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	return es.index.Query(typeName, filter)
+}
+
+func (es *redisEntityStorage) Close() {
+	es.c.Close()
+}
+
+func (es *redisEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
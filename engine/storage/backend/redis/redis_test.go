@@ -0,0 +1,23 @@
+package entitystorageredis
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common/storagetest"
+)
+
+func TestRedisEntityStorage(t *testing.T) {
+	storagetest.RequireServer(t, "localhost:6379")
+	es, err := OpenRedis("redis://localhost:6379", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwlog.Infof("TestRedisEntityStorage: %v", es)
+	storagetest.TestEntityStorage(t, es)
+	storagetest.TestEntityStorageQuery(t, es)
+	storagetest.TestEntityStorageUpdate(t, es)
+	storagetest.TestEntityStorageWriteBatch(t, es)
+	storagetest.TestEntityStorageEpoch(t, es)
+	storagetest.TestEntityStorageDeleteEpoch(t, es)
+}
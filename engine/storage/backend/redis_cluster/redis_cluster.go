@@ -0,0 +1,179 @@
+package entitystoragerediscluster
+
+import (
+	"io"
+	"time"
+
+	"github.com/chasex/redis-go-cluster"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redisindex"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	keyPrefix      = "_ES_"       // prefix of entity data keys: _ES_<type>_<entityID>
+	idsKeyPrefix   = "_ES_IDS_"   // prefix of entity ID set keys: _ES_IDS_<type>
+	epochKeyPrefix = "_ES_EPOCH_" // prefix of entity owner epoch keys: _ES_EPOCH_<type>_<entityID>
+)
+
+type redisClusterEntityStorage struct {
+	c     redis.Cluster
+	index *redisindex.Index
+}
+
+// OpenRedisCluster opens redis cluster as entity storage
+func OpenRedisCluster(startNodes []string) (storagecommon.EntityStorage, error) {
+	gwlog.Debugf("Connecting Redis Cluster %v ...", startNodes)
+	c, err := redis.NewCluster(&redis.Options{
+		StartNodes:   startNodes,
+		ConnTimeout:  10 * time.Second, // Connection timeout
+		ReadTimeout:  60 * time.Second, // Read timeout
+		WriteTimeout: 60 * time.Second, // Write timeout
+		KeepAlive:    1,                // Maximum keep alive connecion in each node
+		AliveTime:    10 * time.Minute, // Keep alive timeout
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "redis cluster dail failed")
+	}
+
+	return &redisClusterEntityStorage{
+		c:     c,
+		index: redisindex.New(c.Do),
+	}, nil
+}
+
+func entityKey(typeName string, entityID common.EntityID) string {
+	return keyPrefix + typeName + "_" + string(entityID)
+}
+
+func epochKey(typeName string, entityID common.EntityID) string {
+	return epochKeyPrefix + typeName + "_" + string(entityID)
+}
+
+func (es *redisClusterEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	eids, err := redis.Strings(es.c.Do("SMEMBERS", idsKeyPrefix+typeName))
+	if err != nil {
+		return nil, err
+	}
+
+	entityIDs := make([]common.EntityID, len(eids))
+	for i, eid := range eids {
+		entityIDs[i] = common.EntityID(eid)
+	}
+	return entityIDs, nil
+}
+
+func (es *redisClusterEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	b, err := netutil.MSG_PACKER.PackMsg(data, nil)
+	if err != nil {
+		return err
+	}
+
+	if epoch := storagecommon.DataEpoch(data); epoch > 0 {
+		// the cluster client can not route scripts by keys, so epoch is checked and set before writing data (not atomically)
+		stored, err := es.storedEpoch(typeName, entityID)
+		if err != nil {
+			return err
+		}
+		if epoch < stored {
+			return storagecommon.StaleWriteError(storagecommon.WriteItem{TypeName: typeName, EntityID: entityID, Data: data})
+		}
+		if _, err = es.c.Do("SET", epochKey(typeName, entityID), epoch); err != nil {
+			return err
+		}
+	}
+
+	// entity data and ID set are possibly in different slots, so MULTI can not be used in cluster
+	if _, err = es.c.Do("SET", entityKey(typeName, entityID), b); err != nil {
+		return err
+	}
+	if _, err = es.c.Do("SADD", idsKeyPrefix+typeName, string(entityID)); err != nil {
+		return err
+	}
+	return es.index.Write(typeName, entityID, data)
+}
+
+// storedEpoch returns the stored owner epoch of entity, or 0 if not stored
+func (es *redisClusterEntityStorage) storedEpoch(typeName string, entityID common.EntityID) (int64, error) {
+	r, err := es.c.Do("GET", epochKey(typeName, entityID))
+	if err != nil || r == nil {
+		return 0, err
+	}
+	return redis.Int64(r, nil)
+}
+
+// WriteBatch writes entities one by one, since keys are possibly in different slots
+func (es *redisClusterEntityStorage) WriteBatch(items []storagecommon.WriteItem) error {
+	var stale []storagecommon.WriteItem
+	for _, item := range items {
+		if err := es.Write(item.TypeName, item.EntityID, item.Data); storagecommon.IsStaleWrite(err) {
+			stale = append(stale, item)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		return storagecommon.StaleWriteError(stale...)
+	}
+	return nil
+}
+
+func (es *redisClusterEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	r, err := es.c.Do("GET", entityKey(typeName, entityID))
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, errors.Errorf("%s %s not found", typeName, entityID)
+	}
+
+	b, err := redis.Bytes(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *redisClusterEntityStorage) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	return storagecommon.UpdateByRewrite(es, typeName, entityID, updates)
+}
+
+func (es *redisClusterEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return redis.Bool(es.c.Do("EXISTS", entityKey(typeName, entityID)))
+}
+
+func (es *redisClusterEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	// remove from ID set first, so that the entity is never listed without data
+	if _, err := es.c.Do("SREM", idsKeyPrefix+typeName, string(entityID)); err != nil {
+		return err
+	}
+	// owner epoch is kept, so that delayed writes of superseded owners are still rejected
+	if _, err := es.c.Do("DEL", entityKey(typeName, entityID)); err != nil {
+		return err
+	}
+	return es.index.Delete(typeName, entityID)
+}
+
+func (es *redisClusterEntityStorage) EnsureIndex(typeName string, attr string) error {
+	return es.index.Ensure(es, typeName, attr)
+}
+
+func (es *redisClusterEntityStorage) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	return es.index.Query(typeName, filter)
+}
+
+func (es *redisClusterEntityStorage) Close() {
+	// redis cluster connections are managed by the cluster client
+}
+
+func (es *redisClusterEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
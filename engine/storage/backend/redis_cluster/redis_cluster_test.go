@@ -0,0 +1,23 @@
+package entitystoragerediscluster
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common/storagetest"
+)
+
+func TestRedisClusterEntityStorage(t *testing.T) {
+	storagetest.RequireServer(t, "127.0.0.1:6379")
+	es, err := OpenRedisCluster([]string{"127.0.0.1:6379"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwlog.Infof("TestRedisClusterEntityStorage: %v", es)
+	storagetest.TestEntityStorage(t, es)
+	storagetest.TestEntityStorageQuery(t, es)
+	storagetest.TestEntityStorageUpdate(t, es)
+	storagetest.TestEntityStorageWriteBatch(t, es)
+	storagetest.TestEntityStorageEpoch(t, es)
+	storagetest.TestEntityStorageDeleteEpoch(t, es)
+}
@@ -0,0 +1,225 @@
+// Package redisindex implements indexes of entity attributes for redis and redis cluster entity storages
+//
+// Number attributes are indexed in sorted sets with the values as scores, string attributes are indexed in sets
+// of entity IDs for each value. Indexed values of each entity are kept in a hash for removing old index entries.
+package redisindex
+
+import (
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	attrsKeyPrefix  = "_ES_IDX_ATTRS_" // set of indexed attributes: _ES_IDX_ATTRS_<type>
+	numKeyPrefix    = "_ES_IDX_N_"     // sorted set of number attribute: _ES_IDX_N_<type>_<attr>
+	strKeyPrefix    = "_ES_IDX_S_"     // set of entities with string attribute value: _ES_IDX_S_<type>_<attr>_<value>
+	valuesKeyPrefix = "_ES_IDX_V_"     // hash of indexed values of entity: _ES_IDX_V_<type>_<entityID>
+)
+
+// DoFunc executes redis command
+type DoFunc func(cmd string, args ...interface{}) (interface{}, error)
+
+// Index maintains indexes of entity attributes in redis
+type Index struct {
+	do    DoFunc
+	attrs map[string][]string // indexed attributes of entity types
+}
+
+// New creates index using the redis command executor
+func New(do DoFunc) *Index {
+	return &Index{
+		do:    do,
+		attrs: map[string][]string{},
+	}
+}
+
+func numKey(typeName string, attr string) string {
+	return numKeyPrefix + typeName + "_" + attr
+}
+
+func strKey(typeName string, attr string, val string) string {
+	return strKeyPrefix + typeName + "_" + attr + "_" + val
+}
+
+func valuesKey(typeName string, entityID common.EntityID) string {
+	return valuesKeyPrefix + typeName + "_" + string(entityID)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeValue encodes index value to string stored in the values hash
+func encodeValue(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return "s" + s
+	}
+	return "n" + formatFloat(val.(float64))
+}
+
+// Ensure creates index on attribute, and indexes all existing entities if the index is new
+func (idx *Index) Ensure(es storagecommon.EntityStorage, typeName string, attr string) error {
+	for _, a := range idx.attrs[typeName] {
+		if a == attr {
+			return nil
+		}
+	}
+	idx.attrs[typeName] = append(idx.attrs[typeName], attr)
+
+	indexed, err := redis.Bool(idx.do("SISMEMBER", attrsKeyPrefix+typeName, attr))
+	if err != nil || indexed {
+		return err
+	}
+
+	gwlog.Infof("redis: building index %s.%s ...", typeName, attr)
+	eids, err := es.List(typeName)
+	if err != nil {
+		return err
+	}
+	for _, eid := range eids {
+		data, err := es.Read(typeName, eid)
+		if err != nil {
+			return err
+		}
+		if val, ok := data.(map[string]interface{})[attr]; ok {
+			if err := idx.add(typeName, attr, eid, val); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = idx.do("SADD", attrsKeyPrefix+typeName, attr)
+	return err
+}
+
+func (idx *Index) add(typeName string, attr string, entityID common.EntityID, val interface{}) error {
+	val, ok := storagecommon.IndexValue(val)
+	if !ok {
+		gwlog.Warnf("%s.%s of %s can not be indexed: %v", typeName, attr, entityID, val)
+		return nil
+	}
+
+	var err error
+	if s, ok := val.(string); ok {
+		_, err = idx.do("SADD", strKey(typeName, attr, s), string(entityID))
+	} else {
+		_, err = idx.do("ZADD", numKey(typeName, attr), formatFloat(val.(float64)), string(entityID))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = idx.do("HSET", valuesKey(typeName, entityID), attr, encodeValue(val))
+	return err
+}
+
+// Write updates index entries of entity with the new data
+func (idx *Index) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	attrs := idx.attrs[typeName]
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m, _ := data.(map[string]interface{})
+	oldValues, err := redis.StringMap(idx.do("HGETALL", valuesKey(typeName, entityID)))
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		val, ok := storagecommon.IndexValue(m[attr])
+		if ok && oldValues[attr] == encodeValue(val) {
+			continue // not changed
+		}
+		if err := idx.remove(typeName, attr, entityID, oldValues[attr]); err != nil {
+			return err
+		}
+		if _, exists := m[attr]; exists {
+			if err := idx.add(typeName, attr, entityID, m[attr]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (idx *Index) remove(typeName string, attr string, entityID common.EntityID, oldValue string) error {
+	if oldValue == "" {
+		return nil
+	}
+
+	var err error
+	if oldValue[0] == 's' {
+		_, err = idx.do("SREM", strKey(typeName, attr, oldValue[1:]), string(entityID))
+	} else {
+		_, err = idx.do("ZREM", numKey(typeName, attr), string(entityID))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = idx.do("HDEL", valuesKey(typeName, entityID), attr)
+	return err
+}
+
+// Delete removes all index entries of entity
+func (idx *Index) Delete(typeName string, entityID common.EntityID) error {
+	oldValues, err := redis.StringMap(idx.do("HGETALL", valuesKey(typeName, entityID)))
+	if err != nil {
+		return err
+	}
+
+	for attr, oldValue := range oldValues {
+		if err := idx.remove(typeName, attr, entityID, oldValue); err != nil {
+			return err
+		}
+	}
+	_, err = idx.do("DEL", valuesKey(typeName, entityID))
+	return err
+}
+
+// Query returns IDs of entities matching the filter
+func (idx *Index) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	var results [][]common.EntityID
+	for _, cond := range filter {
+		var eids []string
+		var err error
+		if s, ok := cond.Value.(string); ok {
+			if cond.Op != storagecommon.QueryEq {
+				return nil, errors.Errorf("redis: range query on string attribute %s is not supported", cond.Attr)
+			}
+			eids, err = redis.Strings(idx.do("SMEMBERS", strKey(typeName, cond.Attr, s)))
+		} else {
+			min, max := scoreRange(cond.Op, formatFloat(cond.Value.(float64)))
+			eids, err = redis.Strings(idx.do("ZRANGEBYSCORE", numKey(typeName, cond.Attr), min, max))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entityIDs := make([]common.EntityID, len(eids))
+		for i, eid := range eids {
+			entityIDs[i] = common.EntityID(eid)
+		}
+		results = append(results, entityIDs)
+	}
+	return storagecommon.IntersectEntityIDs(results), nil
+}
+
+// scoreRange returns the min and max arguments of ZRANGEBYSCORE
+func scoreRange(op storagecommon.QueryOp, val string) (string, string) {
+	switch op {
+	case storagecommon.QueryLt:
+		return "-inf", "(" + val
+	case storagecommon.QueryLte:
+		return "-inf", val
+	case storagecommon.QueryGt:
+		return "(" + val, "+inf"
+	case storagecommon.QueryGte:
+		return val, "+inf"
+	default:
+		return val, val
+	}
+}
@@ -0,0 +1,271 @@
+package entitystoragesql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+
+	// SQL drivers supported by entity storage
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlDialect defines the SQL statements which differs between databases
+type sqlDialect struct {
+	createTable      string   // create table if not exists, %s is the quoted table name
+	createIndexTable []string // create index table and its indexes, %[1]s is the quoted table name, %[2]s, %[3]s and %[4]s are quoted index names
+	upsert           string   // insert or update entity data and epoch, %s is the quoted table name
+	selectEpoch      string   // select epoch of entity for update, %s is the quoted table name
+	placeholder      func(i int) string
+	quote            func(name string) string
+}
+
+var dialects = map[string]*sqlDialect{
+	"sqlite3": {
+		createTable: "CREATE TABLE IF NOT EXISTS %s (id VARCHAR(64) PRIMARY KEY, data BLOB NOT NULL, epoch BIGINT NOT NULL DEFAULT 0)",
+		createIndexTable: []string{
+			"CREATE TABLE IF NOT EXISTS %[1]s (attr VARCHAR(64) NOT NULL, id VARCHAR(64) NOT NULL, num DOUBLE PRECISION, str VARCHAR(255))",
+			"CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (attr, num)",
+			"CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (attr, str)",
+			"CREATE INDEX IF NOT EXISTS %[4]s ON %[1]s (id)",
+		},
+		upsert:      "REPLACE INTO %s (id, data, epoch) VALUES (?, ?, ?)",
+		selectEpoch: "SELECT epoch FROM %s WHERE id = ?", // sqlite locks the whole database in transaction
+		placeholder: func(i int) string { return "?" },
+		quote:       func(name string) string { return `"` + name + `"` },
+	},
+	"mysql": {
+		createTable: "CREATE TABLE IF NOT EXISTS %s (id VARCHAR(64) PRIMARY KEY, data LONGBLOB NOT NULL, epoch BIGINT NOT NULL DEFAULT 0)",
+		createIndexTable: []string{
+			"CREATE TABLE IF NOT EXISTS %[1]s (attr VARCHAR(64) NOT NULL, id VARCHAR(64) NOT NULL, num DOUBLE PRECISION, str VARCHAR(255), " +
+				"INDEX %[2]s (attr, num), INDEX %[3]s (attr, str), INDEX %[4]s (id))",
+		},
+		upsert:      "REPLACE INTO %s (id, data, epoch) VALUES (?, ?, ?)",
+		selectEpoch: "SELECT epoch FROM %s WHERE id = ? FOR UPDATE",
+		placeholder: func(i int) string { return "?" },
+		quote:       func(name string) string { return "`" + name + "`" },
+	},
+	"postgres": {
+		createTable: "CREATE TABLE IF NOT EXISTS %s (id VARCHAR(64) PRIMARY KEY, data BYTEA NOT NULL, epoch BIGINT NOT NULL DEFAULT 0)",
+		createIndexTable: []string{
+			"CREATE TABLE IF NOT EXISTS %[1]s (attr VARCHAR(64) NOT NULL, id VARCHAR(64) NOT NULL, num DOUBLE PRECISION, str VARCHAR(255))",
+			"CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (attr, num)",
+			"CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (attr, str)",
+			"CREATE INDEX IF NOT EXISTS %[4]s ON %[1]s (id)",
+		},
+		upsert:      "INSERT INTO %s (id, data, epoch) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, epoch = EXCLUDED.epoch",
+		selectEpoch: "SELECT epoch FROM %s WHERE id = $1 FOR UPDATE",
+		placeholder: func(i int) string { return fmt.Sprintf("$%d", i) },
+		quote:       func(name string) string { return `"` + name + `"` },
+	},
+}
+
+type sqlEntityStorage struct {
+	db      *sql.DB
+	dialect *sqlDialect
+	tables  map[string]bool     // tables that are already created
+	indexes map[string][]string // indexed attributes of entity types
+}
+
+// OpenSQL opens SQL database as entity storage, one table is used for each entity type
+func OpenSQL(driverName string, dsn string) (storagecommon.EntityStorage, error) {
+	dialect := dialects[driverName]
+	if dialect == nil {
+		return nil, errors.Errorf("unsupported SQL driver: %s", driverName)
+	}
+
+	gwlog.Debugf("Connecting %s ...", driverName)
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if driverName == "sqlite3" {
+		// sqlite does not support concurrent writing
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqlEntityStorage{
+		db:      db,
+		dialect: dialect,
+		tables:  map[string]bool{},
+		indexes: map[string][]string{},
+	}, nil
+}
+
+// table returns the quoted table name of the entity type, and creates the table and its index table if necessary
+func (es *sqlEntityStorage) table(typeName string) (string, error) {
+	table := es.dialect.quote(typeName)
+	if es.tables[typeName] {
+		return table, nil
+	}
+
+	if _, err := es.db.Exec(fmt.Sprintf(es.dialect.createTable, table)); err != nil {
+		return "", errors.Wrapf(err, "create table %s failed", typeName)
+	}
+	for _, stmt := range es.dialect.createIndexTable {
+		stmt = fmt.Sprintf(stmt, es.indexTable(typeName),
+			es.dialect.quote(typeName+"__index_num"), es.dialect.quote(typeName+"__index_str"), es.dialect.quote(typeName+"__index_id"))
+		if _, err := es.db.Exec(stmt); err != nil {
+			return "", errors.Wrapf(err, "create index table of %s failed", typeName)
+		}
+	}
+	es.tables[typeName] = true
+	return table, nil
+}
+
+func (es *sqlEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	table, err := es.table(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	return es.queryEntityIDs(fmt.Sprintf("SELECT id FROM %s", table))
+}
+
+func (es *sqlEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.WriteBatch([]storagecommon.WriteItem{{TypeName: typeName, EntityID: entityID, Data: data}})
+}
+
+// WriteBatch writes all entities in one transaction
+func (es *sqlEntityStorage) WriteBatch(items []storagecommon.WriteItem) error {
+	tables := make([]string, len(items))
+	packed := make([][]byte, len(items))
+	for i, item := range items {
+		table, err := es.table(item.TypeName)
+		if err != nil {
+			return err
+		}
+		b, err := netutil.MSG_PACKER.PackMsg(item.Data, nil)
+		if err != nil {
+			return err
+		}
+		tables[i], packed[i] = table, b
+	}
+
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var stale []storagecommon.WriteItem
+	for i, item := range items {
+		written, err := es.write(tx, tables[i], item, packed[i])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !written {
+			stale = append(stale, item)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(stale) > 0 {
+		return storagecommon.StaleWriteError(stale...)
+	}
+	return nil
+}
+
+// write writes entity data in transaction, returns false if the epoch of data is less than the stored epoch
+func (es *sqlEntityStorage) write(tx *sql.Tx, table string, item storagecommon.WriteItem, b []byte) (bool, error) {
+	var stored int64
+	err := tx.QueryRow(fmt.Sprintf(es.dialect.selectEpoch, table), string(item.EntityID)).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	epoch := storagecommon.DataEpoch(item.Data)
+	if epoch == 0 {
+		epoch = stored // data without epoch does not change the stored epoch
+	} else if epoch < stored {
+		return false, nil
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(es.dialect.upsert, table), string(item.EntityID), b, epoch); err != nil {
+		return false, err
+	}
+	return true, es.writeIndex(tx, item.TypeName, item.EntityID, item.Data)
+}
+
+func (es *sqlEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	table, err := es.table(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	query := fmt.Sprintf("SELECT data FROM %s WHERE id = %s", table, es.dialect.placeholder(1))
+	if err := es.db.QueryRow(query, string(entityID)).Scan(&b); err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *sqlEntityStorage) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	return storagecommon.UpdateByRewrite(es, typeName, entityID, updates)
+}
+
+func (es *sqlEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	table, err := es.table(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	var n int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = %s", table, es.dialect.placeholder(1))
+	if err := es.db.QueryRow(query, string(entityID)).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (es *sqlEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	table, err := es.table(typeName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, es.dialect.placeholder(1)), string(entityID)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", es.indexTable(typeName), es.dialect.placeholder(1)), string(entityID)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (es *sqlEntityStorage) Close() {
+	es.db.Close()
+}
+
+func (es *sqlEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == driver.ErrBadConn
+}
@@ -0,0 +1,164 @@
+package entitystoragesql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Indexed attributes are stored in the index table of entity type, one row for each attribute of each entity.
+// Numbers are stored in column num and strings in column str, both are indexed by database.
+const indexMetaTable = "goworld_indexes" // records indexed attributes of all entity types
+
+func (es *sqlEntityStorage) indexTable(typeName string) string {
+	return es.dialect.quote(typeName + "__index")
+}
+
+func (es *sqlEntityStorage) EnsureIndex(typeName string, attr string) error {
+	if _, err := es.table(typeName); err != nil {
+		return err
+	}
+
+	for _, a := range es.indexes[typeName] {
+		if a == attr {
+			return nil
+		}
+	}
+
+	meta := es.dialect.quote(indexMetaTable)
+	if _, err := es.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (type_name VARCHAR(64) NOT NULL, attr VARCHAR(64) NOT NULL, PRIMARY KEY (type_name, attr))", meta)); err != nil {
+		return err
+	}
+
+	es.indexes[typeName] = append(es.indexes[typeName], attr)
+
+	var n int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE type_name = %s AND attr = %s", meta, es.dialect.placeholder(1), es.dialect.placeholder(2))
+	if err := es.db.QueryRow(query, typeName, attr).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// new index, index all existing entities
+	if err := es.rebuildIndex(typeName, attr); err != nil {
+		return err
+	}
+	_, err := es.db.Exec(fmt.Sprintf("INSERT INTO %s (type_name, attr) VALUES (%s, %s)", meta, es.dialect.placeholder(1), es.dialect.placeholder(2)), typeName, attr)
+	return err
+}
+
+func (es *sqlEntityStorage) rebuildIndex(typeName string, attr string) error {
+	gwlog.Infof("%s: building index %s.%s ...", indexMetaTable, typeName, attr)
+	eids, err := es.List(typeName)
+	if err != nil {
+		return err
+	}
+
+	for _, eid := range eids {
+		data, err := es.Read(typeName, eid)
+		if err != nil {
+			return err
+		}
+		if val, ok := data.(map[string]interface{})[attr]; ok {
+			if err := es.insertIndex(es.db, typeName, attr, eid, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (es *sqlEntityStorage) insertIndex(execer sqlExecer, typeName string, attr string, entityID common.EntityID, val interface{}) error {
+	val, ok := storagecommon.IndexValue(val)
+	if !ok {
+		gwlog.Warnf("%s.%s of %s can not be indexed: %v", typeName, attr, entityID, val)
+		return nil
+	}
+
+	col := "num"
+	if _, ok := val.(string); ok {
+		col = "str"
+	}
+	query := fmt.Sprintf("INSERT INTO %s (attr, id, %s) VALUES (%s, %s, %s)", es.indexTable(typeName), col,
+		es.dialect.placeholder(1), es.dialect.placeholder(2), es.dialect.placeholder(3))
+	_, err := execer.Exec(query, attr, string(entityID), val)
+	return err
+}
+
+// writeIndex replaces index rows of the entity with the new data
+func (es *sqlEntityStorage) writeIndex(tx *sql.Tx, typeName string, entityID common.EntityID, data interface{}) error {
+	attrs := es.indexes[typeName]
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m, _ := data.(map[string]interface{})
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", es.indexTable(typeName), es.dialect.placeholder(1)), string(entityID)); err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		if val, ok := m[attr]; ok {
+			if err := es.insertIndex(tx, typeName, attr, entityID, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var sqlQueryOps = map[storagecommon.QueryOp]string{
+	storagecommon.QueryEq:  "=",
+	storagecommon.QueryLt:  "<",
+	storagecommon.QueryLte: "<=",
+	storagecommon.QueryGt:  ">",
+	storagecommon.QueryGte: ">=",
+}
+
+func (es *sqlEntityStorage) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	if _, err := es.table(typeName); err != nil {
+		return nil, err
+	}
+
+	var results [][]common.EntityID
+	for _, cond := range filter {
+		col := "num"
+		if _, ok := cond.Value.(string); ok {
+			col = "str"
+		}
+		query := fmt.Sprintf("SELECT id FROM %s WHERE attr = %s AND %s %s %s", es.indexTable(typeName),
+			es.dialect.placeholder(1), col, sqlQueryOps[cond.Op], es.dialect.placeholder(2))
+		eids, err := es.queryEntityIDs(query, cond.Attr, cond.Value)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, eids)
+	}
+	return storagecommon.IntersectEntityIDs(results), nil
+}
+
+func (es *sqlEntityStorage) queryEntityIDs(query string, args ...interface{}) ([]common.EntityID, error) {
+	rows, err := es.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entityIDs []common.EntityID
+	for rows.Next() {
+		var eid string
+		if err := rows.Scan(&eid); err != nil {
+			return nil, err
+		}
+		entityIDs = append(entityIDs, common.EntityID(eid))
+	}
+	return entityIDs, rows.Err()
+}
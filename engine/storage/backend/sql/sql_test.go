@@ -0,0 +1,48 @@
+package entitystoragesql
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/storage/storage_common/storagetest"
+)
+
+func TestSQLiteEntityStorage(t *testing.T) {
+	es, err := OpenSQL("sqlite3", filepath.Join(t.TempDir(), "goworld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	storagetest.TestEntityStorage(t, es)
+	storagetest.TestEntityStorageQuery(t, es)
+	storagetest.TestEntityStorageUpdate(t, es)
+	storagetest.TestEntityStorageWriteBatch(t, es)
+	storagetest.TestEntityStorageEpoch(t, es)
+}
+
+func TestSQLiteEntityStorageOverwrite(t *testing.T) {
+	es, err := OpenSQL("sqlite3", filepath.Join(t.TempDir(), "goworld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	if err := es.Write("Avatar", "A", map[string]interface{}{"v": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Write("Avatar", "A", map[string]interface{}{"v": 2}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := es.Read("Avatar", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := data.(map[string]interface{})["v"]; v != int8(2) && v != int64(2) && v != uint8(2) {
+		t.Errorf("wrong data after overwrite: %v", data)
+	}
+	ids, err := es.List("Avatar")
+	if err != nil || len(ids) != 1 {
+		t.Errorf("List should return 1 entity: %v, %v", ids, err)
+	}
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -10,7 +12,11 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/backend/embedded"
 	"github.com/xiaonanln/goworld/engine/storage/backend/mongodb"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redis"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redis_cluster"
+	"github.com/xiaonanln/goworld/engine/storage/backend/sql"
 	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 )
 
@@ -18,6 +24,7 @@ var (
 	storageEngine            storagecommon.EntityStorage
 	operationQueue           = xnsyncutil.NewSyncQueue()
 	storageRoutineTerminated = xnsyncutil.NewOneTimeCond()
+	indexedAttrs             = map[string]common.StringSet{} // indexed attributes of entity types, only used in storage routine
 )
 
 type saveRequest struct {
@@ -27,6 +34,13 @@ type saveRequest struct {
 	Callback SaveCallbackFunc
 }
 
+type updateRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Updates  []storagecommon.AttrUpdate
+	Callback UpdateCallbackFunc
+}
+
 type loadRequest struct {
 	TypeName string
 	EntityID common.EntityID
@@ -39,6 +53,24 @@ type existsRequest struct {
 	Callback ExistsCallbackFunc
 }
 
+type deleteRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Callback DeleteCallbackFunc
+}
+
+type ensureIndexRequest struct {
+	TypeName string
+	Attr     string
+}
+
+type queryRequest struct {
+	TypeName string
+	Filter   storagecommon.QueryFilter
+	WithData bool
+	Callback QueryCallbackFunc
+}
+
 type listEntityIDsRequest struct {
 	TypeName string
 	Callback ListCallbackFunc
@@ -47,54 +79,121 @@ type listEntityIDsRequest struct {
 // SaveCallbackFunc is the callback type of storage Save
 type SaveCallbackFunc func()
 
+// UpdateCallbackFunc is the callback type of storage Update
+type UpdateCallbackFunc func(err error)
+
 // LoadCallbackFunc is the callback type of storage Load
 type LoadCallbackFunc func(data interface{}, err error)
 
 // ExistsCallbackFunc is the callback type of storage Exists
 type ExistsCallbackFunc func(exists bool, err error)
 
+// DeleteCallbackFunc is the callback type of storage Delete
+type DeleteCallbackFunc func(err error)
+
+// QueryResult is the entity matching the query, Data is nil if query without data
+type QueryResult struct {
+	EntityID common.EntityID
+	Data     map[string]interface{}
+}
+
+// QueryCallbackFunc is the callback type of storage Query
+type QueryCallbackFunc func(results []QueryResult, err error)
+
 // ListCallbackFunc is the callback type of storage List
 type ListCallbackFunc func([]common.EntityID, error)
 
 // Save saves entity data to storage
 func Save(typeName string, entityID common.EntityID, data interface{}, callback SaveCallbackFunc) {
-	operationQueue.Push(saveRequest{
+	pushOperation(saveRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Data:     data,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
+}
+
+// Update partially updates entity data in storage
+//
+// Entity data must be saved before updating
+func Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate, callback UpdateCallbackFunc) {
+	pushOperation(updateRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Updates:  updates,
+		Callback: callback,
+	})
 }
 
 // Load loads entity data from storage
 func Load(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
-	operationQueue.Push(loadRequest{
+	pushOperation(loadRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
 }
 
 // Exists checks if entity of specified ID exists in storage
 func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
-	operationQueue.Push(existsRequest{
+	pushOperation(existsRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
+}
+
+// Delete deletes entity data from storage
+//
+// Storage operations are executed in order, so entity data saved before Delete is always deleted
+func Delete(typeName string, entityID common.EntityID, callback DeleteCallbackFunc) {
+	pushOperation(deleteRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Callback: callback,
+	})
+}
+
+// EnsureIndex creates index on the persistent attribute of entity type
+//
+// Only indexed attributes can be used in Query
+func EnsureIndex(typeName string, attr string) {
+	pushOperation(ensureIndexRequest{
+		TypeName: typeName,
+		Attr:     attr,
+	})
+}
+
+// Query returns entities in storage which match the filter on indexed attributes
+//
+// Data of entities are also loaded if withData is true
+func Query(typeName string, filter storagecommon.QueryFilter, withData bool, callback QueryCallbackFunc) {
+	pushOperation(queryRequest{
+		TypeName: typeName,
+		Filter:   append(storagecommon.QueryFilter(nil), filter...), // filter is modified in storage routine
+		WithData: withData,
+		Callback: callback,
+	})
 }
 
 // ListEntityIDs returns all entity IDs in storage
 //
 // Return values can be large for common entity types
 func ListEntityIDs(typeName string, callback ListCallbackFunc) {
-	operationQueue.Push(listEntityIDsRequest{
+	pushOperation(listEntityIDsRequest{
 		TypeName: typeName,
 		Callback: callback,
 	})
+}
+
+// queuedOperation is the operation in queue with the time it is queued
+type queuedOperation struct {
+	op        interface{}
+	queueTime time.Time
+}
+
+func pushOperation(op interface{}) {
+	operationQueue.Push(queuedOperation{op, time.Now()})
 	checkOperationQueueLen()
 }
 
@@ -123,21 +222,65 @@ func Initialize() {
 	go storageRoutine()
 }
 
-func assureStorageEngineReady() (err error) {
-	if storageEngine != nil {
-		return
-	}
-
+// openStorageEngine opens the storage engine of config, replaced by tests
+var openStorageEngine = func() (storageEngine storagecommon.EntityStorage, err error) {
 	cfg := config.GetStorage()
 	if cfg.Type == "mongodb" {
 		storageEngine, err = entitystoragemongodb.OpenMongoDB(cfg.Url, cfg.DB)
+	} else if cfg.Type == "redis" {
+		var dbindex int
+		if dbindex, err = strconv.Atoi(cfg.DB); err != nil {
+			return
+		}
+		storageEngine, err = entitystorageredis.OpenRedis(cfg.Url, dbindex)
+	} else if cfg.Type == "redis_cluster" {
+		storageEngine, err = entitystoragerediscluster.OpenRedisCluster(cfg.StartNodes.ToList())
+	} else if cfg.Type == "sql" {
+		storageEngine, err = entitystoragesql.OpenSQL(cfg.Driver, cfg.DSN)
+	} else if cfg.Type == "embedded" {
+		storageEngine, err = entitystorageembedded.OpenEmbedded(cfg.Path)
 	} else {
 		gwlog.Panicf("unknown storage type: %s", cfg.Type)
 	}
+	return
+}
 
+func assureStorageEngineReady() (err error) {
+	if storageEngine != nil {
+		return
+	}
+
+	if storageEngine, err = openStorageEngine(); err != nil {
+		storageEngine = nil
+		return
+	}
+
+	// storage engine might be reopened, so always ensure indexes
+	for typeName, attrs := range indexedAttrs {
+		for attr := range attrs {
+			if err = storageEngine.EnsureIndex(typeName, attr); err != nil {
+				storageEngine.Close()
+				storageEngine = nil
+				return
+			}
+		}
+	}
 	return
 }
 
+// waitStorageEngineReady retries until storage engine is ready, since operations of a batch might find the storage
+// engine closed by former operations
+func waitStorageEngineReady() {
+	for {
+		err := assureStorageEngineReady()
+		if err == nil {
+			return
+		}
+		gwlog.Errorf("Storage engine is not ready: %s", err)
+		time.Sleep(time.Second) // wait for 1 second to retry
+	}
+}
+
 func storageRoutine() {
 	defer func() {
 		err := recover()
@@ -151,6 +294,7 @@ func storageRoutine() {
 		}
 	}()
 
+	batcher := newWriteBatcher()
 	for {
 		err := assureStorageEngineReady()
 		if err != nil {
@@ -159,104 +303,284 @@ func storageRoutine() {
 			continue
 		}
 
-		op := operationQueue.Pop()
-		if op == nil { // entity storage closed
+		qop := operationQueue.Pop()
+		if qop == nil { // entity storage closed
 			break
 		}
 
-		var monop *opmon.Operation
-		if saveReq, ok := op.(saveRequest); ok {
-			// handle save request
-			monop = opmon.StartOperation("storage.save")
-			for {
-				if consts.DEBUG_SAVE_LOAD {
-					gwlog.Debugf("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
-				}
-				err := assureStorageEngineReady()
-				if err != nil {
-					gwlog.Errorf("Storage engine is not ready: %s", err)
-					time.Sleep(time.Second) // wait for 1 second to retry
-					continue
-				}
-
-				if storageEngine == nil {
-					gwlog.Fatalf("storage engine is nil")
-				}
-
-				err = storageEngine.Write(saveReq.TypeName, saveReq.EntityID, saveReq.Data)
-				if err != nil {
-					// save failed ?
-					gwlog.Errorf("storage: save failed: %s", err)
-
-					if err != nil && storageEngine.IsEOF(err) {
-						storageEngine.Close()
-						storageEngine = nil
-					}
-
-					continue // always retry if fail
-				} else {
-					monop.Finish(time.Millisecond * 100)
-					if saveReq.Callback != nil {
-						post.Post(func() {
-							saveReq.Callback()
-						})
-					}
-					break
-				}
-			}
-		} else if loadReq, ok := op.(loadRequest); ok {
-			// handle load request
-			gwlog.Debugf("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
-			monop = opmon.StartOperation("storage.load")
-			data, err := storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
-			if err != nil {
-				// save failed ?
-				gwlog.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
-				data = nil
+		// handle queued operations in batch, so that writes can be coalesced
+		closed := false
+		for i := 0; i < consts.STORAGE_MAX_BATCH_SIZE; i++ {
+			op := qop.(queuedOperation)
+			if !batcher.add(op) {
+				// the operation might read data written before, so always flush writes first
+				batcher.flush()
+				handleOperation(op.op)
+				stats.recordOperation(op.queueTime)
 			}
 
-			monop.Finish(time.Millisecond * 100)
-			if loadReq.Callback != nil {
-				post.Post(func() {
-					loadReq.Callback(data, err)
-				})
+			var ok bool
+			if qop, ok = operationQueue.TryPop(); !ok {
+				break
+			} else if qop == nil {
+				closed = true
+				break
 			}
+		}
+		batcher.flush()
 
-			if err != nil && storageEngine.IsEOF(err) {
+		if closed {
+			break
+		}
+	}
+}
+
+func handleSave(saveReq saveRequest) {
+	monop := opmon.StartOperation("storage.save")
+	for {
+		if consts.DEBUG_SAVE_LOAD {
+			gwlog.Debugf("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
+		}
+		err := assureStorageEngineReady()
+		if err != nil {
+			gwlog.Errorf("Storage engine is not ready: %s", err)
+			time.Sleep(time.Second) // wait for 1 second to retry
+			continue
+		}
+
+		err = storageEngine.Write(saveReq.TypeName, saveReq.EntityID, saveReq.Data)
+		if storagecommon.IsStaleWrite(err) {
+			// entity is owned by a newer owner, never retry
+			gwlog.Warnf("storage: save %s %s rejected: %s", saveReq.TypeName, saveReq.EntityID, err)
+		} else if err != nil {
+			// save failed ?
+			gwlog.Errorf("storage: save failed: %s", err)
+
+			if storageEngine.IsEOF(err) {
 				storageEngine.Close()
 				storageEngine = nil
 			}
-		} else if existsReq, ok := op.(existsRequest); ok {
-			monop = opmon.StartOperation("storage.exists")
-			exists, err := storageEngine.Exists(existsReq.TypeName, existsReq.EntityID)
-			monop.Finish(time.Millisecond * 100)
-			if existsReq.Callback != nil {
-				post.Post(func() {
-					existsReq.Callback(exists, err)
-				})
+
+			continue // always retry if fail
+		}
+
+		monop.Finish(time.Millisecond * 100)
+		if saveReq.Callback != nil {
+			post.Post(func() {
+				saveReq.Callback()
+			})
+		}
+		return
+	}
+}
+
+func handleUpdate(updateReq updateRequest) {
+	monop := opmon.StartOperation("storage.update")
+	for {
+		if consts.DEBUG_SAVE_LOAD {
+			gwlog.Debugf("storage: UPDATING %s %s: %d updates ...", updateReq.TypeName, updateReq.EntityID, len(updateReq.Updates))
+		}
+		err := assureStorageEngineReady()
+		if err != nil {
+			gwlog.Errorf("Storage engine is not ready: %s", err)
+			time.Sleep(time.Second) // wait for 1 second to retry
+			continue
+		}
+
+		err = storageEngine.Update(updateReq.TypeName, updateReq.EntityID, updateReq.Updates)
+		if err != nil && storageEngine.IsEOF(err) {
+			gwlog.Errorf("storage: update %s %s failed: %s", updateReq.TypeName, updateReq.EntityID, err)
+			storageEngine.Close()
+			storageEngine = nil
+			continue // retry if connection is lost
+		}
+
+		if storagecommon.IsStaleWrite(err) {
+			gwlog.Warnf("storage: update %s %s rejected: %s", updateReq.TypeName, updateReq.EntityID, err)
+		} else if err != nil {
+			gwlog.TraceError("storage: update %s %s failed: %s", updateReq.TypeName, updateReq.EntityID, err)
+		}
+		monop.Finish(time.Millisecond * 100)
+		if updateReq.Callback != nil {
+			post.Post(func() {
+				updateReq.Callback(err)
+			})
+		}
+		return
+	}
+}
+
+func handleOperation(op interface{}) {
+	var monop *opmon.Operation
+	if saveReq, ok := op.(saveRequest); ok {
+		handleSave(saveReq)
+	} else if updateReq, ok := op.(updateRequest); ok {
+		handleUpdate(updateReq)
+	} else if loadReq, ok := op.(loadRequest); ok {
+		// handle load request
+		gwlog.Debugf("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
+		monop = opmon.StartOperation("storage.load")
+		waitStorageEngineReady()
+		data, err := storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
+		if err != nil {
+			// save failed ?
+			gwlog.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
+			data = nil
+		}
+
+		monop.Finish(time.Millisecond * 100)
+		if loadReq.Callback != nil {
+			post.Post(func() {
+				loadReq.Callback(data, err)
+			})
+		}
+
+		if err != nil && storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil
+		}
+	} else if existsReq, ok := op.(existsRequest); ok {
+		monop = opmon.StartOperation("storage.exists")
+		waitStorageEngineReady()
+		exists, err := storageEngine.Exists(existsReq.TypeName, existsReq.EntityID)
+		monop.Finish(time.Millisecond * 100)
+		if existsReq.Callback != nil {
+			post.Post(func() {
+				existsReq.Callback(exists, err)
+			})
+		}
+		if err != nil && storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil
+		}
+	} else if deleteReq, ok := op.(deleteRequest); ok {
+		monop = opmon.StartOperation("storage.delete")
+		for {
+			if consts.DEBUG_SAVE_LOAD {
+				gwlog.Debugf("storage: DELETING %s %s ...", deleteReq.TypeName, deleteReq.EntityID)
+			}
+			err := assureStorageEngineReady()
+			if err != nil {
+				gwlog.Errorf("Storage engine is not ready: %s", err)
+				time.Sleep(time.Second) // wait for 1 second to retry
+				continue
 			}
+
+			err = storageEngine.Delete(deleteReq.TypeName, deleteReq.EntityID)
 			if err != nil && storageEngine.IsEOF(err) {
+				// retry if connection is lost, otherwise the deleted entity might be loaded again
+				gwlog.Errorf("storage: delete %s %s failed: %s", deleteReq.TypeName, deleteReq.EntityID, err)
 				storageEngine.Close()
 				storageEngine = nil
+				continue
 			}
-		} else if listReq, ok := op.(listEntityIDsRequest); ok {
-			monop = opmon.StartOperation("storage.list")
-			eids, err := storageEngine.List(listReq.TypeName)
+
 			if err != nil {
-				gwlog.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
+				gwlog.TraceError("storage: delete %s %s failed: %s", deleteReq.TypeName, deleteReq.EntityID, err)
 			}
-			monop.Finish(time.Millisecond * 1000)
-			if listReq.Callback != nil {
+			monop.Finish(time.Millisecond * 100)
+			if deleteReq.Callback != nil {
 				post.Post(func() {
-					listReq.Callback(eids, err)
+					deleteReq.Callback(err)
 				})
 			}
-			if err != nil && storageEngine.IsEOF(err) {
-				storageEngine.Close()
-				storageEngine = nil
+			break
+		}
+	} else if indexReq, ok := op.(ensureIndexRequest); ok {
+		monop = opmon.StartOperation("storage.ensureIndex")
+		waitStorageEngineReady()
+		if indexedAttrs[indexReq.TypeName] == nil {
+			indexedAttrs[indexReq.TypeName] = common.StringSet{}
+		}
+		indexedAttrs[indexReq.TypeName].Add(indexReq.Attr)
+		err := storageEngine.EnsureIndex(indexReq.TypeName, indexReq.Attr)
+		if err != nil {
+			gwlog.TraceError("storage: ensure index %s.%s failed: %s", indexReq.TypeName, indexReq.Attr, err)
+		}
+		monop.Finish(time.Second)
+		if err != nil && storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil // indexes are ensured again when storage engine is reopened
+		}
+	} else if queryReq, ok := op.(queryRequest); ok {
+		monop = opmon.StartOperation("storage.query")
+		waitStorageEngineReady()
+		results, err := handleQuery(queryReq)
+		if err != nil {
+			gwlog.TraceError("storage: query %s %v failed: %s", queryReq.TypeName, queryReq.Filter, err)
+		}
+		monop.Finish(time.Millisecond * 1000)
+		if queryReq.Callback != nil {
+			post.Post(func() {
+				queryReq.Callback(results, err)
+			})
+		}
+		if err != nil && storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil
+		}
+	} else if listReq, ok := op.(listEntityIDsRequest); ok {
+		monop = opmon.StartOperation("storage.list")
+		waitStorageEngineReady()
+		eids, err := storageEngine.List(listReq.TypeName)
+		if err != nil {
+			gwlog.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
+		}
+		monop.Finish(time.Millisecond * 1000)
+		if listReq.Callback != nil {
+			post.Post(func() {
+				listReq.Callback(eids, err)
+			})
+		}
+		if err != nil && storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil
+		}
+	} else {
+		gwlog.Panicf("storage: unknown operation: %v", op)
+	}
+}
+
+func handleQuery(req queryRequest) ([]QueryResult, error) {
+	if err := req.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	for _, cond := range req.Filter {
+		if !indexedAttrs[req.TypeName].Contains(cond.Attr) {
+			return nil, errors.Errorf("%s.%s is not indexed", req.TypeName, cond.Attr)
+		}
+	}
+
+	eids, err := storageEngine.Query(req.TypeName, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]QueryResult, 0, len(eids))
+	for _, eid := range eids {
+		if !req.WithData {
+			results = append(results, QueryResult{EntityID: eid})
+			continue
+		}
+
+		data, err := storageEngine.Read(req.TypeName, eid)
+		if err != nil {
+			if exists, existsErr := storageEngine.Exists(req.TypeName, eid); existsErr == nil && !exists {
+				continue // entity is deleted after queried
 			}
-		} else {
-			gwlog.Panicf("storage: unknown operation: %v", op)
+			return nil, err
+		}
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			if data == nil {
+				continue
+			}
+			return nil, errors.Errorf("%s %s: wrong entity data type %T", req.TypeName, eid, data)
 		}
+		// epoch and schema version are used by storage and entity loading only
+		delete(m, storagecommon.EpochKey)
+		delete(m, storagecommon.SchemaVersionKey)
+		results = append(results, QueryResult{EntityID: eid, Data: m})
 	}
+	return results, nil
 }
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Stats is the statistics of entity storage
+type Stats struct {
+	QueueLen    int           // number of operations waiting in queue
+	Operations  int64         // number of finished operations
+	Coalesced   int64         // number of saves & updates coalesced into other writes
+	BatchWrites int64         // number of bulk writes
+	AvgLatency  time.Duration // average latency of operations since queued
+	MaxLatency  time.Duration // max latency of operations since queued
+}
+
+type storageStats struct {
+	sync.Mutex
+	operations   int64
+	coalesced    int64
+	batchWrites  int64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+var stats storageStats
+
+func init() {
+	expvar.Publish("storage", expvar.Func(func() interface{} {
+		return GetStats()
+	}))
+}
+
+func (st *storageStats) recordOperation(queueTime time.Time) {
+	latency := time.Since(queueTime)
+	st.Lock()
+	st.operations++
+	st.totalLatency += latency
+	if latency > st.maxLatency {
+		st.maxLatency = latency
+	}
+	st.Unlock()
+}
+
+func (st *storageStats) recordCoalesced() {
+	st.Lock()
+	st.coalesced++
+	st.Unlock()
+}
+
+func (st *storageStats) recordBatchWrite() {
+	st.Lock()
+	st.batchWrites++
+	st.Unlock()
+}
+
+// GetStats returns the statistics of entity storage
+func GetStats() Stats {
+	stats.Lock()
+	s := Stats{
+		QueueLen:    operationQueue.Len(),
+		Operations:  stats.operations,
+		Coalesced:   stats.coalesced,
+		BatchWrites: stats.batchWrites,
+		MaxLatency:  stats.maxLatency,
+	}
+	if stats.operations > 0 {
+		s.AvgLatency = stats.totalLatency / time.Duration(stats.operations)
+	}
+	stats.Unlock()
+	return s
+}
+
+type writeKey struct {
+	typeName string
+	entityID common.EntityID
+}
+
+// pendingWrite is the coalesced saves & updates of one entity
+type pendingWrite struct {
+	writeKey
+	isSave          bool // data is fully saved if true, otherwise updates are applied
+	data            interface{}
+	updates         []storagecommon.AttrUpdate
+	saveCallbacks   []SaveCallbackFunc
+	updateCallbacks []UpdateCallbackFunc
+	queueTimes      []time.Time
+}
+
+// writeBatcher coalesces pending saves & updates of the same entity, and writes them in batch
+type writeBatcher struct {
+	writes map[writeKey]*pendingWrite
+	order  []*pendingWrite
+}
+
+func newWriteBatcher() *writeBatcher {
+	return &writeBatcher{
+		writes: map[writeKey]*pendingWrite{},
+	}
+}
+
+// add adds save or update operation to the batch
+//
+// returns false if the operation can not be batched, in which case the operation should be handled after flush
+func (wb *writeBatcher) add(op queuedOperation) bool {
+	switch req := op.op.(type) {
+	case saveRequest:
+		w := wb.getPendingWrite(writeKey{req.TypeName, req.EntityID})
+		if w.isSave || w.updates != nil {
+			// entity data is saved fully, so previous writes are discarded
+			stats.recordCoalesced()
+		}
+		w.isSave = true
+		w.data = req.Data
+		w.updates = nil
+		if req.Callback != nil {
+			w.saveCallbacks = append(w.saveCallbacks, req.Callback)
+		}
+		w.queueTimes = append(w.queueTimes, op.queueTime)
+		return true
+	case updateRequest:
+		key := writeKey{req.TypeName, req.EntityID}
+		w := wb.writes[key]
+		if w == nil {
+			w = wb.getPendingWrite(key)
+			w.updates = append([]storagecommon.AttrUpdate{}, req.Updates...)
+		} else if w.isSave {
+			// apply updates to the pending saving data
+			data, ok := w.data.(map[string]interface{})
+			if !ok || storagecommon.ApplyUpdates(data, req.Updates) != nil {
+				return false
+			}
+			stats.recordCoalesced()
+		} else {
+			w.updates = append(w.updates, req.Updates...)
+			stats.recordCoalesced()
+		}
+		if req.Callback != nil {
+			w.updateCallbacks = append(w.updateCallbacks, req.Callback)
+		}
+		w.queueTimes = append(w.queueTimes, op.queueTime)
+		return true
+	default:
+		return false
+	}
+}
+
+func (wb *writeBatcher) getPendingWrite(key writeKey) *pendingWrite {
+	w := wb.writes[key]
+	if w == nil {
+		w = &pendingWrite{writeKey: key}
+		wb.writes[key] = w
+		wb.order = append(wb.order, w)
+	}
+	return w
+}
+
+// flush writes all pending writes to storage
+func (wb *writeBatcher) flush() {
+	if len(wb.order) == 0 {
+		return
+	}
+
+	var saves []*pendingWrite
+	for _, w := range wb.order {
+		if w.isSave {
+			saves = append(saves, w)
+		}
+	}
+
+	if len(saves) > 1 && wb.writeBatch(saves) {
+		for _, w := range saves {
+			w.finish()
+		}
+	} else {
+		for _, w := range saves {
+			handleSave(saveRequest{TypeName: w.typeName, EntityID: w.entityID, Data: w.data})
+			w.finish()
+		}
+	}
+
+	for _, w := range wb.order {
+		if w.isSave {
+			continue
+		}
+		updateReq := updateRequest{TypeName: w.typeName, EntityID: w.entityID, Updates: w.updates}
+		if callbacks := w.updateCallbacks; len(callbacks) > 0 {
+			updateReq.Callback = func(err error) {
+				for _, cb := range callbacks {
+					cb(err)
+				}
+			}
+		}
+		handleUpdate(updateReq) // update callbacks are called by handleUpdate
+		w.updateCallbacks = nil
+		w.finish()
+	}
+
+	wb.writes = map[writeKey]*pendingWrite{}
+	wb.order = nil
+}
+
+// writeBatch writes all saves in one bulk write, returns false if failed
+func (wb *writeBatcher) writeBatch(saves []*pendingWrite) bool {
+	if err := assureStorageEngineReady(); err != nil {
+		return false
+	}
+
+	monop := opmon.StartOperation("storage.writeBatch")
+	items := make([]storagecommon.WriteItem, len(saves))
+	for i, w := range saves {
+		items[i] = storagecommon.WriteItem{TypeName: w.typeName, EntityID: w.entityID, Data: w.data}
+	}
+	if consts.DEBUG_SAVE_LOAD {
+		gwlog.Debugf("storage: SAVING %d entities in batch ...", len(items))
+	}
+
+	err := storageEngine.WriteBatch(items)
+	if storagecommon.IsStaleWrite(err) {
+		// stale writes are rejected, and other entities are written
+		gwlog.Warnf("storage: batch write rejected: %s", err)
+	} else if err != nil {
+		gwlog.Errorf("storage: write %d entities in batch failed: %s", len(items), err)
+		if storageEngine.IsEOF(err) {
+			storageEngine.Close()
+			storageEngine = nil
+		}
+		return false
+	}
+
+	monop.Finish(time.Millisecond * 100)
+	stats.recordBatchWrite()
+	return true
+}
+
+// finish calls callbacks of the pending write and records operation stats
+func (w *pendingWrite) finish() {
+	saveCallbacks, updateCallbacks := w.saveCallbacks, w.updateCallbacks
+	if len(saveCallbacks) > 0 || len(updateCallbacks) > 0 {
+		post.Post(func() {
+			for _, cb := range saveCallbacks {
+				cb()
+			}
+			for _, cb := range updateCallbacks {
+				cb(nil)
+			}
+		})
+	}
+	for _, t := range w.queueTimes {
+		stats.recordOperation(t)
+	}
+}
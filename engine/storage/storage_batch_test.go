@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type recordingEntityStorage struct {
+	storagecommon.EntityStorage
+	writes  []storagecommon.WriteItem
+	batches int
+	updates map[common.EntityID][]storagecommon.AttrUpdate
+}
+
+func (es *recordingEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	es.writes = append(es.writes, storagecommon.WriteItem{TypeName: typeName, EntityID: entityID, Data: data})
+	return nil
+}
+
+func (es *recordingEntityStorage) WriteBatch(items []storagecommon.WriteItem) error {
+	es.batches++
+	es.writes = append(es.writes, items...)
+	return nil
+}
+
+func (es *recordingEntityStorage) Update(typeName string, entityID common.EntityID, updates []storagecommon.AttrUpdate) error {
+	es.updates[entityID] = append(es.updates[entityID], updates...)
+	return nil
+}
+
+func (es *recordingEntityStorage) IsEOF(err error) bool {
+	return false
+}
+
+func TestWriteBatcher(t *testing.T) {
+	es := &recordingEntityStorage{updates: map[common.EntityID][]storagecommon.AttrUpdate{}}
+	storageEngine = es
+	defer func() {
+		storageEngine = nil
+	}()
+
+	idA, idB, idC := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	saved, updated := 0, 0
+	onSave := func() { saved++ }
+	onUpdate := func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		updated++
+	}
+	ops := []interface{}{
+		saveRequest{TypeName: "Avatar", EntityID: idA, Data: map[string]interface{}{"level": 1}, Callback: onSave},
+		updateRequest{TypeName: "Avatar", EntityID: idA, Updates: []storagecommon.AttrUpdate{{Path: []interface{}{"exp"}, Value: 100}}, Callback: onUpdate},
+		saveRequest{TypeName: "Avatar", EntityID: idB, Data: map[string]interface{}{"level": 1}, Callback: onSave},
+		saveRequest{TypeName: "Avatar", EntityID: idB, Data: map[string]interface{}{"level": 2}, Callback: onSave},
+		updateRequest{TypeName: "Monster", EntityID: idC, Updates: []storagecommon.AttrUpdate{{Path: []interface{}{"hp"}, Value: 10}}, Callback: onUpdate},
+		updateRequest{TypeName: "Monster", EntityID: idC, Updates: []storagecommon.AttrUpdate{{Path: []interface{}{"hp"}, Value: 5}}, Callback: onUpdate},
+	}
+
+	coalesced := GetStats().Coalesced
+	wb := newWriteBatcher()
+	for _, op := range ops {
+		if !wb.add(queuedOperation{op, time.Now()}) {
+			t.Fatalf("operation should be batched: %v", op)
+		}
+	}
+	if wb.add(queuedOperation{loadRequest{TypeName: "Avatar", EntityID: idA}, time.Now()}) {
+		t.Fatalf("load should not be batched")
+	}
+	wb.flush()
+	post.Tick()
+
+	if es.batches != 1 || len(es.writes) != 2 {
+		t.Fatalf("saves should be written in one batch: batches=%d, writes=%v", es.batches, es.writes)
+	}
+	for _, w := range es.writes {
+		data := w.Data.(map[string]interface{})
+		if w.EntityID == idA && (data["level"] != 1 || data["exp"] != 100) {
+			t.Errorf("update is not applied to saving data: %v", data)
+		} else if w.EntityID == idB && data["level"] != 2 {
+			t.Errorf("the latest data should be saved: %v", data)
+		}
+	}
+	if updates := es.updates[idC]; len(updates) != 2 || updates[1].Value != 5 {
+		t.Errorf("updates should be coalesced in order: %v", updates)
+	}
+	if saved != 3 || updated != 3 {
+		t.Errorf("all callbacks should be called: saved=%d, updated=%d", saved, updated)
+	}
+	if n := GetStats().Coalesced - coalesced; n != 3 {
+		t.Errorf("coalesced count should be 3, but is %d", n)
+	}
+}
@@ -0,0 +1,61 @@
+package storagecommon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/typeconv"
+)
+
+// EpochKey is the key of owner epoch in entity data
+//
+// Each time an entity is loaded, the new owner takes a greater epoch than the stored one.
+// Writes with epoch less than the stored epoch come from a superseded owner and are rejected by entity storage.
+// Data without epoch is written without checking.
+const EpochKey = "_epoch"
+
+// SchemaVersionKey is the key of schema version of persistent attributes in entity data
+const SchemaVersionKey = "_SchemaVersion"
+
+// ErrStaleWrite is the cause of errors returned by entity storage when writing entity data of a superseded owner
+var ErrStaleWrite = errors.New("stale write rejected")
+
+// DataEpoch returns the owner epoch in entity data, or 0 if data has no epoch
+func DataEpoch(data interface{}) int64 {
+	m, ok := data.(map[string]interface{})
+	if !ok || m[EpochKey] == nil {
+		return 0
+	}
+	return typeconv.Int(m[EpochKey])
+}
+
+// UpdatesEpoch returns the owner epoch set in updates, or 0 if epoch is not updated
+func UpdatesEpoch(updates []AttrUpdate) int64 {
+	var epoch int64
+	for _, u := range updates {
+		if len(u.Path) == 1 && u.Path[0] == EpochKey && !u.Unset {
+			epoch = typeconv.Int(u.Value)
+		}
+	}
+	return epoch
+}
+
+// EpochUpdate returns the update to set owner epoch of entity data
+func EpochUpdate(epoch int64) AttrUpdate {
+	return AttrUpdate{Path: []interface{}{EpochKey}, Value: epoch}
+}
+
+// StaleWriteError returns the error of rejected writes
+func StaleWriteError(items ...WriteItem) error {
+	entities := make([]string, len(items))
+	for i, item := range items {
+		entities[i] = fmt.Sprintf("%s<%s> (epoch %d)", item.TypeName, item.EntityID, DataEpoch(item.Data))
+	}
+	return errors.Wrap(ErrStaleWrite, strings.Join(entities, ", "))
+}
+
+// IsStaleWrite returns if the error is caused by rejected stale writes
+func IsStaleWrite(err error) bool {
+	return err != nil && errors.Cause(err) == ErrStaleWrite
+}
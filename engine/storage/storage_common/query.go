@@ -0,0 +1,135 @@
+package storagecommon
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// QueryOp is the comparison operator of query condition
+type QueryOp string
+
+// Query operators
+const (
+	QueryEq  QueryOp = "=="
+	QueryLt  QueryOp = "<"
+	QueryLte QueryOp = "<="
+	QueryGt  QueryOp = ">"
+	QueryGte QueryOp = ">="
+)
+
+// QueryCond is a condition on an indexed attribute
+type QueryCond struct {
+	Attr  string
+	Op    QueryOp
+	Value interface{}
+}
+
+// QueryFilter is the filter of entity query, all conditions must be satisfied
+type QueryFilter []QueryCond
+
+// Eq returns the condition attr == val
+func Eq(attr string, val interface{}) QueryCond {
+	return QueryCond{attr, QueryEq, val}
+}
+
+// Lt returns the condition attr < val
+func Lt(attr string, val interface{}) QueryCond {
+	return QueryCond{attr, QueryLt, val}
+}
+
+// Lte returns the condition attr <= val
+func Lte(attr string, val interface{}) QueryCond {
+	return QueryCond{attr, QueryLte, val}
+}
+
+// Gt returns the condition attr > val
+func Gt(attr string, val interface{}) QueryCond {
+	return QueryCond{attr, QueryGt, val}
+}
+
+// Gte returns the condition attr >= val
+func Gte(attr string, val interface{}) QueryCond {
+	return QueryCond{attr, QueryGte, val}
+}
+
+// IndexValue converts attribute value to the value stored in index
+//
+// Numbers are converted to float64, strings are kept as is. Other values can not be indexed.
+func IndexValue(val interface{}) (interface{}, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return nil, false
+	}
+}
+
+// Validate checks if the query filter is valid, and converts condition values to index values
+func (filter QueryFilter) Validate() error {
+	if len(filter) == 0 {
+		return errors.Errorf("query filter is empty")
+	}
+
+	for i, cond := range filter {
+		switch cond.Op {
+		case QueryEq, QueryLt, QueryLte, QueryGt, QueryGte:
+		default:
+			return errors.Errorf("invalid query operator: %s", cond.Op)
+		}
+
+		val, ok := IndexValue(cond.Value)
+		if !ok {
+			return errors.Errorf("invalid query value of %s: %v", cond.Attr, cond.Value)
+		}
+		filter[i].Value = val
+	}
+	return nil
+}
+
+// IntersectEntityIDs returns entity IDs existing in all lists
+func IntersectEntityIDs(lists [][]common.EntityID) []common.EntityID {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	result := lists[0]
+	for _, list := range lists[1:] {
+		set := common.EntityIDSet{}
+		for _, eid := range list {
+			set.Add(eid)
+		}
+
+		var filtered []common.EntityID
+		for _, eid := range result {
+			if set.Contains(eid) {
+				filtered = append(filtered, eid)
+			}
+		}
+		result = filtered
+	}
+	return result
+}
@@ -6,8 +6,20 @@ import "github.com/xiaonanln/goworld/engine/common"
 type EntityStorage interface {
 	List(typeName string) ([]common.EntityID, error)
 	Write(typeName string, entityID common.EntityID, data interface{}) error
+	WriteBatch(items []WriteItem) error // write multiple entities in bulk
 	Read(typeName string, entityID common.EntityID) (interface{}, error)
+	Update(typeName string, entityID common.EntityID, updates []AttrUpdate) error // partially update existing entity data
 	Exists(typeName string, entityID common.EntityID) (bool, error)
+	Delete(typeName string, entityID common.EntityID) error               // deleting non-existing entity is not an error
+	EnsureIndex(typeName string, attr string) error                       // create index on attribute if not exists, and index existing entities
+	Query(typeName string, filter QueryFilter) ([]common.EntityID, error) // query entity IDs on indexed attributes
 	Close()
 	IsEOF(err error) bool
 }
+
+// WriteItem is the entity data to write in WriteBatch
+type WriteItem struct {
+	TypeName string
+	EntityID common.EntityID
+	Data     interface{}
+}
@@ -0,0 +1,379 @@
+// Package storagetest provides the common test suite for entity storage backends
+package storagetest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/typeconv"
+)
+
+// RequireServer skips the test if the database server at addr is not reachable
+func RequireServer(t testing.TB, addr string) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Skipf("database server %s is not reachable: %s", addr, err)
+	}
+	conn.Close()
+}
+
+// TestEntityStorage tests basic operations of entity storage backend
+func TestEntityStorage(t *testing.T, es storagecommon.EntityStorage) {
+	entityID := common.GenEntityID()
+	gwlog.Infof("TESTING ENTITYID: %s", entityID)
+	data, err := es.Read("Avatar", entityID)
+	if data != nil {
+		t.Errorf("should be nil")
+	}
+
+	exists, err := es.Exists("Avatar", entityID)
+	if err != nil || exists {
+		t.Errorf("should not exist: exists=%v, err=%v", exists, err)
+	}
+
+	testData := map[string]interface{}{
+		"a": 1,
+		"b": "2",
+		"c": true,
+		"d": 1.11,
+		"e": map[string]interface{}{
+			"f": []interface{}{1, "2"},
+		},
+	}
+	if err = es.Write("Avatar", entityID, testData); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err = es.Exists("Avatar", entityID)
+	if err != nil || !exists {
+		t.Errorf("should exist: exists=%v, err=%v", exists, err)
+	}
+
+	verifyData, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyMap := verifyData.(map[string]interface{})
+	if typeconv.Int(verifyMap["a"]) != 1 {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if verifyMap["b"].(string) != "2" {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if verifyMap["c"].(bool) != true {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if typeconv.Float(verifyMap["d"]) != 1.11 {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if f := verifyMap["e"].(map[string]interface{})["f"].([]interface{}); len(f) != 2 || f[1].(string) != "2" {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+
+	avatarIDs, err := es.List("Avatar")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(avatarIDs) == 0 {
+		t.Errorf("Avatar IDs is empty!")
+	}
+
+	found := false
+	for _, avatarID := range avatarIDs {
+		if avatarID == entityID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Avatar %s is not listed", entityID)
+	}
+
+	if err = es.Delete("Avatar", entityID); err != nil {
+		t.Fatal(err)
+	}
+	exists, err = es.Exists("Avatar", entityID)
+	if err != nil || exists {
+		t.Errorf("should not exist after delete: exists=%v, err=%v", exists, err)
+	}
+	avatarIDs, err = es.List("Avatar")
+	if err != nil {
+		t.Error(err)
+	}
+	for _, avatarID := range avatarIDs {
+		if avatarID == entityID {
+			t.Errorf("Avatar %s is listed after delete", entityID)
+		}
+	}
+	if err = es.Delete("Avatar", entityID); err != nil {
+		t.Errorf("delete non-existing entity should not fail: %s", err)
+	}
+
+	gwlog.Infof("Found avatars saved: %v", avatarIDs)
+	for _, avatarID := range avatarIDs {
+		data, err := es.Read("Avatar", avatarID)
+		if err != nil {
+			t.Error(err)
+		}
+		t.Logf("Read Avatar %s => %v", avatarID, data)
+	}
+}
+
+// TestEntityStorageQuery tests indexes and queries of entity storage backend
+func TestEntityStorageQuery(t *testing.T, es storagecommon.EntityStorage) {
+	typeName := "QueryAvatar" + string(common.GenEntityID())
+	ids := make([]common.EntityID, 5)
+	for i := range ids {
+		ids[i] = common.GenEntityID()
+		data := map[string]interface{}{
+			"name":  fmt.Sprintf("avatar%d", i),
+			"level": i + 1,
+			"guild": []string{"red", "blue"}[i%2],
+		}
+		if err := es.Write(typeName, ids[i], data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// existing entities should be indexed
+	for _, attr := range []string{"name", "level", "guild"} {
+		if err := es.EnsureIndex(typeName, attr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(filter storagecommon.QueryFilter, expected ...common.EntityID) {
+		t.Helper()
+		if err := filter.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		eids, err := es.Query(typeName, filter)
+		if err != nil {
+			t.Fatalf("query %v failed: %s", filter, err)
+		}
+		if len(eids) != len(expected) {
+			t.Fatalf("query %v should return %v, but returns %v", filter, expected, eids)
+		}
+		found := common.EntityIDSet{}
+		for _, eid := range eids {
+			found.Add(eid)
+		}
+		for _, eid := range expected {
+			if !found.Contains(eid) {
+				t.Fatalf("query %v should return %v, but returns %v", filter, expected, eids)
+			}
+		}
+	}
+
+	check(storagecommon.QueryFilter{storagecommon.Eq("name", "avatar2")}, ids[2])
+	check(storagecommon.QueryFilter{storagecommon.Eq("name", "nobody")})
+	check(storagecommon.QueryFilter{storagecommon.Gte("level", 4)}, ids[3], ids[4])
+	check(storagecommon.QueryFilter{storagecommon.Gt("level", 1), storagecommon.Lt("level", 4)}, ids[1], ids[2])
+	check(storagecommon.QueryFilter{storagecommon.Lte("level", 3), storagecommon.Eq("guild", "red")}, ids[0], ids[2])
+
+	// index should be updated when entity is written or deleted
+	if err := es.Write(typeName, ids[0], map[string]interface{}{"name": "renamed", "level": 10}); err != nil {
+		t.Fatal(err)
+	}
+	entityID := common.GenEntityID()
+	if err := es.Write(typeName, entityID, map[string]interface{}{"name": "avatar5", "level": 6, "guild": "red"}); err != nil {
+		t.Fatal(err)
+	}
+	check(storagecommon.QueryFilter{storagecommon.Eq("name", "avatar0")})
+	check(storagecommon.QueryFilter{storagecommon.Eq("name", "renamed")}, ids[0])
+	check(storagecommon.QueryFilter{storagecommon.Eq("guild", "red")}, ids[2], ids[4], entityID)
+	check(storagecommon.QueryFilter{storagecommon.Gt("level", 5)}, ids[0], entityID)
+
+	if err := es.Delete(typeName, ids[4]); err != nil {
+		t.Fatal(err)
+	}
+	check(storagecommon.QueryFilter{storagecommon.Eq("guild", "red")}, ids[2], entityID)
+}
+
+// TestEntityStorageUpdate tests partial updates of entity storage backend
+func TestEntityStorageUpdate(t *testing.T, es storagecommon.EntityStorage) {
+	entityID := common.GenEntityID()
+	if err := es.Write("Avatar", entityID, map[string]interface{}{
+		"a": 1,
+		"b": "2",
+		"m": map[string]interface{}{
+			"l": []interface{}{1, 2, 3},
+			"x": 1,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := es.Update("Avatar", entityID, []storagecommon.AttrUpdate{
+		{Path: []interface{}{"a"}, Value: 10},
+		{Path: []interface{}{"b"}, Unset: true},
+		{Path: []interface{}{"m", "l", 1}, Value: "two"},
+		{Path: []interface{}{"m", "y"}, Value: map[string]interface{}{"z": true}},
+		{Path: []interface{}{"m", "x"}, Unset: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := data.(map[string]interface{})
+	if typeconv.Int(m["a"]) != 10 {
+		t.Errorf("a should be updated: %v", m)
+	}
+	if _, ok := m["b"]; ok {
+		t.Errorf("b should be removed: %v", m)
+	}
+	sub := m["m"].(map[string]interface{})
+	if l := sub["l"].([]interface{}); len(l) != 3 || l[1] != "two" || typeconv.Int(l[2]) != 3 {
+		t.Errorf("m.l should be updated: %v", m)
+	}
+	if y := sub["y"].(map[string]interface{}); y["z"] != true {
+		t.Errorf("m.y should be set: %v", m)
+	}
+	if _, ok := sub["x"]; ok {
+		t.Errorf("m.x should be removed: %v", m)
+	}
+
+	if err := es.Delete("Avatar", entityID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEntityStorageWriteBatch tests writing entities in batch
+func TestEntityStorageWriteBatch(t *testing.T, es storagecommon.EntityStorage) {
+	typeNames := []string{"BatchAvatar" + string(common.GenEntityID()), "BatchMonster" + string(common.GenEntityID())}
+	var items []storagecommon.WriteItem
+	for i := 0; i < 6; i++ {
+		items = append(items, storagecommon.WriteItem{
+			TypeName: typeNames[i%2],
+			EntityID: common.GenEntityID(),
+			Data:     map[string]interface{}{"level": i},
+		})
+	}
+	if err := es.WriteBatch(items); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, item := range items {
+		data, err := es.Read(item.TypeName, item.EntityID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level := typeconv.Int(data.(map[string]interface{})["level"]); level != int64(i) {
+			t.Errorf("%s %s: read wrong data: %v", item.TypeName, item.EntityID, data)
+		}
+	}
+
+	for _, typeName := range typeNames {
+		ids, err := es.List(typeName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 3 {
+			t.Errorf("%s: should list 3 entities, but got %v", typeName, ids)
+		}
+	}
+
+	// overwrite existing entities in batch
+	for i := range items {
+		items[i].Data = map[string]interface{}{"level": i + 100}
+	}
+	if err := es.WriteBatch(items); err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range items {
+		data, err := es.Read(item.TypeName, item.EntityID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level := typeconv.Int(data.(map[string]interface{})["level"]); level != int64(i+100) {
+			t.Errorf("%s %s: read wrong data after overwrite: %v", item.TypeName, item.EntityID, data)
+		}
+	}
+}
+
+// TestEntityStorageEpoch tests rejecting stale writes by owner epoch
+func TestEntityStorageEpoch(t *testing.T, es storagecommon.EntityStorage) {
+	typeName := "EpochAvatar"
+	entityID := common.GenEntityID()
+	write := func(epoch int64, level int) error {
+		return es.Write(typeName, entityID, map[string]interface{}{storagecommon.EpochKey: epoch, "level": level})
+	}
+	verify := func(epoch int64, level int) {
+		data, err := es.Read(typeName, entityID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if storagecommon.DataEpoch(data) != epoch || typeconv.Int(data.(map[string]interface{})["level"]) != int64(level) {
+			t.Errorf("read wrong data: %v, should be epoch %d and level %d", data, epoch, level)
+		}
+	}
+
+	if err := write(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(1, 3); !storagecommon.IsStaleWrite(err) {
+		t.Errorf("stale write should be rejected, but got error %v", err)
+	}
+	verify(2, 2)
+
+	// the new owner takes a greater epoch
+	if err := es.Update(typeName, entityID, []storagecommon.AttrUpdate{storagecommon.EpochUpdate(3)}); err != nil {
+		t.Fatal(err)
+	}
+	verify(3, 2)
+	if err := es.Update(typeName, entityID, []storagecommon.AttrUpdate{storagecommon.EpochUpdate(2), {Path: []interface{}{"level"}, Value: 4}}); !storagecommon.IsStaleWrite(err) {
+		t.Errorf("stale update should be rejected, but got error %v", err)
+	}
+	verify(3, 2)
+
+	freshID := common.GenEntityID()
+	err := es.WriteBatch([]storagecommon.WriteItem{
+		{TypeName: typeName, EntityID: entityID, Data: map[string]interface{}{storagecommon.EpochKey: 2, "level": 5}},
+		{TypeName: typeName, EntityID: freshID, Data: map[string]interface{}{storagecommon.EpochKey: 1, "level": 1}},
+	})
+	if !storagecommon.IsStaleWrite(err) {
+		t.Errorf("stale write in batch should be rejected, but got error %v", err)
+	}
+	verify(3, 2)
+	if exists, err := es.Exists(typeName, freshID); err != nil || !exists {
+		t.Errorf("writes which are not stale should be written in batch: exists=%v, err=%v", exists, err)
+	}
+
+	for _, eid := range []common.EntityID{entityID, freshID} {
+		if err := es.Delete(typeName, eid); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestEntityStorageDeleteEpoch tests rejecting stale writes after entity is deleted
+func TestEntityStorageDeleteEpoch(t *testing.T, es storagecommon.EntityStorage) {
+	typeName := "EpochAvatar"
+	entityID := common.GenEntityID()
+	if err := es.Write(typeName, entityID, map[string]interface{}{storagecommon.EpochKey: 2, "level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Delete(typeName, entityID); err != nil {
+		t.Fatal(err)
+	}
+
+	// delayed save of a superseded owner should not bring the deleted entity back
+	if err := es.Write(typeName, entityID, map[string]interface{}{storagecommon.EpochKey: 1, "level": 2}); !storagecommon.IsStaleWrite(err) {
+		t.Errorf("stale write after delete should be rejected, but got error %v", err)
+	}
+	if exists, err := es.Exists(typeName, entityID); err != nil || exists {
+		t.Errorf("deleted entity should not exist: exists=%v, err=%v", exists, err)
+	}
+}
@@ -0,0 +1,130 @@
+package storagecommon
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// AttrUpdate is a partial update of entity data
+//
+// Path is the path of the attribute from root, which consists of string keys for maps and int indexes for lists.
+// The attribute is set to Value, or removed if Unset is true.
+type AttrUpdate struct {
+	Path  []interface{}
+	Value interface{}
+	Unset bool
+}
+
+// ApplyUpdates applies partial updates to entity data
+//
+// Missing maps in path are created, and lists are padded with nil if index is out of range.
+// Removing list item sets the item to nil.
+func ApplyUpdates(data map[string]interface{}, updates []AttrUpdate) error {
+	for _, u := range updates {
+		if len(u.Path) == 0 {
+			return errors.Errorf("empty update path")
+		}
+		if _, err := applyUpdate(data, u.Path, u); err != nil {
+			return errors.Wrapf(err, "apply update %v failed", u.Path)
+		}
+	}
+	return nil
+}
+
+// applyUpdate applies the update to the container at the remaining path, and returns the updated container
+func applyUpdate(container interface{}, path []interface{}, u AttrUpdate) (interface{}, error) {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		key, ok := path[0].(string)
+		if !ok {
+			return nil, errors.Errorf("map key must be string: %v", path[0])
+		}
+
+		if len(path) == 1 {
+			if u.Unset {
+				delete(c, key)
+			} else {
+				c[key] = u.Value
+			}
+			return c, nil
+		}
+
+		child, ok := c[key]
+		if !ok {
+			if u.Unset {
+				return c, nil
+			}
+			child = newContainer(path[1])
+		}
+		child, err := applyUpdate(child, path[1:], u)
+		if err != nil {
+			return nil, err
+		}
+		c[key] = child
+		return c, nil
+	case []interface{}:
+		index, ok := path[0].(int)
+		if !ok || index < 0 {
+			return nil, errors.Errorf("list index must be non-negative int: %v", path[0])
+		}
+
+		if index >= len(c) {
+			if u.Unset {
+				return c, nil
+			}
+			c = append(c, make([]interface{}, index+1-len(c))...)
+		}
+
+		if len(path) == 1 {
+			if u.Unset {
+				c[index] = nil
+			} else {
+				c[index] = u.Value
+			}
+			return c, nil
+		}
+
+		child := c[index]
+		if child == nil {
+			if u.Unset {
+				return c, nil
+			}
+			child = newContainer(path[1])
+		}
+		child, err := applyUpdate(child, path[1:], u)
+		if err != nil {
+			return nil, err
+		}
+		c[index] = child
+		return c, nil
+	default:
+		return nil, errors.Errorf("can not update in %T", container)
+	}
+}
+
+// newContainer creates the container for the path element
+func newContainer(key interface{}) interface{} {
+	if _, ok := key.(int); ok {
+		return []interface{}{}
+	}
+	return map[string]interface{}{}
+}
+
+// UpdateByRewrite updates entity data by reading, applying updates and writing back
+//
+// It is used by backends which can not update entity data partially
+func UpdateByRewrite(es EntityStorage, typeName string, entityID common.EntityID, updates []AttrUpdate) error {
+	data, err := es.Read(typeName, entityID)
+	if err != nil {
+		return err
+	}
+
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("%s %s has invalid data: %T", typeName, entityID, data)
+	}
+	if err := ApplyUpdates(m, updates); err != nil {
+		return err
+	}
+	return es.Write(typeName, entityID, m)
+}
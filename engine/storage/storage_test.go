@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// eofEntityStorage fails the first read with io.EOF, as if the connection is lost
+type eofEntityStorage struct {
+	storagecommon.EntityStorage
+	rec *eofRecord
+}
+
+type eofRecord struct {
+	opened  int
+	closed  int
+	readEOF bool
+	writes  []common.EntityID
+}
+
+func (es *eofEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	es.rec.writes = append(es.rec.writes, entityID)
+	return nil
+}
+
+func (es *eofEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	if !es.rec.readEOF {
+		es.rec.readEOF = true
+		return nil, io.EOF
+	}
+	return map[string]interface{}{}, nil
+}
+
+func (es *eofEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return true, nil
+}
+
+func (es *eofEntityStorage) Close() {
+	es.rec.closed++
+}
+
+func (es *eofEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF
+}
+
+func TestStorageRoutineReopensInBatch(t *testing.T) {
+	rec := &eofRecord{}
+	openStorageEngine = func() (storagecommon.EntityStorage, error) {
+		rec.opened++
+		return &eofEntityStorage{rec: rec}, nil
+	}
+	storageEngine = nil
+
+	idA, idB := common.GenEntityID(), common.GenEntityID()
+	var loadErr error
+	saved, exists := 0, false
+	// all operations are handled in one batch, and the load closes the storage engine by EOF
+	Save("Avatar", idA, map[string]interface{}{}, func() { saved++ })
+	Load("Avatar", common.GenEntityID(), func(data interface{}, err error) { loadErr = err })
+	Exists("Avatar", common.GenEntityID(), func(ok bool, err error) { exists = ok && err == nil })
+	Save("Avatar", idB, map[string]interface{}{}, func() { saved++ })
+
+	go storageRoutine()
+	Shutdown()
+	post.Tick()
+
+	if loadErr != io.EOF {
+		t.Errorf("load should fail with EOF, but err=%v", loadErr)
+	}
+	if !exists {
+		t.Errorf("exists should succeed after storage engine is reopened")
+	}
+	if rec.opened != 2 || rec.closed != 2 {
+		t.Errorf("storage engine should be reopened once: opened=%d, closed=%d", rec.opened, rec.closed)
+	}
+	if saved != 2 || len(rec.writes) != 2 || rec.writes[0] != idA || rec.writes[1] != idB {
+		t.Errorf("all saves should be written: saved=%d, writes=%v", saved, rec.writes)
+	}
+}
+
+// queryEntityStorage returns entity data with epoch and schema version, unless entity is deleted after queried
+type queryEntityStorage struct {
+	storagecommon.EntityStorage
+	eids    []common.EntityID
+	deleted common.EntityID
+	nilData common.EntityID
+}
+
+func (es *queryEntityStorage) Query(typeName string, filter storagecommon.QueryFilter) ([]common.EntityID, error) {
+	return es.eids, nil
+}
+
+func (es *queryEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	if entityID == es.deleted {
+		return nil, errors.Errorf("%s %s not found", typeName, entityID)
+	} else if entityID == es.nilData {
+		return nil, nil
+	}
+	return map[string]interface{}{storagecommon.EpochKey: 3, storagecommon.SchemaVersionKey: 2, "level": 5}, nil
+}
+
+func (es *queryEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return entityID != es.deleted, nil
+}
+
+func TestQueryStripsStorageKeys(t *testing.T) {
+	eid := common.GenEntityID()
+	storageEngine = &queryEntityStorage{eids: []common.EntityID{eid}}
+	indexedAttrs["QueryAvatar"] = common.StringSet{}
+	indexedAttrs["QueryAvatar"].Add("level")
+
+	results, err := handleQuery(queryRequest{TypeName: "QueryAvatar", Filter: storagecommon.QueryFilter{storagecommon.Eq("level", 5)}, WithData: true})
+	if err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if len(results) != 1 || results[0].EntityID != eid || len(results[0].Data) != 1 || results[0].Data["level"] != 5 {
+		t.Errorf("query should return entity data without epoch and schema version: %v", results)
+	}
+}
+
+func TestQuerySkipsDeletedEntities(t *testing.T) {
+	eid, deleted, nilData := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	storageEngine = &queryEntityStorage{eids: []common.EntityID{deleted, eid, nilData}, deleted: deleted, nilData: nilData}
+	indexedAttrs["QueryAvatar"] = common.StringSet{}
+	indexedAttrs["QueryAvatar"].Add("level")
+
+	results, err := handleQuery(queryRequest{TypeName: "QueryAvatar", Filter: storagecommon.QueryFilter{storagecommon.Eq("level", 5)}, WithData: true})
+	if err != nil {
+		t.Fatalf("query should not fail if entities are deleted after queried: %s", err)
+	}
+	if len(results) != 1 || results[0].EntityID != eid {
+		t.Errorf("query should skip deleted entities: %v", results)
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gateauth"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/typeconv"
 	"github.com/xtaci/kcp-go"
 	"golang.org/x/net/websocket"
 )
@@ -55,9 +57,14 @@ type ClientBot struct {
 	useWebSocket       bool
 	noEntitySync       bool
 	packetQueue        chan *pktconn.Packet
+	gateConfig         *config.GateConfig
+	recvDone           chan struct{} // closed when receiving from the current connection stops
+	resumeSessions     bool          // reconnect randomly and resume sessions
+	sessionToken       string        // token for resuming session after reconnecting
+	recvSeq            uint32        // number of session messages received in the session
 }
 
-func newClientBot(id int, useWebSocket bool, useKCP bool, noEntitySync bool, waiter *sync.WaitGroup, waitAllConnected *sync.WaitGroup) *ClientBot {
+func newClientBot(id int, useWebSocket bool, useKCP bool, noEntitySync bool, resumeSessions bool, waiter *sync.WaitGroup, waitAllConnected *sync.WaitGroup) *ClientBot {
 	return &ClientBot{
 		id:               id,
 		waiter:           waiter,
@@ -66,6 +73,7 @@ func newClientBot(id int, useWebSocket bool, useKCP bool, noEntitySync bool, wai
 		useKCP:           useKCP,
 		useWebSocket:     useWebSocket,
 		noEntitySync:     noEntitySync,
+		resumeSessions:   resumeSessions,
 		packetQueue:      make(chan *pktconn.Packet),
 	}
 }
@@ -83,8 +91,21 @@ func (bot *ClientBot) run() {
 	// choose a random gateid
 	gateid := uint16(rand.Intn(desiredGates) + 1)
 	gwlog.Debugf("%s is connecting to gate %d", bot, gateid)
-	cfg := config.GetGate(gateid)
+	bot.gateConfig = config.GetGate(gateid)
+	bot.connectGate()
+	defer func() {
+		bot.conn.Close()
+	}()
+
+	bot.waitAllConnected.Done()
 
+	bot.waitAllConnected.Wait()
+	bot.loop()
+}
+
+// connectGate connects to the gate, and resumes the session if the bot is in session
+func (bot *ClientBot) connectGate() {
+	cfg := bot.gateConfig
 	var netconn net.Conn
 	var err error
 	for { // retry for ever
@@ -108,18 +129,43 @@ func (bot *ClientBot) run() {
 	}
 	conn = netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE)
 	bot.conn = proto.NewGoWorldConnection(conn, nil)
-	defer bot.conn.Close()
 
 	if bot.useKCP {
 		gwlog.Infof("Notify KCP connected ...")
 		bot.conn.SetHeartbeatFromClient()
 	}
 
-	go bot.recvLoop()
-	bot.waitAllConnected.Done()
+	if bot.sessionToken != "" {
+		bot.conn.SendResumeSessionFromClient(bot.sessionToken, bot.recvSeq)
+	} else if cfg.AuthType == "hmac" {
+		// generate the ticket locally, which should be generated by login servers in real games
+		ticket := gateauth.GenHMACTicket(cfg.AuthSecret, fmt.Sprintf("bot%d", bot.id), time.Now().Add(time.Minute))
+		bot.conn.SendAuthFromClient(ticket)
+	} else if cfg.SessionGracePeriod > 0 {
+		bot.conn.SendResumeSessionFromClient("", 0) // start a new session
+	}
 
-	bot.waitAllConnected.Wait()
-	bot.loop()
+	bot.recvDone = make(chan struct{})
+	go bot.recvLoop(bot.conn, bot.recvDone)
+}
+
+// reconnectGate closes the connection and resumes the session with a new connection
+func (bot *ClientBot) reconnectGate() {
+	gwlog.Infof("%s: reconnecting to resume session after %d messages received ...", bot, bot.recvSeq)
+	bot.conn.Close()
+	// packets received before the connection is closed are handled and counted before resuming
+	for {
+		select {
+		case _pkt := <-bot.packetQueue:
+			pkt := (*netutil.Packet)(_pkt)
+			bot.handlePacket(pkt)
+			pkt.Release()
+			continue
+		case <-bot.recvDone:
+		}
+		break
+	}
+	bot.connectGate()
 }
 
 func (bot *ClientBot) connectServer(cfg *config.GateConfig) (net.Conn, error) {
@@ -192,9 +238,12 @@ func (bot *ClientBot) connectServerByWebsocket(cfg *config.GateConfig) (net.Conn
 	}
 }
 
-func (bot *ClientBot) recvLoop() {
-	err := bot.conn.RecvChan(bot.packetQueue)
-	gwlog.Error(err)
+func (bot *ClientBot) recvLoop(conn *proto.GoWorldConnection, done chan struct{}) {
+	err := conn.RecvChan(bot.packetQueue)
+	if !conn.IsClosed() {
+		gwlog.Error(err)
+	}
+	close(done)
 }
 
 func (bot *ClientBot) loop() {
@@ -227,6 +276,9 @@ func (bot *ClientBot) loop() {
 				}
 
 			}
+			if bot.resumeSessions && bot.sessionToken != "" && rand.Float32() < 0.01 {
+				bot.reconnectGate()
+			}
 			post.Tick()
 			break
 		}
@@ -245,6 +297,9 @@ func (bot *ClientBot) handlePacket(packet *netutil.Packet) {
 	defer bot.Unlock()
 
 	msgtype := packet.ReadUint16()
+	if bot.sessionToken != "" && proto.IsSessionMsgType(proto.MsgType(msgtype)) {
+		bot.recvSeq += 1
+	}
 
 	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
 		_ = packet.ReadUint16()
@@ -299,6 +354,13 @@ func (bot *ClientBot) handlePacket(packet *netutil.Packet) {
 		packet.ReadData(&path)
 		//gwlog.Infof("Entity %s Attribute %v: pop", entityID, path)
 		bot.applyListAttrPop(entityID, path)
+	} else if msgtype == proto.MT_NOTIFY_ATTR_CHANGES_ON_CLIENT {
+		for packet.HasUnreadPayload() {
+			entityID := packet.ReadEntityID()
+			var changes [][]interface{}
+			packet.ReadData(&changes)
+			bot.applyAttrChanges(entityID, changes)
+		}
 	} else if msgtype == proto.MT_CREATE_ENTITY_ON_CLIENT {
 		isPlayer := packet.ReadBool()
 		entityID := packet.ReadEntityID()
@@ -360,6 +422,19 @@ func (bot *ClientBot) handlePacket(packet *netutil.Packet) {
 			bot.updateEntityPosition(entityID, entity.Vector3{x, y, z})
 			bot.updateEntityYaw(entityID, yaw)
 		}
+	} else if msgtype == proto.MT_SET_SESSION_TOKEN_ON_CLIENT {
+		bot.sessionToken = packet.ReadVarStr()
+		bot.recvSeq = 0
+	} else if msgtype == proto.MT_RESUME_SESSION_RESULT_ON_CLIENT {
+		if !packet.ReadBool() {
+			gwlog.Errorf("%s: resume session failed", bot)
+		}
+	} else if msgtype == proto.MT_AUTH_RESULT_ON_CLIENT {
+		ok := packet.ReadBool()
+		errmsg := packet.ReadVarStr()
+		if !ok {
+			gwlog.Errorf("%s: authentication failed: %s", bot, errmsg)
+		}
 	} else {
 		gwlog.Panicf("unknown msgtype: %v", msgtype)
 	}
@@ -441,6 +516,44 @@ func (bot *ClientBot) applyListAttrPop(entityID common.EntityID, path []interfac
 	entity.applyListAttrPop(path)
 }
 
+func (bot *ClientBot) applyAttrChanges(entityID common.EntityID, changes [][]interface{}) {
+	if bot.entities[entityID] == nil {
+		Errorf("%s: entity %s not found", bot, entityID)
+		return
+	}
+	entity := bot.entities[entityID]
+	for _, change := range changes {
+		var path []interface{}
+		if change[1] != nil {
+			path = change[1].([]interface{})
+		}
+		switch proto.AttrChangeOp(typeconv.Int(change[0])) {
+		case proto.ATTR_CHANGE_MAP_SET:
+			entity.applyMapAttrChange(path, change[2].(string), change[3])
+		case proto.ATTR_CHANGE_MAP_DEL:
+			entity.applyMapAttrDel(path, change[2].(string))
+		case proto.ATTR_CHANGE_MAP_CLEAR:
+			entity.applyMapAttrClear(path)
+		case proto.ATTR_CHANGE_LIST_SET:
+			entity.applyListAttrChange(path, int(typeconv.Int(change[2])), change[3])
+		case proto.ATTR_CHANGE_LIST_APPEND:
+			entity.applyListAttrAppend(path, change[2])
+		case proto.ATTR_CHANGE_LIST_POP:
+			entity.applyListAttrPop(path)
+		case proto.ATTR_CHANGE_LIST_INSERT:
+			entity.applyListAttrInsert(path, int(typeconv.Int(change[2])), change[3])
+		case proto.ATTR_CHANGE_LIST_REMOVE:
+			entity.applyListAttrRemove(path, int(typeconv.Int(change[2])))
+		case proto.ATTR_CHANGE_LIST_SWAP:
+			entity.applyListAttrSwap(path, int(typeconv.Int(change[2])), int(typeconv.Int(change[3])))
+		case proto.ATTR_CHANGE_LIST_TRUNCATE:
+			entity.applyListAttrTruncate(path, int(typeconv.Int(change[2])))
+		default:
+			Errorf("%s: unknown attribute change: %v", bot, change)
+		}
+	}
+}
+
 func (bot *ClientBot) createEntity(typeName string, entityID common.EntityID, isPlayer bool, clientData map[string]interface{}, x, y, z entity.Coord, yaw entity.Yaw) {
 	gwlog.Debugf("%s: create entity %s<%s>, isPlayer=%v", bot, typeName, entityID, isPlayer)
 	if bot.entities[entityID] == nil {
@@ -496,6 +496,66 @@ func (e *clientEntity) applyListAttrPop(path []interface{}) {
 	e.onAttrChange(path, "")
 }
 
+func (e *clientEntity) applyListAttrInsert(path []interface{}, index int, val interface{}) {
+	_attr, parent, pkey := e.findAttrByPath(path)
+	attr := _attr.([]interface{})
+	if index > len(attr) {
+		gwlog.Panicf("%s: ListAttr insert error: list size is %d, index = %d, path=%s, attr=%#v", e, len(attr), index, path, attr)
+		return
+	}
+
+	attr = append(attr, nil)
+	copy(attr[index+1:], attr[index:])
+	attr[index] = val
+	e.replaceListAttr(parent, pkey, attr)
+	e.onAttrChange(path, "")
+}
+
+func (e *clientEntity) applyListAttrRemove(path []interface{}, index int) {
+	_attr, parent, pkey := e.findAttrByPath(path)
+	attr := _attr.([]interface{})
+	if index >= len(attr) {
+		gwlog.Panicf("%s: ListAttr remove error: list size is %d, index = %d, path=%s, attr=%#v", e, len(attr), index, path, attr)
+		return
+	}
+
+	attr = append(attr[:index], attr[index+1:]...)
+	e.replaceListAttr(parent, pkey, attr)
+	e.onAttrChange(path, "")
+}
+
+func (e *clientEntity) applyListAttrSwap(path []interface{}, i, j int) {
+	_attr, _, _ := e.findAttrByPath(path)
+	attr := _attr.([]interface{})
+	if i >= len(attr) || j >= len(attr) {
+		gwlog.Panicf("%s: ListAttr swap error: list size is %d, i = %d, j = %d, path=%s, attr=%#v", e, len(attr), i, j, path, attr)
+		return
+	}
+
+	attr[i], attr[j] = attr[j], attr[i]
+	e.onAttrChange(path, "")
+}
+
+func (e *clientEntity) applyListAttrTruncate(path []interface{}, size int) {
+	_attr, parent, pkey := e.findAttrByPath(path)
+	attr := _attr.([]interface{})
+	if size < len(attr) {
+		e.replaceListAttr(parent, pkey, attr[:size])
+	}
+	e.onAttrChange(path, "")
+}
+
+// replaceListAttr replaces the list in parent after the list is resized
+func (e *clientEntity) replaceListAttr(parent interface{}, pkey interface{}, attr []interface{}) {
+	if parentmap, ok := parent.(map[string]interface{}); ok {
+		parentmap[pkey.(string)] = attr
+	} else if parentlist, ok := parent.([]interface{}); ok {
+		parentlist[pkey.(int64)] = attr
+	} else {
+		gwlog.Panicf("parent type is %T", parent)
+	}
+}
+
 func (e *clientEntity) onAttrChange(path []interface{}, key string) {
 	var rootkey string
 	if len(path) > 0 {
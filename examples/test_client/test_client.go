@@ -27,6 +27,7 @@ var (
 	numClients    int
 	startClientId int
 	noEntitySync  bool
+	resume        bool
 	strictMode    bool
 	duration      int
 	loglevel      string
@@ -41,6 +42,7 @@ func parseArgs() {
 	flag.BoolVar(&useWebSocket, "ws", false, "use WebSocket to connect server")
 	flag.BoolVar(&useKCP, "kcp", false, "use KCP to connect server")
 	flag.BoolVar(&noEntitySync, "nosync", false, "disable entity sync")
+	flag.BoolVar(&resume, "resume", false, "reconnect randomly and resume sessions (session_grace_period should be enabled)")
 	flag.BoolVar(&strictMode, "strict", false, "enable strict mode")
 	flag.IntVar(&duration, "duration", 0, "run for a specified duration (seconds)")
 	flag.StringVar(&loglevel, "log", "info", "set log level (info by default)")
@@ -71,7 +73,7 @@ func main() {
 	wait.Add(numClients)
 	waitAllConnected.Add(numClients)
 	for i := 0; i < numClients; i++ {
-		bot := newClientBot(startClientId+i, useWebSocket, useKCP, noEntitySync, &wait, &waitAllConnected)
+		bot := newClientBot(startClientId+i, useWebSocket, useKCP, noEntitySync, resume, &wait, &waitAllConnected)
 		go bot.run()
 	}
 	timer.StartTicks(time.Millisecond * 100)
@@ -47,6 +47,20 @@ func (a *Account) Login_Client(username string, password string) {
 		return
 	}
 
+	a.login(username)
+}
+
+// OnClientConnected is called when client is connected to Account
+//
+// If clients are authenticated by gate, login with the identity directly
+func (a *Account) OnClientConnected() {
+	if identity := a.GetClient().Identity(); identity != "" {
+		gwlog.Infof("%s logining with identity %s ...", a, identity)
+		a.login(identity)
+	}
+}
+
+func (a *Account) login(username string) {
 	a.logining = true
 	a.CallClient("OnLogin", true)
 	a.getAvatarID(username, func(avatarID common.EntityID, err error) {
@@ -1,6 +1,9 @@
 package gwmongo
 
 import (
+	"fmt"
+	"net"
+	"os"
 	"testing"
 
 	"time"
@@ -15,6 +18,17 @@ import (
 var wait sync.WaitGroup
 var mongodb *DB
 
+func TestMain(m *testing.M) {
+	// tests depend on each other, so all of them are skipped if MongoDB is not available
+	conn, err := net.DialTimeout("tcp", "localhost:27017", time.Second)
+	if err != nil {
+		fmt.Printf("MongoDB is not reachable, tests skipped: %s\n", err)
+		os.Exit(0)
+	}
+	conn.Close()
+	os.Exit(m.Run())
+}
+
 func TestDial(t *testing.T) {
 	wait.Add(1)
 	Dial("mongodb://localhost:27017/", "goworld", func(res interface{}, err error) {
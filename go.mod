@@ -8,12 +8,14 @@ require (
 	github.com/garyburd/redigo v1.6.0
 	github.com/go-ini/ini v1.67.0
 	github.com/go-ole/go-ole v1.2.6
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/petar/GoLLRB v0.0.0-20190514000832-33fb24c13b99
 	github.com/pkg/errors v0.9.1
 	github.com/sevlyar/go-daemon v0.1.6
 	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/vmihailenco/msgpack v4.0.4+incompatible
-	github.com/xiaonanln/go-aoi v0.2.0
 	github.com/xiaonanln/go-trie-tst v0.0.0-20171018095208-5b9678d55438
 	github.com/xiaonanln/go-xnsyncutil v0.0.5
 	github.com/xiaonanln/goTimer v0.0.3
@@ -21,9 +23,10 @@ require (
 	github.com/xiaonanln/pktconn v0.0.0-20200905130536-8a9529b7c220
 	github.com/xiaonanln/typeconv v0.0.4
 	github.com/xtaci/kcp-go v5.4.19+incompatible
+	go.etcd.io/bbolt v1.3.7
 	go.uber.org/zap v1.22.0
 	golang.org/x/net v0.0.0-20220812174116-3211cb980234
-	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab
+	golang.org/x/sys v0.4.0
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
 
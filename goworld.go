@@ -12,6 +12,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/service"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 )
 
 // Export useful types
@@ -27,6 +28,22 @@ type Space = entity.Space
 // EntityID is unique in the whole game server, and also unique across multiple games.
 type EntityID = common.EntityID
 
+// Attribute fields which can be declared in entity struct with tag `goworld:"name,flags..."`, see entity.IntField
+type (
+	IntField   = entity.IntField
+	FloatField = entity.FloatField
+	BoolField  = entity.BoolField
+	StrField   = entity.StrField
+	MapField   = entity.MapField
+	ListField  = entity.ListField
+)
+
+// QueryFilter is the filter of QueryEntities, conditions can be created by storagecommon.Eq, storagecommon.Gte, etc.
+type QueryFilter = storagecommon.QueryFilter
+
+// ErrCallTimeout is the error given to callback of CallWithReply if the reply does not arrive in time
+var ErrCallTimeout = entity.ErrCallTimeout
+
 // Run runs the server endless loop
 //
 // This is the main routine for the server and all entity logic,
@@ -108,6 +125,20 @@ func Exists(typeName string, entityID EntityID, callback storage.ExistsCallbackF
 	storage.Exists(typeName, entityID, callback)
 }
 
+// DeleteEntityData deletes entity data from entity storage
+//
+// The entity should not be alive when deleting, use Entity.DestroyAndDelete to destroy and delete an alive entity
+func DeleteEntityData(typeName string, entityID EntityID, callback storage.DeleteCallbackFunc) {
+	storage.Delete(typeName, entityID, callback)
+}
+
+// QueryEntities queries persisted entities on indexed attributes, e.g. QueryEntities("Avatar", QueryFilter{storagecommon.Eq("name", name)}, false, cb)
+//
+// Attributes must be defined with "Indexed" for querying. Entity data is also returned in callback if withData is true.
+func QueryEntities(typeName string, filter QueryFilter, withData bool, callback storage.QueryCallbackFunc) {
+	storage.Query(typeName, filter, withData, callback)
+}
+
 // GetEntity gets the entity by EntityID
 func GetEntity(id EntityID) *Entity {
 	return entity.GetEntity(id)
@@ -153,6 +184,13 @@ func Call(id EntityID, method string, args ...interface{}) {
 	entity.Call(id, method, args)
 }
 
+// CallWithReply calls other entities and receives the return values of the method in callback
+//
+// callback is called with ErrCallTimeout if the reply does not arrive in timeout
+func CallWithReply(id EntityID, method string, timeout time.Duration, callback entity.CallReplyCallback, args ...interface{}) {
+	entity.CallWithReply(id, method, timeout, callback, args)
+}
+
 // CallServiceAny calls the method of a random service entity
 func CallServiceAny(serviceName string, method string, args ...interface{}) {
 	service.CallServiceAny(serviceName, method, args)
@@ -173,6 +211,11 @@ func CallServiceShardKey(serviceName string, shardKey string, method string, arg
 	service.CallServiceShardKey(serviceName, shardKey, method, args)
 }
 
+// CallServiceShardKeyWithReply calls the method of the service entity specified by shard key (string) and receives the return values of the method in callback
+func CallServiceShardKeyWithReply(serviceName string, shardKey string, method string, timeout time.Duration, callback entity.CallReplyCallback, args ...interface{}) {
+	service.CallServiceShardKeyWithReply(serviceName, shardKey, method, timeout, callback, args)
+}
+
 // GetServiceEntityID returns the entityid of the service
 func GetServiceEntityID(serviceName string, shardIndex int) common.EntityID {
 	return service.GetServiceEntityID(serviceName, shardIndex)
@@ -222,6 +265,41 @@ func GetOrPutKVDB(key string, val string, callback kvdb.KVDBGetOrPutCallback) {
 	kvdb.GetOrPut(key, val, callback)
 }
 
+// PutKVDBWithTTL puts key-value which expires after ttl to KVDB
+func PutKVDBWithTTL(key string, val string, ttl time.Duration, callback kvdb.KVDBPutCallback) {
+	kvdb.PutWithTTL(key, val, ttl, callback)
+}
+
+// DeleteKVDB deletes key from KVDB
+func DeleteKVDB(key string, callback kvdb.KVDBDeleteCallback) {
+	kvdb.Delete(key, callback)
+}
+
+// CompareAndSwapKVDB sets key to newVal in KVDB if the current value is oldVal
+func CompareAndSwapKVDB(key string, oldVal string, newVal string, callback kvdb.KVDBCompareAndSwapCallback) {
+	kvdb.CompareAndSwap(key, oldVal, newVal, callback)
+}
+
+// IncrKVDB adds delta to the integer value of key in KVDB
+func IncrKVDB(key string, delta int64, callback kvdb.KVDBIncrCallback) {
+	kvdb.Incr(key, delta, callback)
+}
+
+// GetKVDBRangePage gets one page of key-value items in range [beginKey, endKey) from KVDB, callback receives the cursor of next page
+func GetKVDBRangePage(beginKey string, endKey string, opts kvdb.KVDBRangeOptions, callback kvdb.KVDBGetRangePageCallback) {
+	kvdb.GetRangePage(beginKey, endKey, opts, callback)
+}
+
+// NewKVDBBatch creates a KVDB batch, whose puts, deletes and conditions are executed atomically when committed
+func NewKVDBBatch() *kvdb.Batch {
+	return kvdb.NewBatch()
+}
+
+// WatchKVDB watches changes of KVDB keys with prefix, returns the function to stop watching
+func WatchKVDB(prefix string, callback kvdb.KVDBWatchCallback) (unwatch func()) {
+	return kvdb.Watch(prefix, callback)
+}
+
 // GetOnlineGames returns all online game IDs
 func GetOnlineGames() common.Uint16Set {
 	return game.GetOnlineGames()